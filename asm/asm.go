@@ -0,0 +1,431 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package asm
+
+import (
+	"bufio"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/system"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/* An assembler for the text the IR instructions serialize to, so VM
+ * behaviour can be written by hand without the front-end:
+ *
+ *	loop:	lt	%1,BP(-0x1)
+ *		jmpf	%1,done
+ *		add	%0,1
+ *		goto	loop
+ *	done:	exit	%0
+ *
+ * Each line holds at most one instruction: a mnemonic and its comma
+ * separated operands, optionally preceded by labels ending in ':'. Jump,
+ * call and closure targets are labels or absolute 0x offsets. Constants
+ * are written as literals: decimal numbers and double quoted strings.
+ * A '#' outside a string starts a comment. */
+
+type reference struct {
+	line     int
+	label    string
+	location *ir.InstructionLocation
+}
+
+type assembler struct {
+	line   int
+	instrs []ir.Instruction
+	lines  []int //Source line of each instruction
+	labels map[string]int
+	refs   []reference
+	pool   *ir.ConstantPool
+	e      err.Error
+}
+
+var binops = map[string]ir.InstructionType{
+	"sub":  ir.SUB,
+	"mult": ir.MULT,
+	"div":  ir.DIV,
+	"mod":  ir.MOD,
+	"lt":   ir.LT,
+	"lte":  ir.LTE,
+	"gt":   ir.GT,
+	"gte":  ir.GTE,
+	"eq":   ir.EQ,
+	"neq":  ir.NEQ,
+	"and":  ir.AND,
+	"or":   ir.OR,
+}
+
+/* Assemble a program, resolving labels once every line has been read and
+ * then validating the instructions */
+func Assemble(r io.Reader) ([]ir.Instruction, err.Error) {
+	a := &assembler{labels: map[string]int{}, pool: ir.NewConstantPool()}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		a.line++
+		a.assembleLine(scanner.Text())
+		if a.e != nil {
+			return nil, a.e
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		return nil, err.NewLoadError("Reading assembly: " + e.Error())
+	}
+
+	for _, ref := range a.refs {
+		if offset, ok := a.labels[ref.label]; ok {
+			ref.location.SetLocation(offset)
+		} else {
+			a.line = ref.line
+			a.fail("Undefined label '" + ref.label + "'")
+			return nil, a.e
+		}
+	}
+	if offset, msg := ir.Check(a.instrs); offset >= 0 {
+		a.line = a.lines[offset]
+		a.fail(msg)
+		return nil, a.e
+	}
+	return a.instrs, nil
+}
+
+func (a *assembler) fail(msg string) {
+	if a.e == nil {
+		a.e = err.NewSyntaxError("[" + strconv.Itoa(a.line) + "]\t" + msg)
+	}
+}
+
+func (a *assembler) assembleLine(line string) {
+	line = strings.TrimSpace(stripComment(line))
+
+	/* Labels name the offset of the next instruction */
+	for line != "" {
+		fields := strings.Fields(line)
+		if !strings.HasSuffix(fields[0], ":") {
+			break
+		}
+		label := strings.TrimSuffix(fields[0], ":")
+		if !isLabel(label) {
+			a.fail("Invalid label '" + label + "'")
+			return
+		} else if _, ok := a.labels[label]; ok {
+			a.fail("Label '" + label + "' is already defined")
+			return
+		}
+		a.labels[label] = len(a.instrs)
+		line = strings.TrimSpace(line[len(fields[0]):])
+	}
+	if line == "" {
+		return
+	}
+
+	mnemonic, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		mnemonic, rest = line[:i], strings.TrimSpace(line[i:])
+	}
+	ops := splitOperands(rest)
+	for _, op := range ops {
+		if op == "" {
+			a.fail("Empty operand for '" + mnemonic + "'")
+			return
+		}
+	}
+
+	if instr := a.instruction(mnemonic, ops); a.e == nil {
+		a.instrs = append(a.instrs, instr)
+		a.lines = append(a.lines, a.line)
+	}
+}
+
+func (a *assembler) expect(mnemonic string, ops []string, n int) bool {
+	if len(ops) != n {
+		a.fail("'" + mnemonic + "' takes " + strconv.Itoa(n) + " operands, got " + strconv.Itoa(len(ops)))
+		return false
+	}
+	return true
+}
+
+func (a *assembler) instruction(mnemonic string, ops []string) ir.Instruction {
+	if op, ok := binops[mnemonic]; ok {
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewBinOp(op, a.access(ops[0]), a.access(ops[1]))
+		}
+		return nil
+	}
+
+	switch mnemonic {
+	case "add":
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewAdd(a.access(ops[0]), a.access(ops[1]))
+		}
+	case "push":
+		if a.expect(mnemonic, ops, 1) {
+			return ir.NewPush(a.access(ops[0]))
+		}
+	case "release":
+		if a.expect(mnemonic, ops, 1) {
+			if m, ok := a.access(ops[0]).(*ir.MemoryAccess); ok {
+				return ir.NewRelease(m)
+			}
+			a.fail("'release' requires a memory address")
+		}
+	case "mov":
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewMov(a.access(ops[0]), a.access(ops[1]))
+		}
+	case "call":
+		if a.expect(mnemonic, ops, 3) {
+			return ir.NewCall(a.location(ops[0]), a.params(ops[1]), a.integer(ops[2]))
+		}
+	case "callr":
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewCallIndirect(a.access(ops[0]), a.integer(ops[1]))
+		}
+	case "closure":
+		if len(ops) < 3 {
+			a.fail("'closure' takes at least 3 operands, got " + strconv.Itoa(len(ops)))
+			return nil
+		}
+		captures := make([]ir.Accessor, len(ops)-3)
+		for i, op := range ops[3:] {
+			captures[i] = a.access(op)
+		}
+		return ir.NewClosure(a.access(ops[0]), a.location(ops[1]), a.params(ops[2]), captures...)
+	case "ret":
+		if a.expect(mnemonic, ops, 1) {
+			return ir.NewResult(a.access(ops[0]))
+		}
+	case "exit":
+		if a.expect(mnemonic, ops, 1) {
+			return ir.NewExit(a.access(ops[0]))
+		}
+	case "goto":
+		if a.expect(mnemonic, ops, 1) {
+			return ir.NewGoto(a.location(ops[0]))
+		}
+	case "jmpf":
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewJumpUnless(a.access(ops[0]), a.location(ops[1]))
+		}
+	case "jmpd":
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewJumpDefined(a.access(ops[0]), a.location(ops[1]))
+		}
+	case "shrink":
+		if a.expect(mnemonic, ops, 1) {
+			return ir.NewShrink(a.integer(ops[0]))
+		}
+	case "tcall":
+		if a.expect(mnemonic, ops, 4) {
+			return ir.NewTailCall(a.location(ops[0]), a.params(ops[1]), a.integer(ops[2]), a.integer(ops[3]))
+		}
+	case "tcallr":
+		if a.expect(mnemonic, ops, 3) {
+			return ir.NewTailCallIndirect(a.access(ops[0]), a.integer(ops[1]), a.integer(ops[2]))
+		}
+	case "hcall":
+		if a.expect(mnemonic, ops, 2) {
+			return ir.NewHostCall(a.name(ops[0]), a.integer(ops[1]))
+		}
+	case "guard":
+		if a.expect(mnemonic, ops, 3) {
+			return ir.NewGuard(a.access(ops[0]), a.annotation(ops[1]), a.str(ops[2]))
+		}
+	default:
+		a.fail("Unknown instruction '" + mnemonic + "'")
+	}
+	return nil
+}
+
+/*=================================================================================*/
+
+func (a *assembler) integer(op string) int {
+	n, e := strconv.ParseInt(op, 0, 64)
+	if e != nil {
+		a.fail("Invalid integer '" + op + "'")
+	}
+	return int(n)
+}
+
+/* The inside of an operand written as prefix(...) */
+func enclosed(op, prefix string) (string, bool) {
+	if strings.HasPrefix(op, prefix+"(") && strings.HasSuffix(op, ")") {
+		return op[len(prefix)+1 : len(op)-1], true
+	}
+	return "", false
+}
+
+func (a *assembler) access(op string) ir.Accessor {
+	if inner, ok := enclosed(op, "BP"); ok {
+		return ir.NewStackAccess(a.integer(inner))
+	} else if inner, ok := enclosed(op, "M"); ok {
+		return ir.NewMemoryAccess(a.address(inner))
+	} else if inner, ok := enclosed(op, "E"); ok {
+		return ir.NewEnvAccess(a.address(inner))
+	} else if strings.HasPrefix(op, "%") {
+		id, e := strconv.ParseUint(op[1:], 16, 32)
+		if e != nil {
+			a.fail("Invalid register '" + op + "'")
+		}
+		return ir.NewRegisterAccess(int(id))
+	} else if strings.HasPrefix(op, "\"") {
+		return a.pool.Access(system.NewString(a.str(op)))
+	} else if n, e := strconv.ParseFloat(op, 64); e == nil {
+		return a.pool.Access(system.NewNumber(n))
+	}
+	a.fail("Invalid operand '" + op + "'")
+	return ir.NewRegisterAccess(0)
+}
+
+func (a *assembler) address(op string) int {
+	n := a.integer(op)
+	if n < 0 {
+		a.fail("Negative address '" + op + "'")
+	}
+	return n
+}
+
+func (a *assembler) location(op string) *ir.InstructionLocation {
+	if isLabel(op) {
+		location := ir.NewInstructionLocation(0)
+		a.refs = append(a.refs, reference{line: a.line, label: op, location: location})
+		return location
+	}
+	return ir.NewInstructionLocation(a.address(op))
+}
+
+/* Params are written as P(required,optional,rest) */
+func (a *assembler) params(op string) system.Params {
+	inner, ok := enclosed(op, "P")
+	fields := strings.Split(inner, ",")
+	if !ok || len(fields) != 3 {
+		a.fail("Invalid params '" + op + "'")
+		return system.Params{}
+	}
+	return system.Params{
+		Required: a.address(strings.TrimSpace(fields[0])),
+		Optional: a.address(strings.TrimSpace(fields[1])),
+		Rest:     a.address(strings.TrimSpace(fields[2])) != 0,
+	}
+}
+
+func (a *assembler) annotation(op string) string {
+	if !isLabel(op) {
+		a.fail("Invalid annotation '" + op + "'")
+	}
+	return op
+}
+
+func (a *assembler) name(op string) string {
+	if !isLabel(op) {
+		a.fail("Invalid function name '" + op + "'")
+	}
+	return op
+}
+
+func (a *assembler) str(op string) string {
+	s, e := strconv.Unquote(op)
+	if e != nil || !strings.HasPrefix(op, "\"") {
+		a.fail("Invalid string " + op)
+	}
+	return s
+}
+
+/*=================================================================================*/
+
+func isLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		letter := c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+/* Walk a line outside of string literals, calling visit with each
+ * character's index until it returns false */
+func scan(line string, visit func(i int, c byte) bool) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quoted {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				quoted = false
+			}
+			continue
+		} else if c == '"' {
+			quoted = true
+			continue
+		}
+		if !visit(i, c) {
+			return
+		}
+	}
+}
+
+func stripComment(line string) string {
+	end := len(line)
+	scan(line, func(i int, c byte) bool {
+		if c == '#' {
+			end = i
+			return false
+		}
+		return true
+	})
+	return line[:end]
+}
+
+/* Split operands on the commas outside of parentheses and strings */
+func splitOperands(s string) []string {
+	if s == "" {
+		return nil
+	}
+	ops := []string{}
+	depth, start := 0, 0
+	scan(s, func(i int, c byte) bool {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				ops = append(ops, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+		return true
+	})
+	return append(ops, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package asm_test
+
+import (
+	"github.com/rkophs/presta/asm"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/vm"
+	"strings"
+	"testing"
+)
+
+func TestAssembleRunsLoop(t *testing.T) {
+	src := `
+		mov	%0,0
+	loop:	mov	%1,%0
+		lt	%1,5
+		jmpf	%1,done
+		add	%0,1
+		goto	loop
+	done:	exit	%0
+	`
+	instrs, e := asm.Assemble(strings.NewReader(src))
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	for _, run := range []func(*vm.VM) err.Error{(*vm.VM).Run, (*vm.VM).Interpret} {
+		v := vm.NewVM(instrs)
+		if e := run(v); e != nil {
+			t.Fatal(e.Message())
+		} else if n, _ := v.Result().(*system.Number).ToNumber(); n != 5 {
+			t.Errorf("result %v, want 5", n)
+		}
+	}
+}
+
+func TestAssembleRejectsMalformedPrograms(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"goto 0x99", "[1]\tInstruction location outside of the program"},
+		{"goto 0x1", "[1]\tInstruction location outside of the program"},
+		{"exit %0\njmpf %0,0x5", "[2]\tInstruction location outside of the program"},
+		{"call 0x9,P(0,0,0),0\nexit %0", "[1]\tInstruction location outside of the program"},
+		{"closure %0,0x3,P(0,0,0)\nexit %0", "[1]\tInstruction location outside of the program"},
+		{"tcall 0x2,P(0,0,0),0,0\nexit %0", "[1]\tInstruction location outside of the program"},
+		{"exit %0\n\nshrink -0x3", "[3]\tShrink offset is negative"},
+		{"mov %0,M(-0x1)", "[1]\tNegative address '-0x1'"},
+		{"goto done", "[1]\tUndefined label 'done'"},
+	}
+	for _, test := range tests {
+		_, e := asm.Assemble(strings.NewReader(test.src))
+		if e == nil {
+			t.Errorf("%q: assembled without error", test.src)
+		} else if e.Message() != test.msg {
+			t.Errorf("%q: got %q, want %q", test.src, e.Message(), test.msg)
+		}
+	}
+}
+
+/* Programs that assemble but fail at run time must fail the same way in
+ * the dispatch loop and the interpreter */
+func TestMalformedProgramsFailAtRunTime(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"mov %0,1", "Program counter outside of the program"},
+		{"ret %0", "Return outside of a function"},
+		{"push BP(-0x5)\nexit %0", "Stack offset -5 is outside the stack"},
+		{"mov %0,M(0x3)\nexit %0", "Memory address 3 is not allocated"},
+		{"shrink 0x3\nexit %0", "Stack offset 3 is outside the stack"},
+		{"callr %0,0x0\nexit %0", "Value is not a function"},
+		//Registers nothing has written are undefined
+		{"sub %0,1\nexit %0", "sub requires 2 numbers"},
+		{"add %0,%1\nexit %0", "Addition requires 2 numbers"},
+		{"lt %0,1\nexit %0", "lt requires 2 numbers"},
+	}
+	for _, test := range tests {
+		instrs, e := asm.Assemble(strings.NewReader(test.src))
+		if e != nil {
+			t.Fatalf("%q: %s", test.src, e.Message())
+		}
+		for _, run := range []func(*vm.VM) err.Error{(*vm.VM).Run, (*vm.VM).Interpret} {
+			e := run(vm.NewVM(instrs))
+			if e == nil {
+				t.Errorf("%q: ran without error", test.src)
+			} else if e.Code() != err.RUNTIME_ERROR || e.Message() != test.msg {
+				t.Errorf("%q: got %q, want %q", test.src, e.Message(), test.msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/* Lines of unchanged context shown around each change */
+const CONTEXT = 3
+
+type edit struct {
+	op   byte //' ', '-' or '+'
+	line string
+}
+
+/* The unified diff turning a into b */
+func unifiedDiff(nameA, nameB string, a, b []byte) []byte {
+	edits := lineEdits(splitLines(a), splitLines(b))
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "--- %s\n+++ %s\n", nameA, nameB)
+
+	//Line numbers, counted from 1, at the start of each edit
+	startA, startB := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	startA[0], startB[0] = 1, 1
+	for i, e := range edits {
+		startA[i+1], startB[i+1] = startA[i], startB[i]
+		if e.op != '+' {
+			startA[i+1]++
+		}
+		if e.op != '-' {
+			startB[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		//A hunk runs until more than twice the context is unchanged
+		first := i - CONTEXT
+		if first < 0 {
+			first = 0
+		}
+		last, same := i, 0
+		for j := i; j < len(edits) && same <= 2*CONTEXT; j++ {
+			if edits[j].op == ' ' {
+				same++
+			} else {
+				last, same = j, 0
+			}
+		}
+		end := last + CONTEXT + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		countA, countB := startA[end]-startA[first], startB[end]-startB[first]
+		fmt.Fprintf(&buffer, "@@ -%s +%s @@\n", hunkRange(startA[first], countA), hunkRange(startB[first], countB))
+		for _, e := range edits[first:end] {
+			buffer.WriteByte(e.op)
+			buffer.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buffer.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buffer.Bytes()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start-- //Empty ranges name the line before them
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+/* The edits of a longest common subsequence of the lines */
+func lineEdits(a, b []string) []edit {
+	//lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			edits = append(edits, edit{' ', a[i]})
+			i, j = i+1, j+1
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		} else {
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/rkophs/presta/format"
+	"io/ioutil"
+	"os"
+)
+
+const USAGE = `usage: presta <command> [arguments]
+
+commands:
+	fmt	format presta source
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "fmt":
+		os.Exit(formatCommand(os.Args[2:]))
+	default:
+		fmt.Fprintf(os.Stderr, "presta: unknown command %q\n", os.Args[1])
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+}
+
+/* Format the named files, or standard input when there are none. The
+ * formatted source is printed unless it is written back to the files or
+ * only their differences from it are shown. */
+func formatCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write the result to the file instead of printing it")
+	diff := flags.Bool("d", false, "print a diff of the changes instead of the result")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: presta fmt [-w] [-d] [file ...]")
+		flags.PrintDefaults()
+	}
+	if e := flags.Parse(args); e != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "presta fmt: -w needs a file to write")
+			return 2
+		}
+		src, e := ioutil.ReadAll(os.Stdin)
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "presta fmt:", e)
+			return 2
+		}
+		return formatSource("<stdin>", src, false, *diff)
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, e := ioutil.ReadFile(path)
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "presta fmt:", e)
+			status = 2
+		} else if code := formatSource(path, src, *write, *diff); code != 0 {
+			status = code
+		}
+	}
+	return status
+}
+
+func formatSource(path string, src []byte, write bool, diff bool) int {
+	out, e := format.Source(src)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, e.Message())
+		return 2
+	}
+
+	if diff && !bytes.Equal(src, out) {
+		fmt.Printf("diff %s.orig %s\n", path, path)
+		os.Stdout.Write(unifiedDiff(path+".orig", path, src, out))
+	}
+	if write && !bytes.Equal(src, out) {
+		info, e := os.Stat(path)
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "presta fmt:", e)
+			return 2
+		} else if e := ioutil.WriteFile(path, out, info.Mode().Perm()); e != nil {
+			fmt.Fprintln(os.Stderr, "presta fmt:", e)
+			return 2
+		}
+	}
+	if !write && !diff {
+		os.Stdout.Write(out)
+	}
+	return 0
+}
@@ -29,16 +29,20 @@ import (
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Assign struct {
+	position
 	name  string
 	value AstNode
 }
 
 func NewAssignExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Check for : */
 	readCount++
@@ -63,7 +67,7 @@ func NewAssignExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	if expr, err := NewExpression(p); err != nil {
 		return parseError(p, err.Message(), readCount)
 	} else if expr != nil {
-		node := &Assign{name: name, value: expr}
+		node := &Assign{position: position{pos}, name: name, value: expr}
 		return parseValid(p, node)
 	} else {
 		return parseError(p, "Assignment operator must have valid assignment expression.", readCount)
@@ -74,6 +78,21 @@ func (a *Assign) Type() AstNodeType {
 	return ASSIGN
 }
 
+func NewAssignNode(name string, value AstNode) (*Assign, err.Error) {
+	if name == "" || value == nil {
+		return nil, err.NewSyntaxError("An assignment needs a name and a value")
+	}
+	return &Assign{name: name, value: value}, nil
+}
+
+func (a *Assign) Name() string {
+	return a.name
+}
+
+func (a *Assign) Value() AstNode {
+	return a.value
+}
+
 func (a *Assign) Serialize(buffer *bytes.Buffer) {
 
 	json.BuildMap(buffer,
@@ -85,3 +104,28 @@ func (a *Assign) Serialize(buffer *bytes.Buffer) {
 func (p *Assign) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 	return nil
 }
+
+func (a *Assign) Infer(c *types.Checker) *types.Type {
+	t := c.LookupVariable(a.name)
+	c.Expect(a.value.Position(), a.value.Infer(c), t, "Assignment to '"+a.name+"'")
+	return c.Record(a, t)
+}
+
+func (a *Assign) Resolve(r *parser.Resolver) err.Error {
+	if e := a.value.Resolve(r); e != nil {
+		return e
+	}
+	return r.ResolveAssignment(a, a.name, a.Position())
+}
+
+func (a *Assign) Lint(l *lint.Linter) {
+	if v, ok := a.value.(*Variable); ok && v.name == a.name {
+		l.Report(lint.SELF_ASSIGN, a.Position(), "'"+a.name+"' is assigned to itself")
+	}
+	a.value.Lint(l)
+}
+
+func (a *Assign) Optimize(o *Optimizer) AstNode {
+	a.value = a.value.Optimize(o)
+	return a
+}
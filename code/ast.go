@@ -29,13 +29,59 @@ import (
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type AstNode interface {
 	json.Serializable
 	Type() AstNodeType
+	Position() parser.Position
+	SetPosition(pos parser.Position)
 	GenerateICG(code *icg.Code, s *parser.Semantic) err.Error
+	Infer(c *types.Checker) *types.Type
+	Resolve(r *parser.Resolver) err.Error
+	Lint(l *lint.Linter)
+	Optimize(o *Optimizer) AstNode
+}
+
+/* Source location of the first token of a node */
+type position struct {
+	pos parser.Position
+}
+
+func (p *position) Position() parser.Position {
+	return p.pos
+}
+
+/* Builders reject children which are missing */
+func checkNodes(nodes []AstNode, msg string) err.Error {
+	for _, node := range nodes {
+		if node == nil {
+			return err.NewSyntaxError(msg)
+		}
+	}
+	return nil
+}
+
+func checkFunctions(funcs []*Function) err.Error {
+	for _, fn := range funcs {
+		if fn == nil {
+			return err.NewSyntaxError("A function declaration is missing")
+		}
+	}
+	return nil
+}
+
+/* Place a node built by hand, whose position is otherwise the zero one */
+func (p *position) SetPosition(pos parser.Position) {
+	p.pos = pos
+}
+
+func peekPosition(p *parser.TokenScanner) parser.Position {
+	tok, _ := p.Peek()
+	return tok.Position()
 }
 
 type AstNodeType int64
@@ -56,6 +102,7 @@ const (
 	NOT
 	BIN_OP
 	FUNC
+	LAMBDA
 )
 
 const (
@@ -115,6 +162,10 @@ func (a *AstNodeType) String() string {
 		return "NOT"
 	case BIN_OP:
 		return "BIN_OP"
+	case FUNC:
+		return "FUNC"
+	case LAMBDA:
+		return "LAMBDA"
 	default:
 		return ""
 	}
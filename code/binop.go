@@ -30,23 +30,26 @@ import (
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type BinOp struct {
+	position
 	l  AstNode
 	r  AstNode
 	op BinOpType
 }
 
-func NewBinOp(p *parser.TokenScanner, op BinOpType, readCount int) (tree AstNode, e err.Error) {
+func NewBinOp(p *parser.TokenScanner, op BinOpType, pos parser.Position, readCount int) (tree AstNode, e err.Error) {
 	if l, e := NewExpression(p); e != nil {
 		return parseError(p, e.Message(), readCount)
 	} else if l != nil {
 		if r, e := NewExpression(p); e != nil {
 			return parseError(p, e.Message(), readCount)
 		} else if r != nil {
-			node := &BinOp{l: l, r: r, op: op}
+			node := &BinOp{position: position{pos}, l: l, r: r, op: op}
 			return parseValid(p, node)
 		} else {
 			return parseError(p, "Binary op needs another expression.", readCount)
@@ -60,6 +63,25 @@ func (b *BinOp) Type() AstNodeType {
 	return BIN_OP
 }
 
+func NewBinOpNode(op BinOpType, l AstNode, r AstNode) (*BinOp, err.Error) {
+	if l == nil || r == nil {
+		return nil, err.NewSyntaxError("A binary operation needs two operands")
+	}
+	return &BinOp{l: l, r: r, op: op}, nil
+}
+
+func (b *BinOp) Op() BinOpType {
+	return b.op
+}
+
+func (b *BinOp) Left() AstNode {
+	return b.l
+}
+
+func (b *BinOp) Right() AstNode {
+	return b.r
+}
+
 func (b *BinOp) Serialize(buffer *bytes.Buffer) {
 
 	json.BuildMap(buffer,
@@ -71,29 +93,106 @@ func (b *BinOp) Serialize(buffer *bytes.Buffer) {
 
 func (b *BinOp) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 
-	/*Compute left side and push onto stack*/
+	/*Compute left side and keep it in a register, or on the stack once
+	  the registers run out*/
+	start := code.GetFrameOffset()
 	if e := b.l.GenerateICG(code, s); e != nil {
 		return e
 	}
-	laccess := ir.NewStackAccess(code.GetFrameOffset())
-	code.Append(ir.NewPush(code.Ax))
-	code.IncrFrameOffset(1)
+	var laccess ir.Accessor
+	if register, ok := code.AllocRegister(); ok {
+		defer code.FreeRegister(register.Id())
+		laccess = register
+		code.Append(ir.NewMov(register, code.Ax))
+	} else {
+		laccess = ir.NewStackAccess(code.GetFrameOffset())
+		code.Append(ir.NewPush(code.Ax))
+		code.IncrFrameOffset(1)
+	}
 
-	/*Compute right side and push onto stack*/
+	/*Compute right side, which the operation reads from AX*/
 	if e := b.r.GenerateICG(code, s); e != nil {
 		return e
 	}
-	raccess := ir.NewStackAccess(code.GetFrameOffset())
-	code.Append(ir.NewPush(code.Ax))
-	code.IncrFrameOffset(1)
+	raccess := code.Ax
 
 	switch b.op {
 	case ADD:
 		code.Append(ir.NewAdd(laccess, raccess)) //Adds and puts result location
-		code.Append(ir.NewMov(code.Ax, laccess))
-		break
+	case SUB:
+		code.Append(ir.NewBinOp(ir.SUB, laccess, raccess))
+	case MULT:
+		code.Append(ir.NewBinOp(ir.MULT, laccess, raccess))
+	case DIV:
+		code.Append(ir.NewBinOp(ir.DIV, laccess, raccess))
+	case MOD:
+		code.Append(ir.NewBinOp(ir.MOD, laccess, raccess))
+	case LT:
+		code.Append(ir.NewBinOp(ir.LT, laccess, raccess))
+	case LTE:
+		code.Append(ir.NewBinOp(ir.LTE, laccess, raccess))
+	case GT:
+		code.Append(ir.NewBinOp(ir.GT, laccess, raccess))
+	case GTE:
+		code.Append(ir.NewBinOp(ir.GTE, laccess, raccess))
+	case EQ:
+		code.Append(ir.NewBinOp(ir.EQ, laccess, raccess))
+	case NEQ:
+		code.Append(ir.NewBinOp(ir.NEQ, laccess, raccess))
+	case AND:
+		code.Append(ir.NewBinOp(ir.AND, laccess, raccess))
+	case OR:
+		code.Append(ir.NewBinOp(ir.OR, laccess, raccess))
 	default:
 		return err.NewSymanticError("Unsupported binary operation")
 	}
+	code.Append(ir.NewMov(code.Ax, laccess))
+
+	/*Release the operands, the result is in AX*/
+	code.RestoreFrameOffset(start)
 	return nil
 }
+
+func (b *BinOp) Infer(c *types.Checker) *types.Type {
+	l := b.l.Infer(c)
+	r := b.r.Infer(c)
+
+	switch b.op {
+	case EQ, NEQ, AND, OR:
+		//Any two values may be compared or combined
+	default:
+		if !c.Strict() {
+			//Operands are checked when the operator runs
+			break
+		}
+		what := "Operator " + b.op.String()
+		c.Expect(b.l.Position(), l, types.Number(), what)
+		c.Expect(b.r.Position(), r, types.Number(), what)
+	}
+	return c.Record(b, types.Number())
+}
+
+func (b *BinOp) Resolve(r *parser.Resolver) err.Error {
+	if e := b.l.Resolve(r); e != nil {
+		return e
+	}
+	return b.r.Resolve(r)
+}
+
+func (b *BinOp) Lint(l *lint.Linter) {
+	b.l.Lint(l)
+	b.r.Lint(l)
+}
+
+func (b *BinOp) Optimize(o *Optimizer) AstNode {
+	b.l = b.l.Optimize(o)
+	b.r = b.r.Optimize(o)
+	if l, ok := b.l.(*Data); ok {
+		if r, ok := b.r.(*Data); ok {
+			if folded, ok := foldBinOp(b.Position(), b.op, l, r); ok {
+				return folded
+			}
+		}
+	}
+	return b
+}
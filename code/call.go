@@ -30,10 +30,15 @@ import (
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/types"
+	"strconv"
 )
 
 type Call struct {
+	position
 	name   string
 	params []AstNode
 }
@@ -41,6 +46,7 @@ type Call struct {
 func NewCallExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Get variable name*/
 	var name string
@@ -56,7 +62,7 @@ func NewCallExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	/*Check for bracket*/
 	readCount++
 	if tok, eof := p.Read(); eof {
-		return parseError(p, "Premature end.", readCount)
+		return parseExit(p, readCount-1) //Data identifier ending the source
 	} else if tok.Type() != parser.CURLY_OPEN {
 		return parseExit(p, readCount) //Not caller, but data identifier
 	}
@@ -81,7 +87,7 @@ func NewCallExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseError(p, "Missing closing bracket.", readCount)
 	}
 
-	node := &Call{name: name, params: args}
+	node := &Call{position: position{pos}, name: name, params: args}
 	return parseValid(p, node)
 }
 
@@ -89,6 +95,24 @@ func (c *Call) Type() AstNodeType {
 	return CALL
 }
 
+func NewCallNode(name string, args []AstNode) (*Call, err.Error) {
+	if name == "" {
+		return nil, err.NewSyntaxError("A call needs a name")
+	} else if e := checkNodes(args, "A call argument is missing"); e != nil {
+		return nil, e
+	}
+	return &Call{name: name, params: args}, nil
+}
+
+/* The name of the function, host function or variable called */
+func (c *Call) Name() string {
+	return c.name
+}
+
+func (c *Call) Args() []AstNode {
+	return c.params
+}
+
 func (c *Call) Serialize(buffer *bytes.Buffer) {
 
 	params := []json.Serializable{}
@@ -104,31 +128,151 @@ func (c *Call) Serialize(buffer *bytes.Buffer) {
 
 func (c *Call) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 
-	if !s.FunctionExists(c.name) || s.FunctionArity(c.name) != len(c.params) {
-		return err.NewSymanticError("Function not found")
+	//Function values are checked by the VM once their params are known
+	indirect := s.IsVariableCall(c.name)
+	if !indirect {
+		if e := s.CheckArity(c.name, len(c.params), c.Position()); e != nil {
+			return e
+		}
 	}
 
-	//Generate params
-	offsets := make([]int, len(c.params))
-	for i, p := range c.params {
+	//Evaluate args left to right, pushing each into the argument area
+	//(see vm.Stack for the calling convention)
+	start := code.GetFrameOffset()
+	for _, p := range c.params {
 		if e := p.GenerateICG(code, s); e != nil {
 			return e
+		}
+		code.Append(ir.NewPush(code.Ax))
+		code.IncrFrameOffset(1)
+	}
+
+	//Calls in tail position reuse the current frame
+	tail := code.IsTail(c)
+
+	//Function values are called through their variable, host functions
+	//by name
+	if indirect {
+		if access, e := variableAccess(c.name, code, s); e != nil {
+			return e
+		} else if tail {
+			code.Append(ir.NewTailCallIndirect(access, len(c.params), code.GetParamCount()))
+		} else {
+			code.Append(ir.NewCallIndirect(access, len(c.params)))
+		}
+	} else if s.IsHostCall(c.name) {
+		if e := c.cachePattern(s); e != nil {
+			return e
+		}
+		code.Append(ir.NewHostCall(c.name, len(c.params)))
+	} else if len(s.FunctionCaptures(c.name)) > 0 {
+		//Functions capturing variables are called through a function
+		//value holding them
+		if e := functionValue(c.name, code, s); e != nil {
+			return e
+		} else if tail {
+			code.Append(ir.NewTailCallIndirect(code.Ax, len(c.params), code.GetParamCount()))
 		} else {
-			offsets[i] = code.GetFrameOffset()
-			code.Append(ir.NewPush(code.Ax))
-			code.IncrFrameOffset(1)
+			code.Append(ir.NewCallIndirect(code.Ax, len(c.params)))
+		}
+	} else {
+		//Call function (which loads AX when finished)
+		gotoLoc := code.GetFunctionOffset(s.GetFunctionId(c.name))
+		params := s.FunctionParams(c.name)
+		if tail {
+			code.Append(ir.NewTailCall(gotoLoc, params, len(c.params), code.GetParamCount()))
+		} else {
+			code.Append(ir.NewCall(gotoLoc, params, len(c.params)))
+		}
+	}
+
+	//Caller cleanup: release the argument area, the result is in AX
+	code.RestoreFrameOffset(start)
+
+	return nil
+}
+
+/* Compile a constant regular expression passed to a host function once,
+ * reporting a bad pattern before the script runs */
+func (c *Call) cachePattern(s *parser.Semantic) err.Error {
+	host, _ := s.HostFunction(c.name)
+	if i, ok := host.PatternArg(); ok && i < len(c.params) {
+		if d, ok := c.params[i].(*Data); ok && d.dataType == STRING {
+			if e := system.CachePattern(d.str); e != nil {
+				return err.NewSymanticError(d.Position().String() + "\tInvalid pattern: " + e.Error())
+			}
 		}
 	}
+	return nil
+}
 
-	//Push params onto the stack as fn args
-	for i, _ := range c.params {
-		code.Append(ir.NewPush(ir.NewStackAccess(offsets[i])))
+func (c *Call) Infer(checker *types.Checker) *types.Type {
+	args := make([]*types.Type, len(c.params))
+	for i, p := range c.params {
+		args[i] = p.Infer(checker)
 	}
-	code.IncrFrameOffset(len(c.params))
 
-	//Call function (which loads AX when finished)
-	gotoLoc := code.GetFunctionOffset(c.name)
-	code.Append(ir.NewCall(gotoLoc))
+	callee := checker.LookupCallee(c.name)
+	switch callee.Kind() {
+	case types.ANY:
+		return checker.Record(c, types.Any())
+	case types.VAR:
+		//An unknown function value is assumed to take exactly these args
+		params := make([]*types.Type, len(args))
+		for i := range args {
+			params[i] = checker.Fresh()
+		}
+		checker.Unify(callee, types.NewFunction(params, len(params), nil, checker.Fresh()))
+	case types.FUNCTION:
+		if callee.Open() {
+			return checker.Record(c, types.Any())
+		}
+	default:
+		checker.Errorf(c.Position(), "'"+c.name+"' is "+callee.String()+", not a function")
+		return checker.Record(c, types.Any())
+	}
 
+	params := callee.Params()
+	if !checker.Strict() {
+		//Arity is left to the semantic pass
+	} else if len(args) < callee.Required() || (callee.Rest() == nil && len(args) > len(params)) {
+		checker.Errorf(c.Position(), "Function '"+c.name+"' of type "+callee.String()+" called with "+system.Arguments(len(args)))
+	}
+	for i, arg := range args {
+		what := "Argument " + strconv.Itoa(i+1) + " of '" + c.name + "'"
+		if i < len(params) {
+			checker.Expect(c.params[i].Position(), arg, params[i], what)
+		} else if callee.Rest() != nil {
+			checker.Expect(c.params[i].Position(), arg, callee.Rest(), what)
+		}
+	}
+	return checker.Record(c, callee.Result())
+}
+
+func (c *Call) Resolve(r *parser.Resolver) err.Error {
+	if e := r.ResolveCallee(c, c.name, c.Position()); e != nil {
+		return e
+	}
+	for _, p := range c.params {
+		if e := p.Resolve(r); e != nil {
+			return e
+		}
+	}
 	return nil
 }
+
+func (c *Call) Lint(l *lint.Linter) {
+	for _, p := range c.params {
+		p.Lint(l)
+	}
+}
+
+func (c *Call) Optimize(o *Optimizer) AstNode {
+	for i, p := range c.params {
+		c.params[i] = p.Optimize(o)
+	}
+	if inlined, ok := o.inline(c); ok {
+		return inlined
+	}
+	return c
+}
@@ -29,15 +29,19 @@ import (
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Concat struct {
+	position
 	components []AstNode
 }
 
 func NewConcatExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/* Get '.' */
 	readCount++
@@ -82,7 +86,7 @@ func NewConcatExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseError(p, "Missing closing parenthesis for concat", readCount)
 	}
 
-	node := &Concat{components: exprs}
+	node := &Concat{position: position{pos}, components: exprs}
 	return parseValid(p, node)
 }
 
@@ -90,6 +94,17 @@ func (c *Concat) Type() AstNodeType {
 	return CONCAT
 }
 
+func NewConcatNode(components []AstNode) (*Concat, err.Error) {
+	if e := checkNodes(components, "A concat component is missing"); e != nil {
+		return nil, e
+	}
+	return &Concat{components: components}, nil
+}
+
+func (c *Concat) Components() []AstNode {
+	return c.components
+}
+
 func (c *Concat) Serialize(buffer *bytes.Buffer) {
 	components := []json.Serializable{}
 	for _, component := range c.components {
@@ -104,3 +119,43 @@ func (c *Concat) Serialize(buffer *bytes.Buffer) {
 func (c *Concat) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 	return nil
 }
+
+func (c *Concat) Infer(checker *types.Checker) *types.Type {
+	for _, component := range c.components {
+		component.Infer(checker)
+	}
+	return checker.Record(c, types.String())
+}
+
+func (c *Concat) Resolve(r *parser.Resolver) err.Error {
+	for _, component := range c.components {
+		if e := component.Resolve(r); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *Concat) Lint(l *lint.Linter) {
+	for _, component := range c.components {
+		component.Lint(l)
+	}
+}
+
+/* Concatenations of constants become a single string */
+func (c *Concat) Optimize(o *Optimizer) AstNode {
+	var buffer bytes.Buffer
+	constant := true
+	for i, component := range c.components {
+		c.components[i] = component.Optimize(o)
+		if d, ok := c.components[i].(*Data); ok {
+			buffer.WriteString(d.text())
+		} else {
+			constant = false
+		}
+	}
+	if constant {
+		return newString(c.Position(), buffer.String())
+	}
+	return c
+}
@@ -26,17 +26,19 @@ package code
 
 import (
 	"bytes"
-	"encoding/hex"
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
 	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/types"
 	"strconv"
 )
 
 type Data struct {
+	position
 	str      string
 	num      float64
 	dataType DataType
@@ -44,25 +46,24 @@ type Data struct {
 
 func NewData(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 1
+	pos := peekPosition(p)
 	if tok, e := p.Read(); e {
 		return parseError(p, "Premature end.", readCount)
 	} else if tok.Type() == parser.STRING {
-		node := &Data{str: tok.Lit(), dataType: STRING}
+		node := &Data{position: position{pos}, str: tok.Lit(), dataType: STRING}
 		return parseValid(p, node)
 	} else if tok.Type() == parser.NUMBER {
 		if num, e := strconv.ParseFloat(tok.Lit(), 64); e != nil {
 			return parseError(p, "Error parsing numeric.", readCount)
 		} else {
-			node := &Data{num: num, dataType: NUMBER}
+			node := &Data{position: position{pos}, num: num, dataType: NUMBER}
 			return parseValid(p, node)
 		}
 	} else if tok.Type() == parser.IDENTIFIER {
-		if next, e := p.Peek(); e {
-			return parseError(p, "Premature end.", readCount)
-		} else if next.Type() == parser.CURLY_OPEN { //Not identifer - but caller
+		if next, e := p.Peek(); !e && next.Type() == parser.CURLY_OPEN { //Not identifer - but caller
 			parseExit(p, readCount)
 		} else {
-			node := &Variable{name: tok.Lit()}
+			node := &Variable{position: position{pos}, name: tok.Lit()}
 			return parseValid(p, node)
 		}
 	}
@@ -74,6 +75,28 @@ func (d *Data) Type() AstNodeType {
 	return DATA
 }
 
+func NewNumberNode(num float64) *Data {
+	return &Data{num: num, dataType: NUMBER}
+}
+
+func NewStringNode(str string) *Data {
+	return &Data{str: str, dataType: STRING}
+}
+
+func (d *Data) DataType() DataType {
+	return d.dataType
+}
+
+/* The value of a NUMBER constant */
+func (d *Data) NumberValue() float64 {
+	return d.num
+}
+
+/* The value of a STRING constant */
+func (d *Data) StringValue() string {
+	return d.str
+}
+
 func (d *Data) Serialize(buffer *bytes.Buffer) {
 	if d.dataType == NUMBER {
 		json.BuildMap(buffer,
@@ -81,11 +104,9 @@ func (d *Data) Serialize(buffer *bytes.Buffer) {
 			&json.KV{K: "value", V: json.NewNumber(d.num)},
 			&json.KV{K: "type", V: json.NewString("DATA")})
 	} else {
-		str := []byte(d.str)
-		hexStr := hex.EncodeToString(str)
 		json.BuildMap(buffer,
-			&json.KV{K: "dataType", V: json.NewString("string")},
-			&json.KV{K: "value", V: json.NewString(hexStr)},
+			&json.KV{K: "dataType", V: json.NewString(d.dataType.String())},
+			&json.KV{K: "value", V: json.NewString(d.str)},
 			&json.KV{K: "type", V: json.NewString("DATA")})
 	}
 }
@@ -102,6 +123,35 @@ func (d *Data) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 		break
 	}
 
-	code.Append(ir.NewMov(code.Ax, ir.NewConstantAccess(entry)))
+	code.Append(ir.NewMov(code.Ax, code.Constant(entry)))
+	return nil
+}
+
+func (d *Data) Infer(c *types.Checker) *types.Type {
+	if d.dataType == NUMBER {
+		return c.Record(d, types.Number())
+	}
+	return c.Record(d, types.String())
+}
+
+func (d *Data) Resolve(r *parser.Resolver) err.Error {
 	return nil
 }
+
+func (d *Data) Lint(l *lint.Linter) {
+}
+
+/* Whether a node is a constant, and if so whether it is true */
+func constantTruth(node AstNode) (truth bool, ok bool) {
+	if d, ok := node.(*Data); !ok {
+		return false, false
+	} else if d.dataType == NUMBER {
+		return d.num != 0, true
+	} else {
+		return d.str != "", true
+	}
+}
+
+func (d *Data) Optimize(o *Optimizer) AstNode {
+	return d
+}
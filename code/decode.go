@@ -0,0 +1,513 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code
+
+import (
+	encoding "encoding/json"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/types"
+	"io"
+	"sort"
+	"strconv"
+)
+
+/* Rebuild a tree from the JSON its Serialize wrote. Positions are not
+ * serialized, so every decoded node is at the zero position. */
+func Decode(r io.Reader) (AstNode, err.Error) {
+	var v interface{}
+	decoder := encoding.NewDecoder(r)
+	if e := decoder.Decode(&v); e != nil {
+		return nil, err.NewLoadError("Invalid AST JSON: " + e.Error())
+	} else if decoder.More() {
+		return nil, err.NewLoadError("Invalid AST JSON: data after the root node")
+	}
+	return decodeNode(v, "root")
+}
+
+/*=================================================================================*/
+
+type decodeError struct {
+	path string
+	msg  string
+}
+
+func decodeFail(path string, msg string) *decodeError {
+	return &decodeError{path: path, msg: msg}
+}
+
+/* The fields of a node, which must be an object holding only keys */
+func decodeObject(v interface{}, path string, keys ...string) (map[string]interface{}, *decodeError) {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, decodeFail(path, "Expected an object")
+	}
+
+	unknown := []string{}
+	for key := range object {
+		known := false
+		for _, k := range keys {
+			known = known || k == key
+		}
+		if !known {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, decodeFail(path, "Unknown field '"+unknown[0]+"'")
+	}
+	for _, key := range keys {
+		if _, ok := object[key]; !ok {
+			return nil, decodeFail(path, "Missing field '"+key+"'")
+		}
+	}
+	return object, nil
+}
+
+func decodeString(object map[string]interface{}, key string, path string) (string, *decodeError) {
+	if s, ok := object[key].(string); ok {
+		return s, nil
+	}
+	return "", decodeFail(path+"."+key, "Expected a string")
+}
+
+/* A string which must not be empty, such as the name of a variable */
+func decodeName(object map[string]interface{}, key string, path string) (string, *decodeError) {
+	name, e := decodeString(object, key, path)
+	if e == nil && name == "" {
+		return "", decodeFail(path+"."+key, "Expected a name")
+	}
+	return name, e
+}
+
+func decodeArray(object map[string]interface{}, key string, path string) ([]interface{}, *decodeError) {
+	if a, ok := object[key].([]interface{}); ok {
+		return a, nil
+	}
+	return nil, decodeFail(path+"."+key, "Expected an array")
+}
+
+func decodeNames(object map[string]interface{}, key string, path string) ([]string, *decodeError) {
+	elems, e := decodeArray(object, key, path)
+	if e != nil {
+		return nil, e
+	}
+	names := make([]string, len(elems))
+	for i, elem := range elems {
+		elemPath := path + "." + key + "[" + strconv.Itoa(i) + "]"
+		if name, ok := elem.(string); !ok || name == "" {
+			return nil, decodeFail(elemPath, "Expected a name")
+		} else {
+			names[i] = name
+		}
+	}
+	return names, nil
+}
+
+/* Type annotations, each empty or the name of a type */
+func decodeAnnotations(object map[string]interface{}, key string, path string, count int) ([]string, *decodeError) {
+	elems, e := decodeArray(object, key, path)
+	if e != nil {
+		return nil, e
+	} else if len(elems) != count {
+		return nil, decodeFail(path+"."+key, "Expected "+strconv.Itoa(count)+" annotations, got "+strconv.Itoa(len(elems)))
+	}
+	annotations := make([]string, len(elems))
+	for i, elem := range elems {
+		elemPath := path + "." + key + "[" + strconv.Itoa(i) + "]"
+		annotation, ok := elem.(string)
+		if !ok {
+			return nil, decodeFail(elemPath, "Expected a string")
+		} else if e := checkAnnotation(annotation, elemPath); e != nil {
+			return nil, e
+		}
+		annotations[i] = annotation
+	}
+	return annotations, nil
+}
+
+func checkAnnotation(annotation string, path string) *decodeError {
+	if _, ok := types.Named(annotation); annotation != "" && !ok {
+		return decodeFail(path, "Unknown type '"+annotation+"'")
+	}
+	return nil
+}
+
+func decodeChild(object map[string]interface{}, key string, path string) (AstNode, *decodeError) {
+	return decodeNodeAt(object[key], path+"."+key)
+}
+
+func decodeChildren(object map[string]interface{}, key string, path string) ([]AstNode, *decodeError) {
+	elems, e := decodeArray(object, key, path)
+	if e != nil {
+		return nil, e
+	}
+	nodes := make([]AstNode, len(elems))
+	for i, elem := range elems {
+		if nodes[i], e = decodeNodeAt(elem, path+"."+key+"["+strconv.Itoa(i)+"]"); e != nil {
+			return nil, e
+		}
+	}
+	return nodes, nil
+}
+
+/* A functions list, the only place FUNC nodes are allowed */
+func decodeFunctions(object map[string]interface{}, key string, path string) ([]*Function, *decodeError) {
+	elems, e := decodeArray(object, key, path)
+	if e != nil {
+		return nil, e
+	}
+	funcs := make([]*Function, len(elems))
+	for i, elem := range elems {
+		elemPath := path + "." + key + "[" + strconv.Itoa(i) + "]"
+		if nodeType, e := decodeType(elem, elemPath); e != nil {
+			return nil, e
+		} else if nodeType != "FUNC" {
+			return nil, decodeFail(elemPath, "Expected a FUNC node")
+		}
+		if funcs[i], e = decodeFunction(elem, elemPath); e != nil {
+			return nil, e
+		}
+	}
+	return funcs, nil
+}
+
+/*=================================================================================*/
+
+/* The root, which is a PROG node or an expression */
+func decodeNode(v interface{}, path string) (AstNode, err.Error) {
+	var node AstNode
+	nodeType, e := decodeType(v, path)
+	if e == nil && nodeType == "PROG" {
+		node, e = decodeProgram(v, path)
+	} else if e == nil {
+		node, e = decodeNodeAt(v, path)
+	}
+	if e != nil {
+		return nil, err.NewLoadError("[" + e.path + "]\t" + e.msg)
+	}
+	return node, nil
+}
+
+func decodeType(v interface{}, path string) (string, *decodeError) {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return "", decodeFail(path, "Expected an AST node")
+	}
+	nodeType, ok := object["type"].(string)
+	if !ok {
+		return "", decodeFail(path+".type", "Expected the node type")
+	}
+	return nodeType, nil
+}
+
+/* An expression. PROG nodes are only allowed at the root and FUNC nodes in
+ * functions lists. */
+func decodeNodeAt(v interface{}, path string) (AstNode, *decodeError) {
+	nodeType, e := decodeType(v, path)
+	if e != nil {
+		return nil, e
+	}
+
+	switch nodeType {
+	case "PROG":
+		return nil, decodeFail(path, "A PROG node is only allowed at the root")
+	case "FUNC":
+		return nil, decodeFail(path, "A FUNC node is only allowed in a functions list")
+	case "LAMBDA":
+		return decodeLambda(v, path)
+	case "LET":
+		return decodeLet(v, path)
+	case "MATCH":
+		return decodeMatch(v, path)
+	case "REPEAT":
+		return decodeRepeat(v, path)
+	case "ASSIGN":
+		return decodeAssign(v, path)
+	case "CONCAT":
+		return decodeConcat(v, path)
+	case "CALL":
+		return decodeCall(v, path)
+	case "NOT":
+		return decodeNot(v, path)
+	case "BINOP":
+		return decodeBinOp(v, path)
+	case "DATA":
+		return decodeData(v, path)
+	case "VAR":
+		return decodeVariable(v, path)
+	}
+	return nil, decodeFail(path+".type", "Unknown node type '"+nodeType+"'")
+}
+
+func decodeProgram(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "functions", "body", "type")
+	if e != nil {
+		return nil, e
+	}
+	funcs, e := decodeFunctions(object, "functions", path)
+	if e != nil {
+		return nil, e
+	}
+	exec, e := decodeChild(object, "body", path)
+	if e != nil {
+		return nil, e
+	}
+	return &Program{funcs: funcs, exec: exec}, nil
+}
+
+func decodeFunction(v interface{}, path string) (*Function, *decodeError) {
+	object, e := decodeObject(v, path, "name", "params", "annotations", "defaults", "rest", "result", "functions", "body", "type")
+	if e != nil {
+		return nil, e
+	}
+	name, e := decodeName(object, "name", path)
+	if e != nil {
+		return nil, e
+	}
+	f, e := decodeDefinition(object, path)
+	if e != nil {
+		return nil, e
+	}
+	f.name = name
+	return f, nil
+}
+
+func decodeLambda(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "params", "annotations", "defaults", "rest", "result", "functions", "body", "type")
+	if e != nil {
+		return nil, e
+	}
+	f, e := decodeDefinition(object, path)
+	if e != nil {
+		return nil, e
+	}
+	return &Lambda{fn: f}, nil
+}
+
+/* The params, result and body shared by named functions and lambdas */
+func decodeDefinition(object map[string]interface{}, path string) (*Function, *decodeError) {
+	f := &Function{}
+	var e *decodeError
+	if f.params, e = decodeNames(object, "params", path); e != nil {
+		return nil, e
+	} else if f.annotations, e = decodeAnnotations(object, "annotations", path, len(f.params)); e != nil {
+		return nil, e
+	} else if f.defaults, e = decodeChildren(object, "defaults", path); e != nil {
+		return nil, e
+	} else if len(f.defaults) > len(f.params) {
+		return nil, decodeFail(path+".defaults", "More default values than params")
+	} else if f.rest, e = decodeString(object, "rest", path); e != nil {
+		return nil, e
+	} else if f.result, e = decodeString(object, "result", path); e != nil {
+		return nil, e
+	} else if e = checkAnnotation(f.result, path+".result"); e != nil {
+		return nil, e
+	} else if f.funcs, e = decodeFunctions(object, "functions", path); e != nil {
+		return nil, e
+	} else if f.exec, e = decodeChild(object, "body", path); e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+func decodeLet(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "names", "annotations", "values", "functions", "body", "type")
+	if e != nil {
+		return nil, e
+	}
+	l := &Let{}
+	if l.params, e = decodeNames(object, "names", path); e != nil {
+		return nil, e
+	} else if l.annotations, e = decodeAnnotations(object, "annotations", path, len(l.params)); e != nil {
+		return nil, e
+	} else if l.values, e = decodeChildren(object, "values", path); e != nil {
+		return nil, e
+	} else if len(l.values) != len(l.params) {
+		return nil, decodeFail(path+".values", "Expected "+strconv.Itoa(len(l.params))+" values, got "+strconv.Itoa(len(l.values)))
+	} else if l.funcs, e = decodeFunctions(object, "functions", path); e != nil {
+		return nil, e
+	} else if l.exec, e = decodeChild(object, "body", path); e != nil {
+		return nil, e
+	}
+	return l, nil
+}
+
+func decodeMatch(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "matchType", "branches", "conditions", "type")
+	if e != nil {
+		return nil, e
+	}
+	m := &Match{}
+	matchType, e := decodeString(object, "matchType", path)
+	if e != nil {
+		return nil, e
+	}
+	switch matchType {
+	case "MATCH_ALL":
+		m.matchType = ALL
+	case "MATCH_FIRST":
+		m.matchType = FIRST
+	default:
+		return nil, decodeFail(path+".matchType", "Unknown match type '"+matchType+"'")
+	}
+
+	if m.conditions, e = decodeChildren(object, "conditions", path); e != nil {
+		return nil, e
+	} else if m.branches, e = decodeChildren(object, "branches", path); e != nil {
+		return nil, e
+	} else if len(m.conditions) != len(m.branches) || len(m.conditions) == 0 {
+		return nil, decodeFail(path, "Invalid number of conditions and branches")
+	}
+	return m, nil
+}
+
+func decodeRepeat(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "condition", "body", "type")
+	if e != nil {
+		return nil, e
+	}
+	r := &Repeat{}
+	if r.condition, e = decodeChild(object, "condition", path); e != nil {
+		return nil, e
+	} else if r.exec, e = decodeChild(object, "body", path); e != nil {
+		return nil, e
+	}
+	return r, nil
+}
+
+func decodeAssign(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "name", "value", "type")
+	if e != nil {
+		return nil, e
+	}
+	a := &Assign{}
+	if a.name, e = decodeName(object, "name", path); e != nil {
+		return nil, e
+	} else if a.value, e = decodeChild(object, "value", path); e != nil {
+		return nil, e
+	}
+	return a, nil
+}
+
+func decodeConcat(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "chunks", "type")
+	if e != nil {
+		return nil, e
+	}
+	components, e := decodeChildren(object, "chunks", path)
+	if e != nil {
+		return nil, e
+	}
+	return &Concat{components: components}, nil
+}
+
+func decodeCall(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "params", "name", "type")
+	if e != nil {
+		return nil, e
+	}
+	c := &Call{}
+	if c.name, e = decodeName(object, "name", path); e != nil {
+		return nil, e
+	} else if c.params, e = decodeChildren(object, "params", path); e != nil {
+		return nil, e
+	}
+	return c, nil
+}
+
+func decodeNot(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "expression", "type")
+	if e != nil {
+		return nil, e
+	}
+	exec, e := decodeChild(object, "expression", path)
+	if e != nil {
+		return nil, e
+	}
+	return &Not{exec: exec}, nil
+}
+
+func decodeBinOp(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "opType", "l", "r", "type")
+	if e != nil {
+		return nil, e
+	}
+	name, e := decodeString(object, "opType", path)
+	if e != nil {
+		return nil, e
+	}
+	b := &BinOp{op: -1}
+	for op := ADD; op <= OR; op++ {
+		if op.String() == name {
+			b.op = op
+		}
+	}
+	if b.op < 0 {
+		return nil, decodeFail(path+".opType", "Unknown operator '"+name+"'")
+	} else if b.l, e = decodeChild(object, "l", path); e != nil {
+		return nil, e
+	} else if b.r, e = decodeChild(object, "r", path); e != nil {
+		return nil, e
+	}
+	return b, nil
+}
+
+func decodeData(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "dataType", "value", "type")
+	if e != nil {
+		return nil, e
+	}
+	dataType, e := decodeString(object, "dataType", path)
+	if e != nil {
+		return nil, e
+	}
+	switch dataType {
+	case "NUMBER":
+		if num, ok := object["value"].(float64); ok {
+			return &Data{num: num, dataType: NUMBER}, nil
+		}
+		return nil, decodeFail(path+".value", "Expected a number")
+	case "STRING":
+		str, e := decodeString(object, "value", path)
+		if e != nil {
+			return nil, e
+		}
+		return &Data{str: str, dataType: STRING}, nil
+	}
+	return nil, decodeFail(path+".dataType", "Unknown data type '"+dataType+"'")
+}
+
+func decodeVariable(v interface{}, path string) (AstNode, *decodeError) {
+	object, e := decodeObject(v, path, "name", "type")
+	if e != nil {
+		return nil, e
+	}
+	name, e := decodeName(object, "name", path)
+	if e != nil {
+		return nil, e
+	}
+	return &Variable{name: name}, nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code_test
+
+import (
+	"bytes"
+	"github.com/rkophs/presta/code"
+	"github.com/rkophs/presta/err"
+	"strings"
+	"testing"
+)
+
+const (
+	ONE  = `{"dataType":"NUMBER","value":1,"type":"DATA"}`
+	TWO  = `{"dataType":"NUMBER","value":2,"type":"DATA"}`
+	FUNC = `{"name":"f","params":["a"],"annotations":[""],"defaults":[],"rest":"","result":"","functions":[],"body":{"name":"a","type":"VAR"},"type":"FUNC"}`
+	PROG = `{"functions":[],"body":` + ONE + `,"type":"PROG"}`
+)
+
+func binop(l, r string) string {
+	return `{"opType":"+","l":` + l + `,"r":` + r + `,"type":"BINOP"}`
+}
+
+func program(funcs, body string) string {
+	return `{"functions":[` + funcs + `],"body":` + body + `,"type":"PROG"}`
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		program("", binop(ONE, TWO)),
+		program(FUNC, `{"params":[`+ONE+`],"name":"f","type":"CALL"}`),
+		binop(ONE, TWO),
+	} {
+		node, e := code.Decode(strings.NewReader(src))
+		if e != nil {
+			t.Errorf("%s: %s", src, e.Message())
+			continue
+		}
+		var b bytes.Buffer
+		node.Serialize(&b)
+		if b.String() != src {
+			t.Errorf("got %s, want %s", b.String(), src)
+		}
+	}
+}
+
+func TestDecodeRejectsProgramBelowRoot(t *testing.T) {
+	for _, src := range []string{
+		program("", binop(PROG, TWO)),
+		binop(ONE, PROG),
+		program("", `{"expression":`+PROG+`,"type":"NOT"}`),
+	} {
+		_, e := code.Decode(strings.NewReader(src))
+		if e == nil {
+			t.Errorf("%s: decoded a nested PROG node", src)
+		} else if e.Code() != err.LOAD_ERROR || !strings.Contains(e.Message(), "PROG node is only allowed at the root") {
+			t.Errorf("%s: got %q", src, e.Message())
+		}
+	}
+}
+
+func TestDecodeRejectsFunctionOutsideFunctions(t *testing.T) {
+	for _, src := range []string{
+		program("", binop(FUNC, TWO)),
+		program(FUNC, FUNC),
+		FUNC,
+	} {
+		_, e := code.Decode(strings.NewReader(src))
+		if e == nil {
+			t.Errorf("%s: decoded a FUNC node outside a functions list", src)
+		} else if e.Code() != err.LOAD_ERROR || !strings.Contains(e.Message(), "FUNC node is only allowed in a functions list") {
+			t.Errorf("%s: got %q", src, e.Message())
+		}
+	}
+
+	src := program(ONE, ONE)
+	if _, e := code.Decode(strings.NewReader(src)); e == nil || !strings.Contains(e.Message(), "Expected a FUNC node") {
+		t.Errorf("%s: decoded a functions list holding an expression", src)
+	}
+}
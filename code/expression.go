@@ -49,6 +49,12 @@ func NewExpression(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return validExprEnding(p, node, parens, readCount)
 	}
 
+	if node, e := NewLambdaExpr(p); e != nil {
+		return parseError(p, e.Message(), readCount)
+	} else if node != nil {
+		return validExprEnding(p, node, parens, readCount)
+	}
+
 	if node, e := parseUnaryExpression(p); e != nil {
 		return parseError(p, e.Message(), readCount)
 	} else if node != nil {
@@ -134,6 +140,7 @@ func parseUnaryExpression(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 
 func parseIncrExpression(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/* Get op type */
 	var opType BinOpType
@@ -158,11 +165,11 @@ func parseIncrExpression(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseError(p, "Inc/Dec operator must precede an identifier", readCount)
 	} else {
 		name := tok.Lit()
-		variable = &Variable{name: name}
+		variable = &Variable{position: position{tok.Position()}, name: name}
 	}
 
-	one := &Data{dataType: NUMBER, num: 1}
-	node := &BinOp{l: variable, r: one, op: opType}
+	one := &Data{position: position{pos}, dataType: NUMBER, num: 1}
+	node := &BinOp{position: position{pos}, l: variable, r: one, op: opType}
 	return parseValid(p, node)
 }
 
@@ -181,6 +188,7 @@ func parseBinaryExpression(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseValid(p, node)
 	}
 
+	pos := peekPosition(p)
 	readCount++
 	tok, eof := p.Read()
 	if eof {
@@ -188,41 +196,41 @@ func parseBinaryExpression(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	}
 	switch tok.Type() {
 	case parser.GT:
-		return NewBinOp(p, GT, readCount)
+		return NewBinOp(p, GT, pos, readCount)
 	case parser.LT:
-		return NewBinOp(p, LT, readCount)
+		return NewBinOp(p, LT, pos, readCount)
 	case parser.GTE:
-		return NewBinOp(p, GTE, readCount)
+		return NewBinOp(p, GTE, pos, readCount)
 	case parser.LTE:
-		return NewBinOp(p, LTE, readCount)
+		return NewBinOp(p, LTE, pos, readCount)
 	case parser.EQ:
-		return NewBinOp(p, EQ, readCount)
+		return NewBinOp(p, EQ, pos, readCount)
 	case parser.NEQ:
-		return NewBinOp(p, NEQ, readCount)
+		return NewBinOp(p, NEQ, pos, readCount)
 	case parser.OR:
-		return NewBinOp(p, OR, readCount)
+		return NewBinOp(p, OR, pos, readCount)
 	case parser.AND:
-		return NewBinOp(p, AND, readCount)
+		return NewBinOp(p, AND, pos, readCount)
 	case parser.ADD:
-		return NewBinOp(p, ADD, readCount)
+		return NewBinOp(p, ADD, pos, readCount)
 	case parser.SUB:
-		return NewBinOp(p, SUB, readCount)
+		return NewBinOp(p, SUB, pos, readCount)
 	case parser.MULT:
-		return NewBinOp(p, MULT, readCount)
+		return NewBinOp(p, MULT, pos, readCount)
 	case parser.DIV:
-		return NewBinOp(p, DIV, readCount)
+		return NewBinOp(p, DIV, pos, readCount)
 	case parser.MOD:
-		return NewBinOp(p, MOD, readCount)
+		return NewBinOp(p, MOD, pos, readCount)
 	case parser.ADD_I:
-		return NewBinOp(p, ADD_I, readCount)
+		return NewBinOp(p, ADD_I, pos, readCount)
 	case parser.SUB_I:
-		return NewBinOp(p, SUB_I, readCount)
+		return NewBinOp(p, SUB_I, pos, readCount)
 	case parser.MULT_I:
-		return NewBinOp(p, MULT_I, readCount)
+		return NewBinOp(p, MULT_I, pos, readCount)
 	case parser.DIV_I:
-		return NewBinOp(p, DIV_I, readCount)
+		return NewBinOp(p, DIV_I, pos, readCount)
 	case parser.MOD_I:
-		return NewBinOp(p, MOD_I, readCount)
+		return NewBinOp(p, MOD_I, pos, readCount)
 	default:
 		return parseExit(p, readCount)
 	}
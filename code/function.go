@@ -30,18 +30,43 @@ import (
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/types"
 )
 
 type Function struct {
+	position
 	json.Serializable
-	name   string
-	params []string
-	exec   AstNode
+	name        string
+	params      []string
+	annotations []string          //Declared type of each param, empty if none
+	defaults    []AstNode         //Default values of the trailing optional params
+	rest        string            //Collects extra arguments, empty if none
+	positions   []parser.Position //Where each param and the rest are named, none if built
+	result      string            //Declared result type, empty if none
+	funcs       []*Function
+	exec        AstNode
+}
+
+/* Parse zero or more function declarations preceding an expression */
+func parseFunctions(p *parser.TokenScanner) (funcs []*Function, e err.Error) {
+	functions := []*Function{}
+	for {
+		if function, e := NewFunction(p); e != nil {
+			return functions, e
+		} else if function != nil {
+			functions = append(functions, function.(*Function))
+		} else {
+			return functions, nil
+		}
+	}
 }
 
 func NewFunction(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Check if it starts with '~' */
 	readCount++
@@ -51,6 +76,11 @@ func NewFunction(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseExit(p, readCount)
 	}
 
+	/*Anonymous functions are expressions*/
+	if tok, eof := p.Peek(); !eof && tok.Type() == parser.PAREN_OPEN {
+		return parseExit(p, readCount)
+	}
+
 	/*Check for identifier*/
 	readCount++
 	tok, eof := p.Read()
@@ -59,69 +89,226 @@ func NewFunction(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	} else if tok.Type() != parser.IDENTIFIER {
 		return parseError(p, "Function name must follow ~", readCount)
 	}
-	funcName := tok.Lit()
+
+	if node, e := parseFunctionDefinition(p, tok.Lit(), pos); e != nil {
+		return parseError(p, e.Message(), readCount)
+	} else {
+		return parseValid(p, node)
+	}
+}
+
+/* Parse the params and body shared by named and anonymous functions:
+ * (params)(functions... expression) */
+func parseFunctionDefinition(p *parser.TokenScanner, name string, pos parser.Position) (*Function, err.Error) {
 
 	/* Check for parenthesis */
-	readCount++
 	if tok, eof := p.Read(); eof {
-		return parseError(p, "Premature end.", readCount)
+		return nil, err.NewSyntaxError("Premature end.")
 	} else if tok.Type() != parser.PAREN_OPEN {
-		return parseError(p, "Parenthesis must follow function name", readCount)
+		return nil, err.NewSyntaxError("Parenthesis must follow function name")
 	}
 
-	/* Check for param names */
-	params := []string{}
-	for {
-		readCount++
-		if tok, eof := p.Read(); eof {
-			return parseError(p, "Premature end.", readCount)
-		} else if tok.Type() == parser.IDENTIFIER {
-			params = append(params, tok.Lit())
-		} else if tok.Type() == parser.PAREN_CLOSE {
-			break
-		} else {
-			return parseError(p, "Looking for parameter identifiers for function", readCount)
-		}
+	/* Check for params */
+	f := &Function{position: position{pos}, name: name}
+	if e := f.parseParams(p); e != nil {
+		return nil, e
+	}
+
+	/* Check for a result annotation */
+	if result, e := parseAnnotation(p, "the result of "+f.describe()); e != nil {
+		return nil, e
+	} else {
+		f.result = result
 	}
 
 	/*Check for parenthesis*/
-	readCount++
 	if tok, eof := p.Read(); eof {
-		return parseError(p, "Premature end.", readCount)
+		return nil, err.NewSyntaxError("Premature end.")
 	} else if tok.Type() != parser.PAREN_OPEN {
-		return parseError(p, "'(' must prefix function body", readCount)
+		return nil, err.NewSyntaxError("'(' must prefix function body")
+	}
+
+	/* Check for nested function declarations */
+	funcs, e := parseFunctions(p)
+	if e != nil {
+		return nil, e
 	}
+	f.funcs = funcs
 
 	/* Check for expression */
-	expr, err := NewExpression(p)
-	if err != nil {
-		return parseError(p, err.Message(), readCount)
+	expr, e := NewExpression(p)
+	if e != nil {
+		return nil, e
 	} else if expr == nil {
-		return parseError(p, "Function body must be an executable expression", readCount)
+		return nil, err.NewSyntaxError("Function body must be an executable expression")
 	}
 
 	/*Check for parenthesis*/
-	readCount++
 	if tok, eof := p.Read(); eof {
-		return parseError(p, "Premature end.", readCount)
+		return nil, err.NewSyntaxError("Premature end.")
 	} else if tok.Type() != parser.PAREN_CLOSE {
-		return parseError(p, "Parenthesis must postfix function body", readCount)
+		return nil, err.NewSyntaxError("Parenthesis must postfix function body")
 	}
 
-	node := &Function{name: funcName, params: params, exec: expr}
-	return parseValid(p, node)
+	f.exec = expr
+	return f, nil
 }
 
-func (p *Function) Serialize(buffer *bytes.Buffer) {
+/* Parse a type annotation (:type) of what if one follows. A ':' followed
+ * by a name always starts an annotation, so a default value naming a
+ * variable is parenthesized, name:(variable). A ':' followed by anything
+ * else is left alone, it may start a default value */
+func parseAnnotation(p *parser.TokenScanner, what string) (string, err.Error) {
+	if tok, eof := p.Peek(); eof || tok.Type() != parser.ASSIGN {
+		return "", nil
+	}
+	p.Read()
+	tok, eof := p.Peek()
+	if eof || tok.Type() != parser.IDENTIFIER {
+		p.Unread()
+		return "", nil
+	}
+	p.Read()
+	if e := namedType(tok.Lit(), what); e != nil {
+		return "", e
+	}
+	return tok.Lit(), nil
+}
+
+/* An annotation must name a type, or be empty for none */
+func namedType(annotation string, what string) err.Error {
+	if _, ok := types.Named(annotation); !ok && annotation != "" {
+		return err.NewSyntaxError("Unknown type '" + annotation + "' for " + what)
+	}
+	return nil
+}
+
+/* Parse a parameter list up to its closing parenthesis: required names,
+ * then optional names with a default value (name:value), then an optional
+ * rest param (...name). Any param but the rest may be annotated with its
+ * type, before its default value (name:type:value) */
+func (f *Function) parseParams(p *parser.TokenScanner) err.Error {
+	f.params = []string{}
+	f.annotations = []string{}
+	f.defaults = []AstNode{}
+	for {
+		tok, eof := p.Read()
+		if eof {
+			return err.NewSyntaxError("Premature end.")
+		} else if tok.Type() == parser.PAREN_CLOSE {
+			return nil
+		} else if tok.Type() == parser.REST {
+			break
+		} else if tok.Type() != parser.IDENTIFIER {
+			return err.NewSyntaxError("Looking for parameter identifiers for function")
+		}
+		name := tok.Lit()
+		f.positions = append(f.positions, tok.Position())
+		if annotation, e := parseAnnotation(p, "parameter '"+name+"'"); e != nil {
+			return e
+		} else {
+			f.annotations = append(f.annotations, annotation)
+		}
 
+		/* Check for a default value */
+		if next, eof := p.Peek(); !eof && next.Type() == parser.ASSIGN {
+			p.Read()
+			if value, e := NewExpression(p); e != nil {
+				return e
+			} else if value == nil {
+				return err.NewSyntaxError("Parameter '" + name + "' is missing its default value")
+			} else {
+				f.defaults = append(f.defaults, value)
+			}
+		} else if len(f.defaults) > 0 {
+			return err.NewSyntaxError("Required parameter '" + name + "' cannot follow optional parameters")
+		}
+		f.params = append(f.params, name)
+	}
+
+	/* Check for the rest param, which must be last */
+	if tok, eof := p.Read(); eof {
+		return err.NewSyntaxError("Premature end.")
+	} else if tok.Type() != parser.IDENTIFIER {
+		return err.NewSyntaxError("Rest parameter must be named")
+	} else {
+		f.rest = tok.Lit()
+		f.positions = append(f.positions, tok.Position())
+	}
+
+	if tok, eof := p.Read(); eof {
+		return err.NewSyntaxError("Premature end.")
+	} else if tok.Type() != parser.PAREN_CLOSE {
+		return err.NewSyntaxError("Rest parameter '" + f.rest + "' must be last")
+	}
+	return nil
+}
+
+func (f *Function) describe() string {
+	if f.name == "" {
+		return "anonymous function"
+	}
+	return "'" + f.name + "'"
+}
+
+func (f *Function) signature() system.Params {
+	required := len(f.params) - len(f.defaults)
+	return system.Params{Required: required, Optional: len(f.defaults), Rest: f.rest != ""}
+}
+
+/* Every name bound in the argument area, in slot order */
+/* The positions names are declared at, or pos for all of them when the
+ * node was built rather than parsed */
+func namePositions(names []string, positions []parser.Position, pos parser.Position) []parser.Position {
+	if len(positions) == len(names) {
+		return positions
+	}
+	positions = make([]parser.Position, len(names))
+	for i := range positions {
+		positions[i] = pos
+	}
+	return positions
+}
+
+func (f *Function) names() []string {
+	if f.rest != "" {
+		return append(append([]string{}, f.params...), f.rest)
+	}
+	return f.params
+}
+
+func (f *Function) serializeParams() (*json.Array, *json.Array) {
 	params := []json.Serializable{}
-	for _, param := range p.params {
+	for _, param := range f.params {
 		params = append(params, json.NewString(param))
 	}
 
+	defaults := []json.Serializable{}
+	for _, value := range f.defaults {
+		defaults = append(defaults, value)
+	}
+	return json.NewArray(params), json.NewArray(defaults)
+}
+
+func serializeAnnotations(annotations []string) *json.Array {
+	names := []json.Serializable{}
+	for _, annotation := range annotations {
+		names = append(names, json.NewString(annotation))
+	}
+	return json.NewArray(names)
+}
+
+func (p *Function) Serialize(buffer *bytes.Buffer) {
+
+	params, defaults := p.serializeParams()
 	json.BuildMap(buffer,
 		&json.KV{K: "name", V: json.NewString(p.name)},
-		&json.KV{K: "params", V: json.NewArray(params)},
+		&json.KV{K: "params", V: params},
+		&json.KV{K: "annotations", V: serializeAnnotations(p.annotations)},
+		&json.KV{K: "defaults", V: defaults},
+		&json.KV{K: "rest", V: json.NewString(p.rest)},
+		&json.KV{K: "result", V: json.NewString(p.result)},
+		&json.KV{K: "functions", V: serializeFunctions(p.funcs)},
 		&json.KV{K: "body", V: p.exec},
 		&json.KV{K: "type", V: json.NewString("FUNC")})
 }
@@ -130,21 +317,289 @@ func (f *Function) Type() AstNodeType {
 	return FUNC
 }
 
+/* A param of a function, optional when it has a default value */
+type Param struct {
+	Name       string
+	Annotation string  //Declared type, empty if none
+	Default    AstNode //Nil for required params
+}
+
+/* A function declaration. Required params must come before optional
+ * ones; rest, if not empty, collects extra arguments. */
+func NewFunctionNode(name string, params []*Param, rest string, result string, funcs []*Function, body AstNode) (*Function, err.Error) {
+	f := &Function{name: name, rest: rest, result: result, funcs: funcs, exec: body}
+	if body == nil {
+		return nil, err.NewSyntaxError("A function needs a body")
+	} else if e := checkFunctions(funcs); e != nil {
+		return nil, e
+	} else if e := namedType(result, "the result of "+f.describe()); e != nil {
+		return nil, e
+	} else if e := f.setParams(params); e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+func (f *Function) setParams(params []*Param) err.Error {
+	f.params, f.annotations, f.defaults = []string{}, []string{}, []AstNode{}
+	for _, param := range params {
+		if param == nil || param.Name == "" {
+			return err.NewSyntaxError("A parameter needs a name")
+		} else if e := namedType(param.Annotation, "parameter '"+param.Name+"'"); e != nil {
+			return e
+		}
+		if param.Default != nil {
+			f.defaults = append(f.defaults, param.Default)
+		} else if len(f.defaults) > 0 {
+			return err.NewSyntaxError("Required parameter '" + param.Name + "' cannot follow optional parameters")
+		}
+		f.params = append(f.params, param.Name)
+		f.annotations = append(f.annotations, param.Annotation)
+	}
+	return nil
+}
+
+/* The name of a declaration, empty for the function of a lambda */
+func (f *Function) Name() string {
+	return f.name
+}
+
+func (f *Function) Params() []*Param {
+	params := make([]*Param, len(f.params))
+	required := len(f.params) - len(f.defaults)
+	for i, name := range f.params {
+		params[i] = &Param{Name: name, Annotation: f.annotations[i]}
+		if i >= required {
+			params[i].Default = f.defaults[i-required]
+		}
+	}
+	return params
+}
+
+func (f *Function) Rest() string {
+	return f.rest
+}
+
+func (f *Function) Result() string {
+	return f.result
+}
+
+func (f *Function) Functions() []*Function {
+	return f.funcs
+}
+
+func (f *Function) Body() AstNode {
+	return f.exec
+}
+
+func serializeFunctions(funcs []*Function) *json.Array {
+	fns := []json.Serializable{}
+	for _, fn := range funcs {
+		fns = append(fns, fn)
+	}
+	return json.NewArray(fns)
+}
+
+/* Register the functions in the current scope, then generate each body
+ * into its own block which the linker appends after the program */
+func declareFunctions(funcs []*Function, code *icg.Code, s *parser.Semantic) err.Error {
+	for _, f := range funcs {
+		id := s.AddFunction(f.name, f.signature(), s.Captured(f))
+		code.SetFunctionOffset(id, ir.NewInstructionLocation(-1))
+		code.SetFunctionName(id, f.name)
+	}
+
+	for _, f := range funcs {
+		block := code.NewBlock()
+		if e := f.GenerateICG(block, s); e != nil {
+			return e
+		}
+		code.AddFunctionBlock(code.GetFunctionOffset(s.GetFunctionId(f.name)), block)
+	}
+
+	return nil
+}
+
+/* A function using variables of enclosing frames reads them from the
+ * closure environment its function values are built with */
 func (f *Function) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
+	s.PushFunctionScope(f.names(), s.Captured(f))
+	defer s.PopScope()
+	return generateFunctionBody(code, s, f)
+}
+
+/* Generate a function body into its own block. The caller must already
+ * have pushed the scope holding the params. */
+func generateFunctionBody(code *icg.Code, s *parser.Semantic, f *Function) err.Error {
+
+	//Instantiate stack accessors for each param, which sit just below BP
+	names := f.names()
+	for i, p := range names {
+		code.SetVariable(s.GetVariableId(p), ir.NewStackAccess(-1*len(names)+i))
+	}
+
+	//BP+0 holds the function value being run
+	code.IncrFrameOffset(1)
+	code.SetParamCount(len(names))
+
+	//Evaluate the defaults of optional params the caller did not pass
+	required := f.signature().Required
+	for i, value := range f.defaults {
+		slot := ir.NewStackAccess(-1*len(names) + required + i)
+		skip := code.NewLabel()
+		code.Append(ir.NewJumpDefined(slot, skip))
+		if e := value.GenerateICG(code, s); e != nil {
+			return e
+		}
+		code.Append(ir.NewMov(slot, code.Ax))
+		code.PlaceLabel(skip)
+	}
+
+	//Guard the annotated params, once defaults are in place
+	for i, annotation := range f.annotations {
+		if annotation != "" {
+			code.Append(ir.NewGuard(ir.NewStackAccess(-1*len(names)+i), annotation, "Parameter '"+f.params[i]+"'"))
+		}
+	}
 
-	//Instantiate stack accessors for each param
-	s.PushNewScope(f.params)
-	for i, p := range f.params {
-		code.SetVariable(s.GetVariableId(p), ir.NewStackAccess(-1*len(f.params)+i))
+	//Nested functions are visible to the body and to each other
+	if e := declareFunctions(f.funcs, code, s); e != nil {
+		return e
 	}
 
-	//Code generate the body
+	//Code generate the body, whose value is the function result. A tail
+	//call would skip the result guard, so annotated results disable it
+	if f.result == "" {
+		code.MarkTail(f.exec)
+	}
 	if e := f.exec.GenerateICG(code, s); e != nil {
 		return e
 	}
+	if f.result != "" {
+		code.Append(ir.NewGuard(code.Ax, f.result, "Result of "+f.describe()))
+	}
 
-	//Load result into AX and shrink the stack
+	//Return the result in AX, the VM discards the frame
 	code.Append(ir.NewResult(code.Ax))
 
 	return nil
 }
+
+/* Infer a group of declarations together so they may be mutually
+ * recursive, then generalize their types */
+func inferFunctions(funcs []*Function, c *types.Checker) {
+	c.EnterLevel()
+	signatures := make([]*types.Type, len(funcs))
+	for i, f := range funcs {
+		signatures[i] = f.freshType(c)
+		c.BindFunction(f.name, signatures[i])
+	}
+	for i, f := range funcs {
+		f.inferBody(c, signatures[i])
+	}
+	c.ExitLevel()
+
+	for i, f := range funcs {
+		c.Generalize(signatures[i])
+		c.Record(f, signatures[i])
+	}
+}
+
+func (f *Function) freshType(c *types.Checker) *types.Type {
+	params := make([]*types.Type, len(f.params))
+	for i := range f.params {
+		params[i] = c.Declared(f.annotations[i])
+	}
+	var rest *types.Type
+	if f.rest != "" {
+		rest = c.Declared("")
+	}
+	return types.NewFunction(params, f.signature().Required, rest, c.Declared(f.result))
+}
+
+func (f *Function) inferBody(c *types.Checker, t *types.Type) {
+	c.PushScope()
+	defer c.PopScope()
+
+	for i, name := range f.params {
+		c.BindVariable(name, t.Params()[i])
+	}
+	if f.rest != "" {
+		c.BindVariable(f.rest, types.NewList(t.Rest()))
+	}
+
+	required := f.signature().Required
+	for i, value := range f.defaults {
+		c.Expect(value.Position(), value.Infer(c), t.Params()[required+i], "Parameter '"+f.params[required+i]+"'")
+	}
+
+	inferFunctions(f.funcs, c)
+	c.Expect(f.exec.Position(), f.exec.Infer(c), t.Result(), "Function body")
+}
+
+func (f *Function) Infer(c *types.Checker) *types.Type {
+	inferFunctions([]*Function{f}, c)
+	return c.TypeOf(f)
+}
+
+/* Declare a group of functions before resolving any body, so they may
+ * reference each other in any order */
+func resolveFunctions(funcs []*Function, r *parser.Resolver) err.Error {
+	for _, f := range funcs {
+		if e := r.DeclareFunction(f, f.name, f.Position()); e != nil {
+			return e
+		}
+	}
+	for _, f := range funcs {
+		if e := f.resolveBody(r); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (f *Function) resolveBody(r *parser.Resolver) err.Error {
+	r.PushFunctionScope(f)
+	defer r.PopScope()
+
+	names := f.names()
+	if e := r.DeclareParams(f, names, namePositions(names, f.positions, f.Position())); e != nil {
+		return e
+	}
+	for _, value := range f.defaults {
+		if e := value.Resolve(r); e != nil {
+			return e
+		}
+	}
+	if e := resolveFunctions(f.funcs, r); e != nil {
+		return e
+	}
+	return f.exec.Resolve(r)
+}
+
+func (f *Function) Resolve(r *parser.Resolver) err.Error {
+	return resolveFunctions([]*Function{f}, r)
+}
+
+func lintFunctions(funcs []*Function, l *lint.Linter) {
+	for _, f := range funcs {
+		f.Lint(l)
+	}
+}
+
+func (f *Function) Lint(l *lint.Linter) {
+	for _, value := range f.defaults {
+		value.Lint(l)
+	}
+	lintFunctions(f.funcs, l)
+	f.exec.Lint(l)
+}
+
+func (f *Function) Optimize(o *Optimizer) AstNode {
+	for i, value := range f.defaults {
+		f.defaults[i] = value.Optimize(o)
+	}
+	optimizeFunctions(f.funcs, o)
+	f.exec = f.exec.Optimize(o)
+	return f
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code
+
+import (
+	"bytes"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/icg"
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
+	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
+)
+
+type Lambda struct {
+	position
+	fn *Function
+}
+
+func NewLambdaExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
+	readCount := 0
+	pos := peekPosition(p)
+
+	/*Check if it starts with '~' */
+	readCount++
+	if tok, eof := p.Read(); eof {
+		return parseError(p, "Premature end.", readCount)
+	} else if tok.Type() != parser.FUNC {
+		return parseExit(p, readCount)
+	}
+
+	/* Check for parenthesis */
+	if tok, eof := p.Peek(); eof {
+		return parseError(p, "Premature end.", readCount)
+	} else if tok.Type() != parser.PAREN_OPEN {
+		return parseExit(p, readCount) //Named function declaration
+	}
+
+	if fn, e := parseFunctionDefinition(p, "", pos); e != nil {
+		return parseError(p, e.Message(), readCount)
+	} else {
+		node := &Lambda{position: position{pos}, fn: fn}
+		return parseValid(p, node)
+	}
+}
+
+func (l *Lambda) Type() AstNodeType {
+	return LAMBDA
+}
+
+/* An anonymous function, with params as for NewFunctionNode */
+func NewLambdaNode(params []*Param, rest string, result string, funcs []*Function, body AstNode) (*Lambda, err.Error) {
+	fn, e := NewFunctionNode("", params, rest, result, funcs, body)
+	if e != nil {
+		return nil, e
+	}
+	return &Lambda{fn: fn}, nil
+}
+
+/* The unnamed function the lambda evaluates to */
+func (l *Lambda) Function() *Function {
+	return l.fn
+}
+
+func (l *Lambda) Serialize(buffer *bytes.Buffer) {
+
+	params, defaults := l.fn.serializeParams()
+	json.BuildMap(buffer,
+		&json.KV{K: "params", V: params},
+		&json.KV{K: "annotations", V: serializeAnnotations(l.fn.annotations)},
+		&json.KV{K: "defaults", V: defaults},
+		&json.KV{K: "rest", V: json.NewString(l.fn.rest)},
+		&json.KV{K: "result", V: json.NewString(l.fn.result)},
+		&json.KV{K: "functions", V: serializeFunctions(l.fn.funcs)},
+		&json.KV{K: "body", V: l.fn.exec},
+		&json.KV{K: "type", V: json.NewString("LAMBDA")})
+}
+
+func (l *Lambda) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
+
+	//Generate the body into its own block, collecting free variables
+	location := ir.NewInstructionLocation(-1)
+	block := code.NewBlock()
+	s.PushLambdaScope(l.fn.names())
+	if e := generateFunctionBody(block, s, l.fn); e != nil {
+		s.PopScope()
+		return e
+	}
+	captures := s.Captures()
+	s.PopScope()
+	code.AddFunctionBlock(location, block)
+
+	//Captured values are resolved in the enclosing scope
+	accessors := make([]ir.Accessor, len(captures))
+	for i, name := range captures {
+		if access, e := variableAccess(name, code, s); e != nil {
+			return e
+		} else {
+			accessors[i] = access
+		}
+	}
+
+	code.Append(ir.NewClosure(code.Ax, location, l.fn.signature(), accessors...))
+	return nil
+}
+
+func (l *Lambda) Infer(c *types.Checker) *types.Type {
+	t := l.fn.freshType(c)
+	l.fn.inferBody(c, t)
+	return c.Record(l, t)
+}
+
+func (l *Lambda) Resolve(r *parser.Resolver) err.Error {
+	return l.fn.resolveBody(r)
+}
+
+func (l *Lambda) Lint(linter *lint.Linter) {
+	l.fn.Lint(linter)
+}
+
+func (l *Lambda) Optimize(o *Optimizer) AstNode {
+	l.fn.Optimize(o)
+	return l
+}
@@ -28,18 +28,26 @@ import (
 	"bytes"
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
+	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Let struct {
-	params []string
-	values []AstNode
-	exec   AstNode
+	position
+	params      []string
+	annotations []string          //Declared type of each binding, empty if none
+	positions   []parser.Position //Where each binding is named, none if built
+	values      []AstNode
+	funcs       []*Function
+	exec        AstNode
 }
 
 func NewLetExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Check if it starts with ':' */
 	readCount++
@@ -60,12 +68,20 @@ func NewLetExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 
 	/* Check for param names and closing parenthesis*/
 	params := []string{}
+	annotations := []string{}
+	positions := []parser.Position{}
 	for {
 		readCount++
 		if tok, eof := p.Read(); eof {
 			return parseError(p, "Premature end.", readCount)
 		} else if tok.Type() == parser.IDENTIFIER {
 			params = append(params, tok.Lit())
+			positions = append(positions, tok.Position())
+			if annotation, e := parseAnnotation(p, "binding '"+tok.Lit()+"'"); e != nil {
+				return parseError(p, e.Message(), readCount)
+			} else {
+				annotations = append(annotations, annotation)
+			}
 		} else if tok.Type() == parser.PAREN_CLOSE {
 			break
 		} else {
@@ -105,6 +121,12 @@ func NewLetExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseError(p, "Missing closing parenthesis for let assignments", readCount)
 	}
 
+	/* Check for local function declarations */
+	funcs, e := parseFunctions(p)
+	if e != nil {
+		return parseError(p, e.Message(), readCount)
+	}
+
 	body, err := NewExpression(p)
 	if err != nil {
 		return parseError(p, err.Message(), readCount)
@@ -112,7 +134,7 @@ func NewLetExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseError(p, "Missing let statement body", readCount)
 	}
 
-	node := &Let{params: params, values: values, exec: body}
+	node := &Let{position: position{pos}, params: params, annotations: annotations, positions: positions, values: values, funcs: funcs, exec: body}
 	return parseValid(p, node)
 }
 
@@ -120,6 +142,49 @@ func (l *Let) Type() AstNodeType {
 	return LET
 }
 
+/* A name bound by a let to the value of an expression */
+type Binding struct {
+	Name       string
+	Annotation string //Declared type, empty if none
+	Value      AstNode
+}
+
+func NewLetNode(bindings []*Binding, funcs []*Function, body AstNode) (*Let, err.Error) {
+	if body == nil {
+		return nil, err.NewSyntaxError("A let needs a body")
+	} else if e := checkFunctions(funcs); e != nil {
+		return nil, e
+	}
+	l := &Let{params: []string{}, annotations: []string{}, values: []AstNode{}, funcs: funcs, exec: body}
+	for _, binding := range bindings {
+		if binding == nil || binding.Name == "" || binding.Value == nil {
+			return nil, err.NewSyntaxError("A let binding needs a name and a value")
+		} else if e := namedType(binding.Annotation, "binding '"+binding.Name+"'"); e != nil {
+			return nil, e
+		}
+		l.params = append(l.params, binding.Name)
+		l.annotations = append(l.annotations, binding.Annotation)
+		l.values = append(l.values, binding.Value)
+	}
+	return l, nil
+}
+
+func (l *Let) Bindings() []*Binding {
+	bindings := make([]*Binding, len(l.params))
+	for i, name := range l.params {
+		bindings[i] = &Binding{Name: name, Annotation: l.annotations[i], Value: l.values[i]}
+	}
+	return bindings
+}
+
+func (l *Let) Functions() []*Function {
+	return l.funcs
+}
+
+func (l *Let) Body() AstNode {
+	return l.exec
+}
+
 func (l *Let) Serialize(buffer *bytes.Buffer) {
 
 	params := []json.Serializable{}
@@ -134,11 +199,125 @@ func (l *Let) Serialize(buffer *bytes.Buffer) {
 
 	json.BuildMap(buffer,
 		&json.KV{K: "names", V: json.NewArray(params)},
+		&json.KV{K: "annotations", V: serializeAnnotations(l.annotations)},
 		&json.KV{K: "values", V: json.NewArray(values)},
+		&json.KV{K: "functions", V: serializeFunctions(l.funcs)},
 		&json.KV{K: "body", V: l.exec},
 		&json.KV{K: "type", V: json.NewString("LET")})
 }
 
 func (l *Let) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
+
+	//Values are computed in the enclosing scope and kept on the stack
+	start := code.GetFrameOffset()
+	offsets := make([]int, len(l.values))
+	for i, v := range l.values {
+		if e := v.GenerateICG(code, s); e != nil {
+			return e
+		}
+		if l.annotations[i] != "" {
+			code.Append(ir.NewGuard(code.Ax, l.annotations[i], "Binding '"+l.params[i]+"'"))
+		}
+		offsets[i] = code.GetFrameOffset()
+		code.Append(ir.NewPush(code.Ax))
+		code.IncrFrameOffset(1)
+	}
+
+	s.PushNewScope(l.params)
+	defer s.PopScope()
+	for i, p := range l.params {
+		code.SetVariable(s.GetVariableId(p), ir.NewStackAccess(offsets[i]))
+	}
+
+	//Local functions are only visible within the let body
+	if e := declareFunctions(l.funcs, code, s); e != nil {
+		return e
+	}
+
+	if code.IsTail(l) {
+		code.MarkTail(l.exec)
+	}
+	if e := l.exec.GenerateICG(code, s); e != nil {
+		return e
+	}
+
+	//Drop the bindings, the result is in AX
+	code.RestoreFrameOffset(start)
 	return nil
 }
+
+func (l *Let) Infer(c *types.Checker) *types.Type {
+	values := make([]*types.Type, len(l.values))
+	for i, v := range l.values {
+		values[i] = v.Infer(c)
+		if t, ok := types.Named(l.annotations[i]); ok {
+			c.Expect(v.Position(), values[i], t, "Binding '"+l.params[i]+"'")
+			values[i] = t
+		} else if !c.Strict() {
+			values[i] = types.Any()
+		}
+	}
+
+	c.PushScope()
+	defer c.PopScope()
+	for i, p := range l.params {
+		c.BindVariable(p, values[i])
+	}
+	inferFunctions(l.funcs, c)
+
+	return c.Record(l, l.exec.Infer(c))
+}
+
+func (l *Let) Resolve(r *parser.Resolver) err.Error {
+	for _, v := range l.values {
+		if e := v.Resolve(r); e != nil {
+			return e
+		}
+	}
+
+	r.PushScope()
+	defer r.PopScope()
+	if e := r.DeclareVariables(l, l.params, namePositions(l.params, l.positions, l.Position())); e != nil {
+		return e
+	}
+	if e := resolveFunctions(l.funcs, r); e != nil {
+		return e
+	}
+	return l.exec.Resolve(r)
+}
+
+func (l *Let) Lint(linter *lint.Linter) {
+	for _, v := range l.values {
+		v.Lint(linter)
+	}
+	lintFunctions(l.funcs, linter)
+	l.exec.Lint(linter)
+}
+
+/* Bindings of constants which are never assigned are substituted into the
+ * body. A let left without bindings or functions is replaced by its body. */
+func (l *Let) Optimize(o *Optimizer) AstNode {
+	symbols := o.table.Declarations(l)
+	positions := namePositions(l.params, l.positions, l.Position())
+	params, annotations, values := []string{}, []string{}, []AstNode{}
+	l.positions = []parser.Position{}
+	for i, v := range l.values {
+		v = v.Optimize(o)
+		if d, ok := v.(*Data); ok && symbols != nil && symbols[i].Writes == 0 && l.annotations[i] == "" {
+			o.constants[symbols[i]] = d
+			continue
+		}
+		params = append(params, l.params[i])
+		annotations = append(annotations, l.annotations[i])
+		l.positions = append(l.positions, positions[i])
+		values = append(values, v)
+	}
+	l.params, l.annotations, l.values = params, annotations, values
+
+	optimizeFunctions(l.funcs, o)
+	l.exec = l.exec.Optimize(o)
+	if len(l.params) == 0 && len(l.funcs) == 0 {
+		return l.exec
+	}
+	return l
+}
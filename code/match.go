@@ -28,11 +28,16 @@ import (
 	"bytes"
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
+	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/types"
 )
 
 type Match struct {
+	position
 	conditions []AstNode
 	branches   []AstNode
 	matchType  MatchType
@@ -40,6 +45,7 @@ type Match struct {
 
 func NewMatchExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Get '@' or '|' */
 	var matchType MatchType
@@ -80,7 +86,7 @@ func NewMatchExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 		return parseError(p, "Missing closing parenthesis for match", readCount)
 	}
 
-	node := &Match{conditions: conditions, branches: branches, matchType: matchType}
+	node := &Match{position: position{pos}, conditions: conditions, branches: branches, matchType: matchType}
 	return parseValid(p, node)
 }
 
@@ -112,6 +118,41 @@ func (m *Match) Type() AstNodeType {
 	return MATCH
 }
 
+/* A branch of a match, taken when its condition holds */
+type Case struct {
+	Condition AstNode
+	Branch    AstNode
+}
+
+/* A match of at least one case, each with a condition and a branch */
+func NewMatchNode(matchType MatchType, cases []*Case) (*Match, err.Error) {
+	if len(cases) == 0 {
+		return nil, err.NewSyntaxError("A match needs at least one case")
+	}
+	m := &Match{conditions: []AstNode{}, branches: []AstNode{}, matchType: matchType}
+	for _, c := range cases {
+		if c == nil || c.Condition == nil || c.Branch == nil {
+			return nil, err.NewSyntaxError("A match case needs a condition and a branch")
+		}
+		m.conditions = append(m.conditions, c.Condition)
+		m.branches = append(m.branches, c.Branch)
+	}
+	return m, nil
+}
+
+/* Whether every branch which holds is taken, or only the first */
+func (m *Match) MatchType() MatchType {
+	return m.matchType
+}
+
+func (m *Match) Cases() []*Case {
+	cases := make([]*Case, len(m.conditions))
+	for i, condition := range m.conditions {
+		cases[i] = &Case{Condition: condition, Branch: m.branches[i]}
+	}
+	return cases
+}
+
 func (m *Match) Serialize(buffer *bytes.Buffer) {
 
 	branches := []json.Serializable{}
@@ -125,11 +166,139 @@ func (m *Match) Serialize(buffer *bytes.Buffer) {
 	}
 
 	json.BuildMap(buffer,
+		&json.KV{K: "matchType", V: &m.matchType},
 		&json.KV{K: "branches", V: json.NewArray(branches)},
 		&json.KV{K: "conditions", V: json.NewArray(conditions)},
 		&json.KV{K: "type", V: json.NewString("MATCH")})
 }
 
+/* A first match evaluates the branch of the first true condition, while
+ * a match all evaluates the branch of every true condition and results in
+ * the last one taken. When no branch is taken the result is the value of
+ * the last condition for a first match and 0 for a match all. */
 func (m *Match) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
+	start := code.GetFrameOffset()
+	end := code.NewLabel()
+	tail := code.IsTail(m)
+	last := len(m.branches) - 1
+
+	//Match all keeps the latest branch result in a stack slot
+	var result ir.Accessor
+	if m.matchType == ALL {
+		result = ir.NewStackAccess(start)
+		code.Append(ir.NewPush(code.Constant(system.NewNumber(0))))
+		code.IncrFrameOffset(1)
+	}
+	base := code.GetFrameOffset()
+
+	for i, condition := range m.conditions {
+		next := code.NewLabel()
+
+		if e := condition.GenerateICG(code, s); e != nil {
+			return e
+		}
+		code.RestoreFrameOffset(base)
+		code.Append(ir.NewJumpUnless(code.Ax, next))
+
+		//Every first match branch is in tail position, but only the last
+		//branch of a match all is
+		branch := m.branches[i]
+		if tail && (m.matchType == FIRST || i == last) {
+			code.MarkTail(branch)
+		}
+		if e := branch.GenerateICG(code, s); e != nil {
+			return e
+		}
+		code.RestoreFrameOffset(base)
+
+		if m.matchType == FIRST {
+			code.Append(ir.NewGoto(end))
+		} else {
+			code.Append(ir.NewMov(result, code.Ax))
+		}
+		code.PlaceLabel(next)
+	}
+
+	code.PlaceLabel(end)
+	if m.matchType == ALL {
+		code.Append(ir.NewMov(code.Ax, result))
+		code.RestoreFrameOffset(start)
+	}
+
 	return nil
 }
+
+func (m *Match) Infer(c *types.Checker) *types.Type {
+	var result *types.Type
+	for i, condition := range m.conditions {
+		condition.Infer(c)
+		if branch := m.branches[i].Infer(c); result == nil {
+			result = branch
+		} else {
+			result = c.Join(result, branch)
+		}
+	}
+	return c.Record(m, result)
+}
+
+func (m *Match) Resolve(r *parser.Resolver) err.Error {
+	for i, condition := range m.conditions {
+		if e := condition.Resolve(r); e != nil {
+			return e
+		} else if e := m.branches[i].Resolve(r); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (m *Match) Lint(l *lint.Linter) {
+	for i, condition := range m.conditions {
+		condition.Lint(l)
+		m.branches[i].Lint(l)
+	}
+
+	//Only the first matching branch of '|' is run
+	if m.matchType != FIRST {
+		return
+	}
+	for i, condition := range m.conditions[:len(m.conditions)-1] {
+		if truth, ok := constantTruth(condition); ok && truth {
+			l.Report(lint.UNREACHABLE, m.conditions[i+1].Position(), "Match branch is unreachable after an always-true condition")
+			return
+		}
+	}
+}
+
+/* Branches whose condition is constant false never run. The first match
+ * stops at a constant true condition; a match left without branches
+ * yields a false value like one which matched nothing. */
+func (m *Match) Optimize(o *Optimizer) AstNode {
+	conditions, branches := []AstNode{}, []AstNode{}
+	var dropped AstNode
+	for i, condition := range m.conditions {
+		condition = condition.Optimize(o)
+		branch := m.branches[i].Optimize(o)
+		truth, constant := constantTruth(condition)
+		if constant && !truth {
+			dropped = condition
+			continue
+		}
+		conditions = append(conditions, condition)
+		branches = append(branches, branch)
+		if constant && m.matchType == FIRST {
+			break
+		}
+	}
+	m.conditions, m.branches = conditions, branches
+
+	if len(m.conditions) == 0 {
+		if m.matchType == ALL {
+			return newNumber(m.Position(), 0)
+		}
+		return dropped
+	} else if truth, constant := constantTruth(m.conditions[0]); constant && truth && len(m.conditions) == 1 {
+		return m.branches[0]
+	}
+	return m
+}
@@ -29,15 +29,19 @@ import (
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Not struct {
+	position
 	exec AstNode
 }
 
 func NewNotExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 	/*Check for ! */
 	readCount++
 	if tok, eof := p.Read(); eof {
@@ -49,7 +53,7 @@ func NewNotExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	if expr, e := NewExpression(p); e != nil {
 		return parseError(p, e.Message(), readCount)
 	} else if expr != nil {
-		node := &Not{exec: expr}
+		node := &Not{position: position{pos}, exec: expr}
 		return parseValid(p, node)
 	} else {
 		return parseError(p, "Not operator must precede expression", readCount)
@@ -61,6 +65,17 @@ func (n *Not) Type() AstNodeType {
 	return NOT
 }
 
+func NewNotNode(expression AstNode) (*Not, err.Error) {
+	if expression == nil {
+		return nil, err.NewSyntaxError("A not needs an expression")
+	}
+	return &Not{exec: expression}, nil
+}
+
+func (n *Not) Expression() AstNode {
+	return n.exec
+}
+
 func (n *Not) Serialize(buffer *bytes.Buffer) {
 
 	json.BuildMap(buffer,
@@ -71,3 +86,24 @@ func (n *Not) Serialize(buffer *bytes.Buffer) {
 func (n *Not) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 	return nil
 }
+
+func (n *Not) Infer(c *types.Checker) *types.Type {
+	n.exec.Infer(c)
+	return c.Record(n, types.Number())
+}
+
+func (n *Not) Resolve(r *parser.Resolver) err.Error {
+	return n.exec.Resolve(r)
+}
+
+func (n *Not) Lint(l *lint.Linter) {
+	n.exec.Lint(l)
+}
+
+func (n *Not) Optimize(o *Optimizer) AstNode {
+	n.exec = n.exec.Optimize(o)
+	if truth, ok := constantTruth(n.exec); ok {
+		return newBool(n.Position(), !truth)
+	}
+	return n
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code
+
+import (
+	"github.com/rkophs/presta/parser"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* The most nodes a function body may have to be inlined */
+const INLINE_LIMIT = 16
+
+/* A comment on or just above a function declaration which keeps calls to
+ * it from being inlined */
+const NOINLINE = "noinline"
+
+/* Rewrites the tree before code generation: constant expressions are
+ * folded, dead match branches dropped, let bindings of constants and
+ * small functions inlined. Names are matched to their declarations
+ * through the symbol table of the resolution pass. */
+type Optimizer struct {
+	table     *parser.SymbolTable
+	constants map[*parser.Symbol]*Data
+	functions map[*parser.Symbol]*Function
+	noinline  map[int64]bool //Lines with a noinline comment
+}
+
+func NewOptimizer(table *parser.SymbolTable, comments []parser.Comment) *Optimizer {
+	o := &Optimizer{table: table, constants: make(map[*parser.Symbol]*Data),
+		functions: make(map[*parser.Symbol]*Function), noinline: make(map[int64]bool)}
+	for _, comment := range comments {
+		if strings.TrimSpace(comment.Text) == NOINLINE {
+			o.noinline[comment.Pos.Line] = true
+		}
+	}
+	return o
+}
+
+func newNumber(pos parser.Position, num float64) *Data {
+	return &Data{position: position{pos}, num: num, dataType: NUMBER}
+}
+
+func newString(pos parser.Position, str string) *Data {
+	return &Data{position: position{pos}, str: str, dataType: STRING}
+}
+
+func newBool(pos parser.Position, b bool) *Data {
+	if b {
+		return newNumber(pos, 1)
+	}
+	return newNumber(pos, 0)
+}
+
+/* The text of a constant, as the VM would print it */
+func (d *Data) text() string {
+	if d.dataType == NUMBER {
+		return strconv.FormatFloat(d.num, 'f', -1, 64)
+	}
+	return d.str
+}
+
+func (d *Data) equal(other *Data) bool {
+	if d.dataType != other.dataType {
+		return false
+	} else if d.dataType == NUMBER {
+		return d.num == other.num
+	}
+	return d.str == other.str
+}
+
+/* Evaluate an operation on two constants the way the VM would. Anything
+ * the VM would fail on is left for it to report at runtime. */
+func foldBinOp(pos parser.Position, op BinOpType, l *Data, r *Data) (*Data, bool) {
+	switch op {
+	case EQ:
+		return newBool(pos, l.equal(r)), true
+	case NEQ:
+		return newBool(pos, !l.equal(r)), true
+	case AND:
+		lt, _ := constantTruth(l)
+		rt, _ := constantTruth(r)
+		return newBool(pos, lt && rt), true
+	case OR:
+		lt, _ := constantTruth(l)
+		rt, _ := constantTruth(r)
+		return newBool(pos, lt || rt), true
+	}
+
+	if l.dataType != NUMBER || r.dataType != NUMBER {
+		return nil, false
+	}
+	switch op {
+	case ADD:
+		return newNumber(pos, l.num+r.num), true
+	case SUB:
+		return newNumber(pos, l.num-r.num), true
+	case MULT:
+		return newNumber(pos, l.num*r.num), true
+	case DIV:
+		if r.num != 0 {
+			return newNumber(pos, l.num/r.num), true
+		}
+	case MOD:
+		if r.num != 0 {
+			return newNumber(pos, math.Mod(l.num, r.num)), true
+		}
+	case LT:
+		return newBool(pos, l.num < r.num), true
+	case LTE:
+		return newBool(pos, l.num <= r.num), true
+	case GT:
+		return newBool(pos, l.num > r.num), true
+	case GTE:
+		return newBool(pos, l.num >= r.num), true
+	}
+	return nil, false
+}
+
+/* Every function of a group is known before any body is optimised, so
+ * calls within the group may be inlined */
+func optimizeFunctions(funcs []*Function, o *Optimizer) {
+	for _, f := range funcs {
+		if symbol := o.table.Function(f); symbol != nil {
+			o.functions[symbol] = f
+		}
+	}
+	for _, f := range funcs {
+		f.Optimize(o)
+	}
+}
+
+/* Replace a direct call by the body of a small function, binding its
+ * params to the arguments with a let. Only bodies made of operations on
+ * the params are inlined: they reach no other name, so they mean the
+ * same at the call site, and cannot recurse. */
+func (o *Optimizer) inline(c *Call) (AstNode, bool) {
+	symbol := o.table.Lookup(c)
+	if symbol == nil || symbol.Kind != parser.FUNCTION {
+		return nil, false
+	}
+	f, ok := o.functions[symbol]
+	if !ok || len(f.funcs) > 0 || len(f.defaults) > 0 || f.rest != "" || f.result != "" ||
+		len(c.params) != len(f.params) || o.noinline[f.Position().Line] || o.noinline[f.Position().Line-1] {
+		return nil, false
+	}
+	for _, annotation := range f.annotations {
+		if annotation != "" {
+			return nil, false
+		}
+	}
+
+	params := o.table.Declarations(f)
+	size := 0
+	body, ok := o.inlineCopy(f.exec, params, &size)
+	if !ok {
+		return nil, false
+	} else if len(f.params) == 0 {
+		return body, true
+	}
+	return &Let{position: c.position, params: f.params, annotations: make([]string, len(f.params)),
+		values: c.params, funcs: []*Function{}, exec: body}, true
+}
+
+/* Copy a function body for a call site, failing if it reaches anything
+ * but its params or grows past the size limit */
+func (o *Optimizer) inlineCopy(node AstNode, params []*parser.Symbol, size *int) (AstNode, bool) {
+	if *size++; *size > INLINE_LIMIT {
+		return nil, false
+	}
+
+	switch n := node.(type) {
+	case *Data:
+		dup := *n
+		return &dup, true
+	case *Variable:
+		symbol := o.table.Lookup(n)
+		for _, param := range params {
+			if symbol == param && param.Writes == 0 {
+				dup := *n
+				return &dup, true
+			}
+		}
+	case *Not:
+		if exec, ok := o.inlineCopy(n.exec, params, size); ok {
+			return &Not{position: n.position, exec: exec}, true
+		}
+	case *BinOp:
+		l, lok := o.inlineCopy(n.l, params, size)
+		r, rok := o.inlineCopy(n.r, params, size)
+		if lok && rok {
+			return &BinOp{position: n.position, l: l, r: r, op: n.op}, true
+		}
+	case *Concat:
+		components := make([]AstNode, len(n.components))
+		for i, component := range n.components {
+			if dup, ok := o.inlineCopy(component, params, size); ok {
+				components[i] = dup
+			} else {
+				return nil, false
+			}
+		}
+		return &Concat{position: n.position, components: components}, true
+	case *Match:
+		conditions := make([]AstNode, len(n.conditions))
+		branches := make([]AstNode, len(n.branches))
+		for i := range n.conditions {
+			condition, cok := o.inlineCopy(n.conditions[i], params, size)
+			branch, bok := o.inlineCopy(n.branches[i], params, size)
+			if !cok || !bok {
+				return nil, false
+			}
+			conditions[i], branches[i] = condition, branch
+		}
+		return &Match{position: n.position, conditions: conditions, branches: branches, matchType: n.matchType}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code_test
+
+import (
+	"bytes"
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/code"
+	"github.com/rkophs/presta/format"
+	"github.com/rkophs/presta/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, src string) (code.AstNode, []parser.Comment) {
+	tokens, comments, e := presta.TokenizeWithComments(strings.NewReader(src))
+	if e != nil {
+		t.Fatalf("%s: %s", src, e.Message())
+	}
+	tree, e := presta.Parse(tokens)
+	if e != nil {
+		t.Fatalf("%s: %s", src, e.Message())
+	}
+	return tree, comments
+}
+
+/* The source of the optimized program, on one line */
+func optimize(t *testing.T, src string) string {
+	tree, comments := parse(t, src)
+	optimized, e := presta.Optimize(tree, comments)
+	if e != nil {
+		t.Fatalf("%s: %s", src, e.Message())
+	}
+	var b bytes.Buffer
+	if e := format.Node(&b, optimized, nil); e != nil {
+		t.Fatalf("%s: %s", src, e.Message())
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func TestOptimizeFoldsConstants(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"+ 1 2", "3"},
+		{"* + 1 2 - 9 4", "15"},
+		{"< 1 2", "1"},
+		{"== 'a' 'a'", "1"},
+		{"! 0", "1"},
+		{".('a' 'b')", "'ab'"},
+		{".('a' 1)", "'a1'"},
+		{":(x)(1) + x 2", "3"},
+		//Division by zero is left to fail at run time
+		{"/ 1 0", "/ 1 0"},
+	}
+	for _, test := range tests {
+		if got := optimize(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestOptimizeRemovesDeadBranches(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"|(0 1 1 2)", "2"},
+		{":(x)(1) |(== x 2 'a' 1 'b')", "'b'"},
+		{"~f(a)(|(0 1 a 2 1 3)) f", "~f(a)( |( a 2 1 3 ) ) f"},
+		{"@(0 1 0 2)", "0"},
+		{"|(0 1)", "0"},
+	}
+	for _, test := range tests {
+		if got := optimize(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestOptimizeInlinesLetBindings(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{":(x y)(1 'a') .(y x)", "'a1'"},
+		{":(x)(1) :(y)(2) + x y", "3"},
+		//Bindings which are annotated or not constant stay
+		{":(x:num)(1) x", ":(x:num)(1) x"},
+		{"~f(a)(:(x)(a) x) f{1}", "~f(a)( :(x)(a) x ) f{1}"},
+	}
+	for _, test := range tests {
+		if got := optimize(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* The tree given to Optimize is left as it was parsed */
+func TestOptimizeKeepsTree(t *testing.T) {
+	for _, src := range []string{"+ 1 2", "~f(a)(+ a 1) f{2}", ":(x)(1) |(== x 1 'a' 1 'b')"} {
+		tree, comments := parse(t, src)
+		var before, after bytes.Buffer
+		tree.Serialize(&before)
+		if _, e := presta.Optimize(tree, comments); e != nil {
+			t.Fatalf("%s: %s", src, e.Message())
+		}
+		tree.Serialize(&after)
+		if before.String() != after.String() {
+			t.Errorf("%s: optimizing changed the tree to %s", src, after.String())
+		}
+	}
+}
+
+func TestOptimizeInlinesSmallFunctions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"~sq(a)(* a a) sq{3}", "~sq(a)(* a a) :(a)(3) * a a"},
+		{"~sq(a)(* a a) :(n)(len{'ab'}) sq{n}", "~sq(a)(* a a) :(n)(len{'ab'}) :(a)(n) * a a"},
+		{"~one()(1) + one{} 2", "~one()(1) 3"},
+		//Recursion reaches a name other than the params
+		{"~f(n)(|(n f{- n 1} 1 0)) f{3}", "~f(n)( |( n f{- n 1} 1 0 ) ) f{3}"},
+		//Opted out by a comment on or before the declaration
+		{"# noinline\n~sq(a)(* a a) sq{3}", "~sq(a)(* a a) sq{3}"},
+		{"~sq(a)(* a a) # noinline\nsq{3}", "~sq(a)(* a a) sq{3}"},
+		//Bodies over INLINE_LIMIT nodes
+		{"~big(a)(+ a + a + a + a + a + a + a + a a) big{1}", "~big(a)(+ a + a + a + a + a + a + a + a a) big{1}"},
+		{"~ok(a)(+ a + a + a a) ok{1}", "~ok(a)(+ a + a + a a) :(a)(1) + a + a + a a"},
+	}
+	for _, test := range tests {
+		if got := optimize(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
@@ -31,10 +31,13 @@ import (
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Program struct {
+	position
 	funcs []*Function
 	exec  AstNode
 }
@@ -43,52 +46,59 @@ func (p *Program) Type() AstNodeType {
 	return PROG
 }
 
+/* A program declaring funcs for its body to call */
+func NewProgramNode(funcs []*Function, body AstNode) (*Program, err.Error) {
+	if body == nil {
+		return nil, err.NewSyntaxError("A program needs a body")
+	} else if e := checkFunctions(funcs); e != nil {
+		return nil, e
+	}
+	return &Program{funcs: funcs, exec: body}, nil
+}
+
+func (p *Program) Functions() []*Function {
+	return p.funcs
+}
+
+func (p *Program) Body() AstNode {
+	return p.exec
+}
+
 func NewProgram(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Check for function declarations*/
-	functions := []*Function{}
-	for {
-		if function, e := NewFunction(p); e != nil {
-			return parseError(p, e.Message(), readCount)
-		} else if function != nil {
-			functions = append(functions, function.(*Function))
-		} else {
-			break
-		}
+	functions, e := parseFunctions(p)
+	if e != nil {
+		return parseError(p, e.Message(), readCount)
 	}
 
 	/*Check for exec*/
 	expr, err := NewExpression(p)
 	if err != nil {
-		return parseError(p, e.Message(), readCount)
+		return parseError(p, err.Message(), readCount)
 	} else if expr == nil {
 		return parseError(p, "Program must contain an executable expression", readCount)
 	}
 
-	program := &Program{funcs: functions, exec: expr}
+	program := &Program{position: position{pos}, funcs: functions, exec: expr}
 	return parseValid(p, program)
 }
 
 func (p *Program) Serialize(buffer *bytes.Buffer) {
 
-	fns := []json.Serializable{}
-	for _, fn := range p.funcs {
-		fns = append(fns, fn)
-	}
-
 	json.BuildMap(buffer,
-		&json.KV{K: "functions", V: json.NewArray(fns)},
+		&json.KV{K: "functions", V: serializeFunctions(p.funcs)},
 		&json.KV{K: "body", V: p.exec},
 		&json.KV{K: "type", V: json.NewString("PROG")})
 }
 
 func (p *Program) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 
-	/* Add function linker symbols */
-	for _, f := range p.funcs {
-		s.AddFunction(f.name, len(f.params))
-		code.SetFunctionOffset(f.name, ir.NewInstructionLocation(-1))
+	/* Add function linker symbols and generate their bodies */
+	if e := declareFunctions(p.funcs, code, s); e != nil {
+		return e
 	}
 
 	if e := p.exec.GenerateICG(code, s); e != nil {
@@ -99,18 +109,34 @@ func (p *Program) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 	code.Append(ir.NewExit(code.Ax))
 
 	//Concatenate instruction lists and set correct function offsets
-	offset := code.GetCount()
-	for _, f := range p.funcs {
-		code.GetFunctionOffset(f.name).SetLocation(offset)
-		fnBlock := icg.NewCode(code.GetLinker())
-		if e := f.GenerateICG(fnBlock, s); e != nil {
-			return e
-		}
-		code.AppendBlock(fnBlock)
-		offset += fnBlock.GetCount()
-	}
+	code.LinkFunctions()
 
 	fmt.Println("program generated")
 
 	return nil
 }
+
+func (p *Program) Infer(c *types.Checker) *types.Type {
+	inferFunctions(p.funcs, c)
+	return c.Record(p, p.exec.Infer(c))
+}
+
+func (p *Program) Resolve(r *parser.Resolver) err.Error {
+	r.PushScope()
+	defer r.PopScope()
+	if e := resolveFunctions(p.funcs, r); e != nil {
+		return e
+	}
+	return p.exec.Resolve(r)
+}
+
+func (p *Program) Lint(l *lint.Linter) {
+	lintFunctions(p.funcs, l)
+	p.exec.Lint(l)
+}
+
+func (p *Program) Optimize(o *Optimizer) AstNode {
+	optimizeFunctions(p.funcs, o)
+	p.exec = p.exec.Optimize(o)
+	return p
+}
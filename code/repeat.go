@@ -29,16 +29,20 @@ import (
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Repeat struct {
+	position
 	condition AstNode
 	exec      AstNode
 }
 
 func NewRepeatExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	readCount := 0
+	pos := peekPosition(p)
 
 	/*Check for ^ */
 	readCount++
@@ -62,7 +66,7 @@ func NewRepeatExpr(p *parser.TokenScanner) (tree AstNode, e err.Error) {
 	if expr, e := NewExpression(p); e != nil {
 		return parseError(p, e.Message(), readCount)
 	} else if expr != nil {
-		node := &Repeat{condition: condition, exec: expr}
+		node := &Repeat{position: position{pos}, condition: condition, exec: expr}
 		return parseValid(p, node)
 	} else {
 		return parseError(p, "Repeat op must have body", readCount)
@@ -73,6 +77,21 @@ func (r *Repeat) Type() AstNodeType {
 	return REPEAT
 }
 
+func NewRepeatNode(condition AstNode, body AstNode) (*Repeat, err.Error) {
+	if condition == nil || body == nil {
+		return nil, err.NewSyntaxError("A repeat needs a condition and a body")
+	}
+	return &Repeat{condition: condition, exec: body}, nil
+}
+
+func (r *Repeat) Condition() AstNode {
+	return r.condition
+}
+
+func (r *Repeat) Body() AstNode {
+	return r.exec
+}
+
 func (r *Repeat) Serialize(buffer *bytes.Buffer) {
 
 	json.BuildMap(buffer,
@@ -84,3 +103,32 @@ func (r *Repeat) Serialize(buffer *bytes.Buffer) {
 func (r *Repeat) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
 	return nil
 }
+
+func (r *Repeat) Infer(c *types.Checker) *types.Type {
+	r.condition.Infer(c)
+	r.exec.Infer(c)
+	return c.Record(r, types.Any())
+}
+
+func (r *Repeat) Resolve(resolver *parser.Resolver) err.Error {
+	if e := r.condition.Resolve(resolver); e != nil {
+		return e
+	}
+	return r.exec.Resolve(resolver)
+}
+
+func (r *Repeat) Lint(l *lint.Linter) {
+	if truth, ok := constantTruth(r.condition); ok && truth {
+		l.Report(lint.CONSTANT_CONDITION, r.condition.Position(), "Loop condition is always true")
+	} else if ok {
+		l.Report(lint.CONSTANT_CONDITION, r.condition.Position(), "Loop condition is always false")
+	}
+	r.condition.Lint(l)
+	r.exec.Lint(l)
+}
+
+func (r *Repeat) Optimize(o *Optimizer) AstNode {
+	r.condition = r.condition.Optimize(o)
+	r.exec = r.exec.Optimize(o)
+	return r
+}
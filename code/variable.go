@@ -30,10 +30,13 @@ import (
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/json"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
 )
 
 type Variable struct {
+	position
 	name string
 }
 
@@ -41,6 +44,14 @@ func (v *Variable) Type() AstNodeType {
 	return VAR
 }
 
+func NewVariableNode(name string) *Variable {
+	return &Variable{name: name}
+}
+
+func (v *Variable) Name() string {
+	return v.name
+}
+
 func (v *Variable) Serialize(buffer *bytes.Buffer) {
 
 	json.BuildMap(buffer,
@@ -49,10 +60,68 @@ func (v *Variable) Serialize(buffer *bytes.Buffer) {
 }
 
 func (v *Variable) GenerateICG(code *icg.Code, s *parser.Semantic) err.Error {
-	if !s.VariableExists(v.name) {
-		return err.NewSymanticError("Undefined variable.")
+
+	//Declared functions referenced by name become function values
+	if !s.VariableExists(v.name) && s.FunctionExists(v.name) {
+		return functionValue(v.name, code, s)
 	}
 
-	code.Append(ir.NewMov(code.Ax, code.GetVariableLocation(s.GetVariableId(v.name))))
+	if access, e := variableAccess(v.name, code, s); e != nil {
+		return e
+	} else {
+		code.Append(ir.NewMov(code.Ax, access))
+		return nil
+	}
+}
+
+/* Load a declared function into AX as a function value. The closure
+ * environment holds the variables of enclosing frames it captures, read
+ * where the value is built as a lambda's are. */
+func functionValue(name string, code *icg.Code, s *parser.Semantic) err.Error {
+	captures := s.FunctionCaptures(name)
+	accessors := make([]ir.Accessor, len(captures))
+	for i, capture := range captures {
+		if access, e := variableAccess(capture, code, s); e != nil {
+			return e
+		} else {
+			accessors[i] = access
+		}
+	}
+	location := code.GetFunctionOffset(s.GetFunctionId(name))
+	code.Append(ir.NewClosure(code.Ax, location, s.FunctionParams(name), accessors...))
 	return nil
 }
+
+/* Resolve a variable to its stack slot, or to its closure environment
+ * slot when it is captured from an enclosing frame */
+func variableAccess(name string, code *icg.Code, s *parser.Semantic) (ir.Accessor, err.Error) {
+	if !s.VariableExists(name) {
+		return nil, err.NewSymanticError("Undefined variable.")
+	} else if !s.VariableCaptured(name) {
+		return code.GetVariableLocation(s.GetVariableId(name)), nil
+	} else if index, ok := s.CaptureVariable(name); ok {
+		return ir.NewEnvAccess(index), nil
+	} else {
+		return nil, err.NewSymanticError("Variable '" + name + "' belongs to an enclosing function and cannot be referenced. Use a lambda to capture it.")
+	}
+}
+
+func (v *Variable) Infer(c *types.Checker) *types.Type {
+	return c.Record(v, c.LookupValue(v.name))
+}
+
+func (v *Variable) Resolve(r *parser.Resolver) err.Error {
+	return r.ResolveValue(v, v.name, v.Position())
+}
+
+func (v *Variable) Lint(l *lint.Linter) {
+}
+
+func (v *Variable) Optimize(o *Optimizer) AstNode {
+	if symbol := o.table.Lookup(v); symbol != nil {
+		if d, ok := o.constants[symbol]; ok {
+			return &Data{position: v.position, str: d.str, num: d.num, dataType: d.dataType}
+		}
+	}
+	return v
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code
+
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/parser"
+)
+
+/* Visit is called for each node Walk meets. Walk goes on to the node's
+ * children with the visitor it returns, unless that is nil, and then
+ * calls that visitor's Visit with nil. */
+type Visitor interface {
+	Visit(node AstNode) (w Visitor)
+}
+
+/* Visit the tree depth first, each node before its children and the
+ * children in source order. The function of a lambda is not a node of
+ * its own: its children are the lambda's. */
+func Walk(v Visitor, node AstNode) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, child := range children(node) {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(AstNode) bool
+
+func (f inspector) Visit(node AstNode) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+/* Walk the tree calling f for every node and then with nil. The children
+ * of a node are skipped when f returns false for it. */
+func Inspect(node AstNode, f func(AstNode) bool) {
+	Walk(inspector(f), node)
+}
+
+/* Replace every node of the tree, children before their parent, by the
+ * node f returns for it, which may be the node itself. Nodes are
+ * rewritten in place; the new root is returned. A replacement must be
+ * allowed where it goes: a PROG only at the root, a FUNC only in a
+ * functions list or as a FUNC root, any other node elsewhere. Replacements
+ * without a position take the position of the node they replace. */
+func Rewrite(node AstNode, f func(AstNode) AstNode) (AstNode, err.Error) {
+	r := &rewriter{f: f}
+	if node.Type() == FUNC {
+		//A declaration stays one
+		fn := []*Function{node.(*Function)}
+		if r.functions(fn); r.e != nil {
+			return nil, r.e
+		}
+		return fn[0], nil
+	}
+
+	if node = r.rewrite(node); r.e != nil {
+		return nil, r.e
+	} else if node.Type() == FUNC {
+		return nil, rewriteFail(node, "A FUNC node is only allowed in a functions list")
+	}
+	return node, nil
+}
+
+/* A copy of the tree sharing no nodes with it, so either may be
+ * rewritten without changing the other */
+func Copy(node AstNode) AstNode {
+	switch n := node.(type) {
+	case *Program:
+		dup := *n
+		dup.funcs, dup.exec = copyFunctions(n.funcs), Copy(n.exec)
+		return &dup
+	case *Function:
+		return copyFunction(n)
+	case *Lambda:
+		return &Lambda{position: n.position, fn: copyFunction(n.fn)}
+	case *Let:
+		dup := *n
+		dup.params = append([]string{}, n.params...)
+		dup.annotations = append([]string{}, n.annotations...)
+		dup.positions = append([]parser.Position{}, n.positions...)
+		dup.values, dup.funcs, dup.exec = copyAll(n.values), copyFunctions(n.funcs), Copy(n.exec)
+		return &dup
+	case *Match:
+		dup := *n
+		dup.conditions, dup.branches = copyAll(n.conditions), copyAll(n.branches)
+		return &dup
+	case *Repeat:
+		dup := *n
+		dup.condition, dup.exec = Copy(n.condition), Copy(n.exec)
+		return &dup
+	case *Assign:
+		dup := *n
+		dup.value = Copy(n.value)
+		return &dup
+	case *Concat:
+		dup := *n
+		dup.components = copyAll(n.components)
+		return &dup
+	case *Call:
+		dup := *n
+		dup.params = copyAll(n.params)
+		return &dup
+	case *Not:
+		dup := *n
+		dup.exec = Copy(n.exec)
+		return &dup
+	case *BinOp:
+		dup := *n
+		dup.l, dup.r = Copy(n.l), Copy(n.r)
+		return &dup
+	case *Data:
+		dup := *n
+		return &dup
+	case *Variable:
+		dup := *n
+		return &dup
+	}
+	return node
+}
+
+func copyFunction(f *Function) *Function {
+	dup := *f
+	dup.params = append([]string{}, f.params...)
+	dup.annotations = append([]string{}, f.annotations...)
+	dup.positions = append([]parser.Position{}, f.positions...)
+	dup.defaults, dup.funcs, dup.exec = copyAll(f.defaults), copyFunctions(f.funcs), Copy(f.exec)
+	return &dup
+}
+
+func copyAll(nodes []AstNode) []AstNode {
+	dups := make([]AstNode, len(nodes))
+	for i, node := range nodes {
+		dups[i] = Copy(node)
+	}
+	return dups
+}
+
+func copyFunctions(funcs []*Function) []*Function {
+	dups := make([]*Function, len(funcs))
+	for i, fn := range funcs {
+		dups[i] = copyFunction(fn)
+	}
+	return dups
+}
+
+/* A Rewrite in progress, stopping at the first invalid replacement */
+type rewriter struct {
+	f func(AstNode) AstNode
+	e err.Error
+}
+
+func rewriteFail(node AstNode, msg string) err.Error {
+	return err.NewSyntaxError(node.Position().String() + "\t" + msg)
+}
+
+func (r *rewriter) rewrite(node AstNode) AstNode {
+	switch n := node.(type) {
+	case *Program:
+		r.functions(n.funcs)
+		n.exec = r.expression(n.exec)
+	case *Function:
+		r.all(n.defaults)
+		r.functions(n.funcs)
+		n.exec = r.expression(n.exec)
+	case *Lambda:
+		r.all(n.fn.defaults)
+		r.functions(n.fn.funcs)
+		n.fn.exec = r.expression(n.fn.exec)
+	case *Let:
+		r.all(n.values)
+		r.functions(n.funcs)
+		n.exec = r.expression(n.exec)
+	case *Match:
+		for i := range n.conditions {
+			n.conditions[i] = r.expression(n.conditions[i])
+			n.branches[i] = r.expression(n.branches[i])
+		}
+	case *Repeat:
+		n.condition = r.expression(n.condition)
+		n.exec = r.expression(n.exec)
+	case *Assign:
+		n.value = r.expression(n.value)
+	case *Concat:
+		r.all(n.components)
+	case *Call:
+		r.all(n.params)
+	case *Not:
+		n.exec = r.expression(n.exec)
+	case *BinOp:
+		n.l = r.expression(n.l)
+		n.r = r.expression(n.r)
+	}
+	if r.e != nil {
+		return node
+	}
+
+	replacement := r.f(node)
+	if replacement == nil {
+		r.e = rewriteFail(node, "A node was replaced by nil")
+		return node
+	} else if replacement.Position() == (parser.Position{}) {
+		replacement.SetPosition(node.Position())
+	}
+	return replacement
+}
+
+/* Rewrite a node in expression position */
+func (r *rewriter) expression(node AstNode) AstNode {
+	if r.e != nil {
+		return node
+	}
+	rewritten := r.rewrite(node)
+	if r.e != nil {
+		return rewritten
+	} else if rewritten.Type() == PROG {
+		r.e = rewriteFail(rewritten, "A PROG node is only allowed at the root")
+	} else if rewritten.Type() == FUNC {
+		r.e = rewriteFail(rewritten, "A FUNC node is only allowed in a functions list")
+	}
+	return rewritten
+}
+
+func (r *rewriter) all(nodes []AstNode) {
+	for i, node := range nodes {
+		nodes[i] = r.expression(node)
+	}
+}
+
+func (r *rewriter) functions(funcs []*Function) {
+	for i, fn := range funcs {
+		if r.e != nil {
+			return
+		}
+		rewritten := r.rewrite(fn)
+		if r.e != nil {
+			return
+		} else if replacement, ok := rewritten.(*Function); ok {
+			funcs[i] = replacement
+		} else {
+			r.e = rewriteFail(rewritten, "Expected a FUNC node")
+		}
+	}
+}
+
+/* The children of a node in source order */
+func children(node AstNode) []AstNode {
+	nodes := []AstNode{}
+	switch n := node.(type) {
+	case *Program:
+		nodes = appendFunctions(nodes, n.funcs)
+		nodes = append(nodes, n.exec)
+	case *Function:
+		nodes = append(nodes, n.defaults...)
+		nodes = appendFunctions(nodes, n.funcs)
+		nodes = append(nodes, n.exec)
+	case *Lambda:
+		nodes = append(nodes, n.fn.defaults...)
+		nodes = appendFunctions(nodes, n.fn.funcs)
+		nodes = append(nodes, n.fn.exec)
+	case *Let:
+		nodes = append(nodes, n.values...)
+		nodes = appendFunctions(nodes, n.funcs)
+		nodes = append(nodes, n.exec)
+	case *Match:
+		for i := range n.conditions {
+			nodes = append(nodes, n.conditions[i], n.branches[i])
+		}
+	case *Repeat:
+		nodes = append(nodes, n.condition, n.exec)
+	case *Assign:
+		nodes = append(nodes, n.value)
+	case *Concat:
+		nodes = append(nodes, n.components...)
+	case *Call:
+		nodes = append(nodes, n.params...)
+	case *Not:
+		nodes = append(nodes, n.exec)
+	case *BinOp:
+		nodes = append(nodes, n.l, n.r)
+	}
+	return nodes
+}
+
+func appendFunctions(nodes []AstNode, funcs []*Function) []AstNode {
+	for _, fn := range funcs {
+		nodes = append(nodes, fn)
+	}
+	return nodes
+}
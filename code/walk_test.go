@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package code_test
+
+import (
+	"bytes"
+	"github.com/rkophs/presta/code"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/parser"
+	"strings"
+	"testing"
+)
+
+func declaration(t *testing.T) *code.Function {
+	fn, e := code.NewFunctionNode("f", []*code.Param{{Name: "a"}}, "", "", nil, code.NewVariableNode("a"))
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	return fn
+}
+
+/* A program declaring f for body */
+func newProgram(t *testing.T, body code.AstNode) *code.Program {
+	p, e := code.NewProgramNode([]*code.Function{declaration(t)}, body)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	return p
+}
+
+func TestNewMatchNodeNeedsCases(t *testing.T) {
+	if _, e := code.NewMatchNode(code.FIRST, nil); e == nil {
+		t.Errorf("built a match without cases")
+	}
+	cases := []*code.Case{{Condition: code.NewNumberNode(1), Branch: nil}}
+	if _, e := code.NewMatchNode(code.FIRST, cases); e == nil {
+		t.Errorf("built a match case without a branch")
+	}
+	cases[0].Branch = code.NewNumberNode(2)
+	if _, e := code.NewMatchNode(code.FIRST, cases); e != nil {
+		t.Errorf("got %q", e.Message())
+	}
+}
+
+/* Every builder rejects missing children and unknown types, so a tree
+ * built by hand compiles or fails like a parsed one */
+func TestBuildersValidate(t *testing.T) {
+	one, a := code.NewNumberNode(1), code.NewVariableNode("a")
+	tests := []struct {
+		name  string
+		build func() err.Error
+		msg   string
+	}{
+		{"binop", func() err.Error {
+			_, e := code.NewBinOpNode(code.ADD, one, nil)
+			return e
+		}, "A binary operation needs two operands"},
+		{"call name", func() err.Error {
+			_, e := code.NewCallNode("", nil)
+			return e
+		}, "A call needs a name"},
+		{"call argument", func() err.Error {
+			_, e := code.NewCallNode("f", []code.AstNode{one, nil})
+			return e
+		}, "A call argument is missing"},
+		{"concat", func() err.Error {
+			_, e := code.NewConcatNode([]code.AstNode{nil})
+			return e
+		}, "A concat component is missing"},
+		{"not", func() err.Error {
+			_, e := code.NewNotNode(nil)
+			return e
+		}, "A not needs an expression"},
+		{"repeat", func() err.Error {
+			_, e := code.NewRepeatNode(one, nil)
+			return e
+		}, "A repeat needs a condition and a body"},
+		{"assign", func() err.Error {
+			_, e := code.NewAssignNode("a", nil)
+			return e
+		}, "An assignment needs a name and a value"},
+		{"program body", func() err.Error {
+			_, e := code.NewProgramNode(nil, nil)
+			return e
+		}, "A program needs a body"},
+		{"program function", func() err.Error {
+			_, e := code.NewProgramNode([]*code.Function{nil}, one)
+			return e
+		}, "A function declaration is missing"},
+		{"function body", func() err.Error {
+			_, e := code.NewFunctionNode("f", nil, "", "", nil, nil)
+			return e
+		}, "A function needs a body"},
+		{"function param", func() err.Error {
+			_, e := code.NewFunctionNode("f", []*code.Param{nil}, "", "", nil, one)
+			return e
+		}, "A parameter needs a name"},
+		{"function annotation", func() err.Error {
+			_, e := code.NewFunctionNode("f", []*code.Param{{Name: "a", Annotation: "nmu"}}, "", "", nil, a)
+			return e
+		}, "Unknown type 'nmu' for parameter 'a'"},
+		{"function result", func() err.Error {
+			_, e := code.NewFunctionNode("f", nil, "", "nmu", nil, one)
+			return e
+		}, "Unknown type 'nmu' for the result of 'f'"},
+		{"lambda", func() err.Error {
+			_, e := code.NewLambdaNode(nil, "", "nmu", nil, one)
+			return e
+		}, "Unknown type 'nmu' for the result of anonymous function"},
+		{"let body", func() err.Error {
+			_, e := code.NewLetNode(nil, nil, nil)
+			return e
+		}, "A let needs a body"},
+		{"let binding", func() err.Error {
+			_, e := code.NewLetNode([]*code.Binding{{Name: "a"}}, nil, a)
+			return e
+		}, "A let binding needs a name and a value"},
+		{"let annotation", func() err.Error {
+			_, e := code.NewLetNode([]*code.Binding{{Name: "a", Annotation: "nmu", Value: one}}, nil, a)
+			return e
+		}, "Unknown type 'nmu' for binding 'a'"},
+		{"let function", func() err.Error {
+			_, e := code.NewLetNode(nil, []*code.Function{nil}, one)
+			return e
+		}, "A function declaration is missing"},
+	}
+	for _, test := range tests {
+		if e := test.build(); e == nil {
+			t.Errorf("%s: built without error", test.name)
+		} else if e.Code() != err.SYNTAX_ERROR || e.Message() != test.msg {
+			t.Errorf("%s: got %q, want %q", test.name, e.Message(), test.msg)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	one := code.NewNumberNode(1)
+	one.SetPosition(parser.Position{Line: 2, Column: 5})
+	sum, e := code.NewBinOpNode(code.ADD, one, code.NewNumberNode(3))
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	tree := newProgram(t, sum)
+
+	var two code.AstNode
+	rewritten, e := code.Rewrite(tree, func(node code.AstNode) code.AstNode {
+		if node == one {
+			two = code.NewNumberNode(2)
+			return two
+		}
+		return node
+	})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	var b bytes.Buffer
+	rewritten.Serialize(&b)
+	if want := program(FUNC, `{"opType":"+","l":`+TWO+`,"r":{"dataType":"NUMBER","value":3,"type":"DATA"},"type":"BINOP"}`); b.String() != want {
+		t.Errorf("got %s, want %s", b.String(), want)
+	}
+	if got := two.Position(); got != (parser.Position{Line: 2, Column: 5}) {
+		t.Errorf("replacement is at %s, want the position of the node it replaced", got)
+	}
+}
+
+/* Rewriting a copy leaves the original as it was */
+func TestCopy(t *testing.T) {
+	tree, _ := parse(t, "~f(a b:(1))( :(x)(a) |(x .(b 'c') 1 ~(y)(+ y 2)) ) f{1}")
+	var before, after bytes.Buffer
+	tree.Serialize(&before)
+
+	dup := code.Copy(tree)
+	if _, e := code.Rewrite(dup, func(node code.AstNode) code.AstNode {
+		if node.Type() == code.DATA {
+			return code.NewNumberNode(9)
+		}
+		return node
+	}); e != nil {
+		t.Fatal(e.Message())
+	}
+	tree.Serialize(&after)
+	if before.String() != after.String() {
+		t.Errorf("rewriting the copy changed the tree to %s", after.String())
+	}
+}
+
+func TestRewriteRejectsInvalidReplacements(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace func(node code.AstNode) code.AstNode
+		msg     string
+	}{
+		{"nil", func(node code.AstNode) code.AstNode {
+			return nil
+		}, "replaced by nil"},
+		{"program", func(node code.AstNode) code.AstNode {
+			if node.Type() == code.DATA {
+				return newProgram(t, code.NewNumberNode(1))
+			}
+			return node
+		}, "A PROG node is only allowed at the root"},
+		{"function", func(node code.AstNode) code.AstNode {
+			if node.Type() == code.DATA {
+				return declaration(t)
+			}
+			return node
+		}, "A FUNC node is only allowed in a functions list"},
+		{"declaration", func(node code.AstNode) code.AstNode {
+			if node.Type() == code.FUNC {
+				return code.NewNumberNode(1)
+			}
+			return node
+		}, "Expected a FUNC node"},
+	}
+	for _, test := range tests {
+		not, e := code.NewNotNode(code.NewNumberNode(1))
+		if e != nil {
+			t.Fatal(e.Message())
+		}
+		tree := newProgram(t, not)
+		if _, e := code.Rewrite(tree, test.replace); e == nil {
+			t.Errorf("%s: rewrote an invalid tree", test.name)
+		} else if !strings.Contains(e.Message(), test.msg) {
+			t.Errorf("%s: got %q, want %q", test.name, e.Message(), test.msg)
+		}
+	}
+
+	//A declaration at the root may only become another declaration
+	if _, e := code.Rewrite(declaration(t), func(node code.AstNode) code.AstNode {
+		if node.Type() == code.FUNC {
+			return code.NewNumberNode(1)
+		}
+		return node
+	}); e == nil {
+		t.Errorf("replaced a FUNC root by an expression")
+	}
+}
@@ -31,12 +31,46 @@ import (
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/icg"
 	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/lint"
 	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/types"
 	"io"
 )
 
+type Options struct {
+	//Infer and check the type of every expression, rather than only
+	//check values against the declared types
+	TypeCheck bool
+	//The lint rules to warn about, nil for none
+	Lint *lint.Config
+	//Receives each lint warning, which are dropped if nil
+	Warn func(*err.Warning)
+	//Skip the optimisers, to debug the code generated for the source as is
+	NoOptimize bool
+	//Size of the register file, ir.REGISTERS if zero
+	Registers int
+	//Leave debug info such as function names out of the program
+	StripDebug bool
+	//Go functions the script may call, which the VM must be given too
+	Host *system.Host
+}
+
 func Compile(r io.Reader) (i []ir.Instruction, e err.Error) {
-	tokens, e := Tokenize(r)
+	return CompileWithOptions(r, Options{})
+}
+
+func CompileWithOptions(r io.Reader, options Options) (i []ir.Instruction, e err.Error) {
+	program, e := CompileProgram(r, options)
+	if e != nil {
+		return nil, e
+	}
+	return program.Instructions, nil
+}
+
+/* Compile to a linked program, which ir.Encode can store as bytecode */
+func CompileProgram(r io.Reader, options Options) (program *ir.Program, e err.Error) {
+	tokens, comments, e := TokenizeWithComments(r)
 	if e != nil {
 		return nil, e
 	}
@@ -45,12 +79,43 @@ func Compile(r io.Reader) (i []ir.Instruction, e err.Error) {
 	if e != nil {
 		return nil, e
 	}
+	return CompileTree(tree, comments, options)
+}
 
+/* Compile a tree built by Parse, ParseJson or by hand. Comments may be
+ * nil. */
+func CompileTree(tree code.AstNode, comments []parser.Comment, options Options) (program *ir.Program, e err.Error) {
 	var buffer1 bytes.Buffer
 	tree.Serialize(&buffer1)
 	fmt.Println(buffer1.String())
 
-	code, e := Generate(tree)
+	if options.Lint != nil {
+		warnings, e := lintWithHost(tree, comments, options.Lint, options.Host)
+		if e != nil {
+			return nil, e
+		}
+		for _, warning := range warnings {
+			if options.Warn != nil {
+				options.Warn(warning)
+			}
+		}
+	}
+
+	check := CheckAnnotations
+	if options.TypeCheck {
+		check = Check
+	}
+	if _, errs := check(tree); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	if !options.NoOptimize {
+		if tree, e = optimizeWithHost(tree, comments, options.Host); e != nil {
+			return nil, e
+		}
+	}
+
+	code, e := GenerateWithOptions(tree, options)
 	if e != nil {
 		return nil, e
 	}
@@ -59,24 +124,120 @@ func Compile(r io.Reader) (i []ir.Instruction, e err.Error) {
 	code.Serialize(&buffer)
 	fmt.Println(buffer.String())
 
-	return code.GetInstructions(), nil
+	program = code.GetProgram()
+	program.Debug = !options.StripDebug
+	return program, nil
 }
 
 func Generate(tree code.AstNode) (*icg.Code, err.Error) {
+	return GenerateWithOptions(tree, Options{})
+}
+
+func GenerateWithOptions(tree code.AstNode, options Options) (*icg.Code, err.Error) {
 	code := icg.NewCode(icg.NewLinker())
-	s := parser.NewSemantic()
+	if options.Registers > 0 {
+		code.SetRegisterCount(options.Registers)
+	}
+	code.SetPeephole(!options.NoOptimize)
+	s := newSemantic(options.Host)
+	if _, e := Resolve(tree, s); e != nil {
+		return nil, e
+	}
+
 	if err := tree.GenerateICG(code, s); err != nil {
 		return nil, err
 	}
 	return code, nil
 }
 
+func newSemantic(host *system.Host) *parser.Semantic {
+	s := parser.NewSemantic()
+	s.SetHost(host)
+	return s
+}
+
+/* Resolve every name in the tree before any code is generated, declaring
+ * its symbols with ids from s */
+func Resolve(tree code.AstNode, s *parser.Semantic) (*parser.SymbolTable, err.Error) {
+	r := parser.NewResolver(s)
+	if e := tree.Resolve(r); e != nil {
+		return nil, e
+	} else if e := r.Finish(); e != nil {
+		return nil, e
+	}
+	return r.Table(), nil
+}
+
+/* Report the warnings of the enabled lint rules, less those suppressed by
+ * comments */
+func Lint(tree code.AstNode, comments []parser.Comment, config *lint.Config) ([]*err.Warning, err.Error) {
+	return lintWithHost(tree, comments, config, nil)
+}
+
+func lintWithHost(tree code.AstNode, comments []parser.Comment, config *lint.Config, host *system.Host) ([]*err.Warning, err.Error) {
+	table, e := Resolve(tree, newSemantic(host))
+	if e != nil {
+		return nil, e
+	}
+	l := lint.NewLinter(config, comments)
+	l.CheckSymbols(table)
+	tree.Lint(l)
+	return l.Warnings(), nil
+}
+
+/* An equivalent tree which generates less code, leaving the tree given
+ * as it is. Comments may opt functions out of inlining. */
+func Optimize(tree code.AstNode, comments []parser.Comment) (code.AstNode, err.Error) {
+	return optimizeWithHost(tree, comments, nil)
+}
+
+func optimizeWithHost(tree code.AstNode, comments []parser.Comment, host *system.Host) (code.AstNode, err.Error) {
+	//The optimiser rewrites nodes in place, the caller keeps its tree
+	tree = code.Copy(tree)
+	table, e := Resolve(tree, newSemantic(host))
+	if e != nil {
+		return nil, e
+	}
+	return tree.Optimize(code.NewOptimizer(table, comments)), nil
+}
+
+/* Infer the type of every node in the tree. The checker holds the
+ * annotations; all type errors found are returned */
+func Check(tree code.AstNode) (*types.Checker, []err.Error) {
+	c := types.NewChecker()
+	tree.Infer(c)
+	return c, c.Errors()
+}
+
+/* Check values only against the types the program declares, taking
+ * anything undeclared as Any */
+func CheckAnnotations(tree code.AstNode) (*types.Checker, []err.Error) {
+	c := types.NewAnnotationChecker()
+	tree.Infer(c)
+	return c, c.Errors()
+}
+
 func Parse(tokens []parser.Token) (tree code.AstNode, e err.Error) {
 	p := parser.NewTokenScanner(tokens)
 	return code.NewProgram(p)
 }
 
+/* Rebuild a program from the AST JSON written by its Serialize */
+func ParseJson(r io.Reader) (tree code.AstNode, e err.Error) {
+	if tree, e = code.Decode(r); e != nil {
+		return nil, e
+	} else if tree.Type() != code.PROG {
+		return nil, err.NewLoadError("[root]\tExpected a PROG node")
+	}
+	return tree, nil
+}
+
 func Tokenize(reader io.Reader) (tokens []parser.Token, e err.Error) {
+	tokens, _, e = TokenizeWithComments(reader)
+	return tokens, e
+}
+
+func TokenizeWithComments(reader io.Reader) (tokens []parser.Token, comments []parser.Comment, e err.Error) {
 	s := parser.NewLexScanner(reader)
 	a := []parser.Token{}
 	for {
@@ -84,11 +245,11 @@ func Tokenize(reader io.Reader) (tokens []parser.Token, e err.Error) {
 		if tok.Type() == parser.EOF {
 			break
 		} else if tok.Type() == parser.ILLEGAL {
-			return a, err.NewLexicalError(fmt.Sprintf("[%d:%d]\tIllegal token:\t%q\n", tok.Line(), tok.Pos(), tok.Lit()))
+			return a, s.Comments(), err.NewLexicalError(fmt.Sprintf("%s\tIllegal token:\t%q\n", tok.Position(), tok.Lit()))
 		} else {
 			a = append(a, *tok)
 		}
 	}
 
-	return a, nil
+	return a, s.Comments(), nil
 }
@@ -0,0 +1,489 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package presta_test
+
+import (
+	"bytes"
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/lint"
+	"github.com/rkophs/presta/vm"
+	"strings"
+	"testing"
+)
+
+/* Compile a program and run it in the dispatch loop and the interpreter,
+ * which must agree, returning its result or the message of the error it
+ * failed with */
+func run(t *testing.T, src string, options presta.Options) string {
+	instrs, e := presta.CompileWithOptions(strings.NewReader(src), options)
+	if e != nil {
+		return "error: " + e.Message()
+	}
+
+	results := [2]string{}
+	for i, interpret := range []bool{false, true} {
+		v := vm.NewVM(instrs)
+		v.SetHost(options.Host)
+		if interpret {
+			e = v.Interpret()
+		} else {
+			e = v.Run()
+		}
+		if e != nil {
+			results[i] = "error: " + e.Message()
+		} else {
+			results[i], _ = v.Result().ToString()
+		}
+	}
+	if results[0] != results[1] {
+		t.Errorf("%s: Run gave %q, Interpret %q", src, results[0], results[1])
+	}
+	return results[0]
+}
+
+/* Run a program with and without the optimizers, which must agree */
+func runAll(t *testing.T, src string) string {
+	optimized, unoptimized := run(t, src, presta.Options{}), run(t, src, presta.Options{NoOptimize: true})
+	if optimized != unoptimized {
+		t.Errorf("%s: gave %q optimized, %q unoptimized", src, optimized, unoptimized)
+	}
+	return optimized
+}
+
+func TestNestedFunctionCaptures(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		//Params and let bindings of enclosing functions
+		{"~outer(a)( ~inner(b)( + a b ) inner{10} ) outer{5}", "15"},
+		{"~outer(a)( :(c)(+ a 1) ~inner(b)( * c b ) inner{10} ) outer{1}", "20"},
+		//Let bindings of the program
+		{":(x)(10) ~f(y)( + x y ) f{5}", "15"},
+		//Through an intermediate function
+		{"~outer(a)( ~mid()( ~in()( a ) in{} ) mid{} ) outer{3}", "3"},
+		//As function values and from lambdas
+		{"~outer(a)( ~f(n)( + n a ) :(g)(f) g{1} ) outer{41}", "42"},
+		{"~outer(a)( ~f(n)( ~(k)(+ + k n a) ) :(g)(f{1}) g{2} ) outer{10}", "13"},
+		{"~outer(a)( ~f(n)( + n a ) ~(k)( f{k} ) ) :(g)(outer{1}) g{2}", "3"},
+		//Recursive, mutually recursive and in tail position
+		{"~outer(a)( ~sum(n)( |(== n 0 a 1 + n sum{- n 1}) ) sum{4} ) outer{100}", "110"},
+		{"~outer(a)( ~ev(n)( |(== n 0 a 1 od{- n 1}) ) ~od(n)( |(== n 0 0 1 ev{- n 1}) ) ev{6} ) outer{42}", "42"},
+		{"~outer(a)( ~lp(n acc)( |(== n 0 + acc a 1 lp{- n 1 + acc 1}) ) lp{10000 0} ) outer{7}", "10007"},
+		//In the default of an optional param
+		{"~outer(a)( ~f(b:(a))( b ) f{} ) outer{9}", "9"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestNestedFunctionCaptureHidden(t *testing.T) {
+	src := "~outer(a)( ~f()( a ) :(a)(99) f{} ) outer{1}"
+	if got := runAll(t, src); !strings.Contains(got, "Function 'f' uses the variable 'a'") {
+		t.Errorf("%s: got %q", src, got)
+	}
+}
+
+/* Args, locals and results stay in their own frames across nested,
+ * recursive and mutually recursive calls */
+func TestCallingConvention(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		//Arg order and calls in args
+		{"~f(a b)(- a b) f{f{10 3} f{2 1}}", "6"},
+		{"~g(a b c)(+ * a 100 + * b 10 c) ~f(x)(g{x + x 1 + x 2}) + f{1} :(y)(f{2}) y", "357"},
+		{"~id(x)(x) ~k(a b)(a) k{id{k{1 2}} id{3}}", "1"},
+		//Locals of the callee and omitted optional args
+		{"~f(a)( :(b c)(* a 2 + a 1) - b c ) f{5}", "4"},
+		{"~f(a b:2)(- a b) + f{10} f{10 7}", "11"},
+		//Recursive
+		{"~sum(n)( |(== n 0 0 1 + n sum{- n 1}) ) sum{1000}", "500500"},
+		{"~fib(n)( |(< n 2 n 1 + fib{- n 1} fib{- n 2}) ) fib{15}", "610"},
+		{"~ack(m n)( |(== m 0 + n 1 == n 0 ack{- m 1 1} 1 ack{- m 1 ack{m - n 1}}) ) ack{2 3}", "9"},
+		//Mutually recursive
+		{"~ev(n)( |(== n 0 1 1 od{- n 1}) ) ~od(n)( |(== n 0 0 1 ev{- n 1}) ) + ev{10} od{7}", "2"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* Every operator, on params so the optimizer cannot fold it */
+func TestBinOps(t *testing.T) {
+	tests := []struct {
+		op   string
+		args string
+		want string
+	}{
+		{"+", "7 2", "9"},
+		{"-", "2 7", "-5"},
+		{"*", "7 2", "14"},
+		{"/", "7 2", "3.5"},
+		{"%", "- 0 7 2", "-1"},
+		{"<", "2 7", "1"},
+		{"<", "7 7", "0"},
+		{"<=", "7 7", "1"},
+		{"<=", "7 2", "0"},
+		{">", "7 2", "1"},
+		{">", "7 7", "0"},
+		{">=", "7 7", "1"},
+		{">=", "2 7", "0"},
+		{"==", "2 2", "1"},
+		{"==", "'x' 'x'", "1"},
+		{"==", "2 7", "0"},
+		{"!=", "2 7", "1"},
+		{"!=", "'x' 'x'", "0"},
+		{"&&", "7 2", "1"},
+		{"&&", "0 2", "0"},
+		{"||", "0 2", "1"},
+		{"||", "0 0", "0"},
+	}
+	for _, test := range tests {
+		src := "~f(a b)(" + test.op + " a b) f{" + test.args + "}"
+		if got := runAll(t, src); got != test.want {
+			t.Errorf("%s: got %q, want %q", src, got, test.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		//The first true branch of '|'
+		{"~f(x)(|(== x 1 'one' == x 2 'two' 1 'many')) f{2}", "two"},
+		{"~f(x)(|(== x 1 'one' == x 2 'two' 1 'many')) f{9}", "many"},
+		{"~f(x)(|(> x 0 |(> x 10 'big' 1 'small') 1 'neg')) f{5}", "small"},
+		{"~f(x)(|(> x 0 |(> x 10 'big' 1 'small') 1 'neg')) f{- 0 1}", "neg"},
+		//The last true branch of '@', 0 if none is
+		{"~f(x)(@(> x 0 'pos' > x 10 'big' < x 0 'neg')) f{20}", "big"},
+		{"~f(x)(@(> x 0 'pos' > x 10 'big' < x 0 'neg')) f{5}", "pos"},
+		{"~f(x)(@(> x 0 'pos' > x 10 'big')) f{0}", "0"},
+		//Recursion through the branches in tail position
+		{"~lp(n)(|(== n 0 'done' 1 lp{- n 1})) lp{100000}", "done"},
+		{"~lp(n)(@(1 0 == n 0 'done' != n 0 lp{- n 1})) lp{100000}", "done"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* A name after ':' is always a type, so a misspelled type is an error and
+ * a default naming a variable is parenthesized */
+func TestAnnotationsAreTypeNames(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"~f(a:nmu)(a) f{1}", "error: Unknown type 'nmu' for parameter 'a'"},
+		{"~f(a b:a)(b) f{1}", "error: Unknown type 'a' for parameter 'b'"},
+		{"~f(a):nmu(a) f{1}", "error: Unknown type 'nmu' for the result of 'f'"},
+		{":(x:nmu)(1) x", "error: Unknown type 'nmu' for binding 'x'"},
+		{"~f(a b:(a))(b) f{1}", "1"},
+		{"~f(a:num:3)(a) f{}", "3"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* Values are checked against the declared types without TypeCheck, while
+ * undeclared types are left to the run */
+func TestAnnotationsAreChecked(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"~f(a:num)(a) f{'x'}", "error: [1:16]\tArgument 1 of 'f' expects num, got str"},
+		{"~f():num('x') f{}", "error: [1:10]\tFunction body expects num, got str"},
+		{":(x:num)('s') x", "error: [1:10]\tBinding 'x' expects num, got str"},
+		{":(x:num)(1) :x 'a'", "error: [1:16]\tAssignment to 'x' expects num, got str"},
+		{"~f(a:str)(a) ~g(b)(f{b}) g{1}", "error: Parameter 'a' expects str, got num"},
+		{"~f(a)(a) :(x)(1) |(== x 1 f{'a'} 1 x)", "a"},
+		{"|(== 1 1 1 1 + 'a' 1)", "1"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestMapAnnotations(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"~f(m:map)(keys{m}) f{parseJson{'{\"a\":1,\"b\":2}'}}", "[a, b]"},
+		{"~f(m:map)(m) f{1}", "error: [1:16]\tArgument 1 of 'f' expects map[any], got num"},
+		{":(m:map)(split{'a b' ' '}) m", "error: [1:10]\tBinding 'm' expects map[any], got list[str]"},
+		{"keys{'x'}", "error: [1:6]\tArgument 1 of 'keys' expects map[any], got str"},
+		{"~f(m:map)(m) ~g(x)(f{x}) g{split{'a b' ' '}}", "error: Parameter 'm' expects map, got list"},
+		{"~f():map(parseJson{'[1]'}) f{}", "error: Result of 'f' expects map, got list"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* Closure environments out of reach are reclaimed, so a loop creating
+ * closures runs in a bounded heap */
+func TestClosureEnvironmentsAreCollected(t *testing.T) {
+	src := "~lp(n acc)( :(f)(~(x)(+ x n)) |(== n 0 acc 1 lp{- n 1 f{acc}}) ) lp{5000 0}"
+	instrs, e := presta.CompileWithOptions(strings.NewReader(src), presta.Options{})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	for _, interpret := range []bool{false, true} {
+		v := vm.NewVM(instrs)
+		v.SetLimits(vm.Limits{HeapSize: 10})
+		if interpret {
+			e = v.Interpret()
+		} else {
+			e = v.Run()
+		}
+		if e != nil {
+			t.Fatalf("interpret %v: %s", interpret, e.Message())
+		} else if result, _ := v.Result().ToString(); result != "12502500" {
+			t.Errorf("interpret %v: got %s, want 12502500", interpret, result)
+		}
+	}
+
+	//Environments in reach still count
+	src = "~deep(n)( :(f)(~()(n)) |(== n 0 0 1 + f{} deep{- n 1}) ) deep{50}"
+	if instrs, e = presta.CompileWithOptions(strings.NewReader(src), presta.Options{}); e != nil {
+		t.Fatal(e.Message())
+	}
+	v := vm.NewVM(instrs)
+	v.SetLimits(vm.Limits{HeapSize: 10})
+	if e := v.Run(); e == nil || e.Code() != err.HEAP_LIMIT_ERROR {
+		t.Errorf("%s: ran past the heap limit, got %v", src, e)
+	}
+}
+
+/* Lint is opt in, and its warnings are handed to Warn */
+func TestLintWarningsGoToWarn(t *testing.T) {
+	src := ":(x)(1) 2"
+	warnings := []string{}
+	options := presta.Options{Lint: lint.NewConfig(), Warn: func(w *err.Warning) {
+		warnings = append(warnings, w.Rule())
+	}}
+	if _, e := presta.CompileWithOptions(strings.NewReader(src), options); e != nil {
+		t.Fatal(e.Message())
+	} else if len(warnings) != 1 || warnings[0] != "unused" {
+		t.Errorf("%s: got warnings %v, want [unused]", src, warnings)
+	}
+
+	warnings = warnings[:0]
+	options.Lint = nil
+	if _, e := presta.CompileWithOptions(strings.NewReader(src), options); e != nil {
+		t.Fatal(e.Message())
+	} else if len(warnings) != 0 {
+		t.Errorf("%s: linted without a config, got warnings %v", src, warnings)
+	}
+}
+
+/* Programs run in the register file they are compiled for, also after
+ * being stored as bytecode */
+func TestRegisters(t *testing.T) {
+	src := "~f(a)(+ a + a + a + a + a + a + a + a + a + a + a + a 1) f{2}"
+	for _, registers := range []int{0, 2, 16} {
+		options := presta.Options{Registers: registers, NoOptimize: true}
+		if got := run(t, src, options); got != "25" {
+			t.Errorf("%d registers: got %s, want 25", registers, got)
+		}
+
+		program, e := presta.CompileProgram(strings.NewReader(src), options)
+		if e != nil {
+			t.Fatal(e.Message())
+		}
+		if used := ir.Registers(program.Instructions); used > program.Registers || registers == 16 && used <= ir.REGISTERS {
+			t.Errorf("%d registers: program uses %d", registers, used)
+		}
+		var b bytes.Buffer
+		if e := ir.Encode(&b, program); e != nil {
+			t.Fatal(e.Message())
+		}
+		decoded, e := ir.Decode(&b)
+		if e != nil {
+			t.Fatalf("%d registers: %s", registers, e.Message())
+		} else if decoded.Registers != program.Registers {
+			t.Errorf("%d registers: decoded %d", registers, decoded.Registers)
+		}
+		v := vm.NewVMForProgram(decoded)
+		if e := v.Run(); e != nil {
+			t.Errorf("%d registers: %s", registers, e.Message())
+		} else if result, _ := v.Result().ToString(); result != "25" {
+			t.Errorf("%d registers: decoded program gave %s, want 25", registers, result)
+		}
+	}
+}
+
+/* TypeCheck infers the type of every expression and rejects programs
+ * whose types can not agree before they run */
+func TestTypeCheck(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"+ 1 2", "3"},
+		{"~f(a)(+ a 1) f{2}", "3"},
+		{"~id(a)(a) + id{1} len{id{'ab'}}", "3"},
+		//Branches of different types join to any, binding nothing
+		{"~f(a)(|(== a 0 'zero' 1 a)) f{1}", "1"},
+		{"~f(a)(|(== a 0 'zero' 1 a)) f{0}", "zero"},
+		{"+ 1 'a'", "error: [1:5]\tOperator + expects num, got str"},
+		{"~f(a)(+ a 1) f{'x'}", "error: [1:16]\tArgument 1 of 'f' expects num, got str"},
+		{"~f(a:str)(a) f{1}", "error: [1:16]\tArgument 1 of 'f' expects str, got num"},
+		{":(x)(1) + x 'a'", "error: [1:13]\tOperator + expects num, got str"},
+		{"~f(g:fn)(g{1}) f{2}", "error: [1:18]\tArgument 1 of 'f' expects fn, got num"},
+	}
+	for _, test := range tests {
+		got := run(t, test.src, presta.Options{TypeCheck: true})
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* Check reports every type error in a tree, not only the first */
+func TestCheckReportsEveryError(t *testing.T) {
+	tokens, e := presta.Tokenize(strings.NewReader("~f(a)(+ a 1) + f{'x'} 'y'"))
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	tree, e := presta.Parse(tokens)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	_, errs := presta.Check(tree)
+	got := []string{}
+	for _, e := range errs {
+		got = append(got, e.Message())
+	}
+	want := []string{"[1:18]\tArgument 1 of 'f' expects num, got str", "[1:23]\tOperator + expects num, got str"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, errs := presta.CheckAnnotations(tree); len(errs) != 0 {
+		t.Errorf("undeclared types are checked without TypeCheck, got %d errors", len(errs))
+	}
+}
+
+/* Names are declared where they are written, so errors about them point
+ * at the name rather than the function or let declaring it */
+func TestDuplicateDeclarations(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"~f(a\n  a)(a) f{1}", "error: [2:3]\tParameter 'a' is already declared at [1:4]"},
+		{"~f(a b ...a)(a) f{1}", "error: [1:11]\tParameter 'a' is already declared at [1:4]"},
+		{":(x\n  x)(1 2) x", "error: [2:3]\tVariable 'x' is already declared at [1:3]"},
+		{"~f()(1)\n~f()(2) f{}", "error: [2:1]\tFunction 'f' is already declared at [1:1]"},
+		{":(x)(1) ~f()(1) ~f()(2) f{}", "error: [1:17]\tFunction 'f' is already declared at [1:9]"},
+		//Variables and functions are named apart
+		{"~f(a)(a) :(f)(1) f", "1"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+
+	src := ":(g)(~(a:num b:num):num(a)) g{1}"
+	if got, want := run(t, src, presta.Options{TypeCheck: true}), "error: [1:29]\tFunction 'g' of type fn(num num)num called with 1 argument"; got != want {
+		t.Errorf("%q: got %q, want %q", src, got, want)
+	}
+}
+
+func TestShadowWarnings(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{":(x)(1) ~f(a\n  x)(+ a x) f{x 2}", []string{"[2:3]\tParameter 'x' shadows the variable declared at [1:3] (shadow)"}},
+		{":(x)(1)\n:(y\n  x)(2 3) + x y", []string{"[1:3]\tVariable 'x' is never used (unused)", "[3:3]\tVariable 'x' shadows the variable declared at [1:3] (shadow)"}},
+		{"~f(a)(:(a)(1) a) f{2}", []string{"[1:4]\tParameter 'a' is never used (unused)", "[1:9]\tVariable 'a' shadows the parameter declared at [1:4] (shadowed-param)"}},
+		//Suppressed on the line of the param rather than the function
+		{":(x)(1) ~f(a\n  # lint:ignore shadow\n  x)(+ a x) f{x 2}", []string{}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		options := presta.Options{Lint: lint.NewConfig(), Warn: func(w *err.Warning) {
+			got = append(got, w.Message())
+		}}
+		if _, e := presta.CompileWithOptions(strings.NewReader(test.src), options); e != nil {
+			t.Errorf("%q: %s", test.src, e.Message())
+		} else if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%q: got warnings %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* Calls with an argument count the function does not accept fail where
+ * they are made, or at run time for function values */
+func TestArity(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"~f(a)(a) f{1 2}", "error: [1:10]\tFunction 'f' expects 1 argument, got 2"},
+		{"~f(a b)(a)\n  f{1}", "error: [2:3]\tFunction 'f' expects 2 arguments, got 1"},
+		{"~f()(1) f{1}", "error: [1:9]\tFunction 'f' expects 0 arguments, got 1"},
+		{"~f(a ...r)(a) f{}", "error: [1:15]\tFunction 'f' expects at least 1 argument, got 0"},
+		{"~f(a b:2 c:3)(a) f{}", "error: [1:18]\tFunction 'f' expects between 1 and 3 arguments, got 0"},
+		{"~f(a b:2)(a) f{1 2 3}", "error: [1:14]\tFunction 'f' expects between 1 and 2 arguments, got 3"},
+		{"len{}", "error: [1:1]\tFunction 'len' expects 1 argument, got 0"},
+		{":(g)(~(a)(a)) g{1 2}", "error: Function expects 1 argument, got 2"},
+	}
+	for _, test := range tests {
+		if got := runAll(t, test.src); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+
+	src := ":(g)(~(a:num b:num):num(a)) g{1}"
+	if got, want := run(t, src, presta.Options{TypeCheck: true}), "error: [1:29]\tFunction 'g' of type fn(num num)num called with 1 argument"; got != want {
+		t.Errorf("%q: got %q, want %q", src, got, want)
+	}
+}
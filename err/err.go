@@ -24,6 +24,10 @@
 
 package err
 
+import (
+	"strconv"
+)
+
 type ErrorCode int64
 
 const (
@@ -31,6 +35,15 @@ const (
 	SYNTAX_ERROR
 	SEMANTIC_ERROR
 	RUNTIME_ERROR
+	TYPE_ERROR
+	WARNING
+	LOAD_ERROR
+	INSTRUCTION_LIMIT_ERROR
+	CALL_DEPTH_LIMIT_ERROR
+	STACK_LIMIT_ERROR
+	HEAP_LIMIT_ERROR
+	TIMEOUT_ERROR
+	CANCELED_ERROR
 )
 
 type Error interface {
@@ -101,3 +114,88 @@ func (r *RuntimeError) Message() string {
 func (r *RuntimeError) Code() ErrorCode {
 	return RUNTIME_ERROR
 }
+
+type TypeError struct {
+	msg string
+}
+
+func NewTypeError(msg string) *TypeError {
+	return &TypeError{msg: msg}
+}
+
+func (t *TypeError) Message() string {
+	return t.msg
+}
+
+func (t *TypeError) Code() ErrorCode {
+	return TYPE_ERROR
+}
+
+type LoadError struct {
+	msg string
+}
+
+func NewLoadError(msg string) *LoadError {
+	return &LoadError{msg: msg}
+}
+
+func (l *LoadError) Message() string {
+	return l.msg
+}
+
+func (l *LoadError) Code() ErrorCode {
+	return LOAD_ERROR
+}
+
+/* A run stopped by one of the VM's execution limits or by its context,
+ * the code telling which */
+type LimitError struct {
+	code ErrorCode
+	msg  string
+}
+
+func NewLimitError(code ErrorCode, msg string) *LimitError {
+	return &LimitError{code: code, msg: msg}
+}
+
+func (l *LimitError) Message() string {
+	return l.msg
+}
+
+func (l *LimitError) Code() ErrorCode {
+	return l.code
+}
+
+/* A problem which does not stop compilation. Warnings are reported by
+ * rule so each kind can be enabled, disabled or suppressed on its own.
+ * Lines and columns count from 1, as in the message. */
+type Warning struct {
+	rule   string
+	line   int64
+	column int64
+	msg    string
+}
+
+func NewWarning(rule string, line int64, column int64, msg string) *Warning {
+	return &Warning{rule: rule, line: line, column: column, msg: msg}
+}
+
+func (w *Warning) Message() string {
+	return "[" + strconv.FormatInt(w.line, 10) + ":" + strconv.FormatInt(w.column, 10) + "]\t" + w.msg + " (" + w.rule + ")"
+}
+
+func (w *Warning) Code() ErrorCode {
+	return WARNING
+}
+
+func (w *Warning) Rule() string {
+	return w.rule
+}
+
+func (w *Warning) Line() int64 {
+	return w.line
+}
+
+func (w *Warning) Column() int64 {
+	return w.column
+}
@@ -0,0 +1,491 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rkophs/presta/code"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/parser"
+	"github.com/rkophs/presta/types"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* Renders trees as canonical presta source. Lets and matches are broken
+ * over lines, one binding or case per line, and so are functions with
+ * nested declarations or a body which is broken. Everything else is
+ * written on one line. Blocks are indented with tabs.
+ *
+ * Comments after code on a line stay at the end of the line holding
+ * that code. Others are written on a line of their own just above the
+ * first node after them which starts a line, so lint suppressions and
+ * noinline comments keep applying to the code they were next to. When
+ * code from several source lines is joined on one line, only the comment
+ * of the last of them stays at its end, the others being written on lines
+ * of their own above it. */
+type printer struct {
+	buffer    bytes.Buffer
+	indent    int
+	lineStart int              //Offset in buffer of the line being written
+	comments  []parser.Comment //Not yet written, in source order
+	last      parser.Position  //Of the node last written
+	err       err.Error
+}
+
+/* Format source code, keeping its comments */
+func Source(src []byte) ([]byte, err.Error) {
+	tree, comments, e := parse(src)
+	if e != nil {
+		return nil, e
+	}
+
+	var buffer bytes.Buffer
+	if e := Node(&buffer, tree, comments); e != nil {
+		return nil, e
+	}
+
+	//The output must read back as the same program
+	formatted, _, e := parse(buffer.Bytes())
+	if e != nil {
+		return nil, err.NewRuntimeError("Formatted source does not parse: " + e.Message())
+	} else if serialize(formatted) != serialize(tree) {
+		return nil, err.NewRuntimeError("Formatted source is not the same program")
+	}
+	return buffer.Bytes(), nil
+}
+
+/* Parse a whole program and its comments */
+func parse(src []byte) (code.AstNode, []parser.Comment, err.Error) {
+	s := parser.NewLexScanner(bytes.NewReader(src))
+	tokens := []parser.Token{}
+	for {
+		tok := s.Scan()
+		if tok.Type() == parser.EOF {
+			break
+		} else if tok.Type() == parser.ILLEGAL {
+			return nil, nil, err.NewLexicalError(fmt.Sprintf("%s\tIllegal token:\t%q\n", tok.Position(), tok.Lit()))
+		}
+		tokens = append(tokens, *tok)
+	}
+
+	p := parser.NewTokenScanner(tokens)
+	tree, e := code.NewProgram(p)
+	if e != nil {
+		return nil, nil, e
+	} else if tok, eof := p.Peek(); !eof {
+		//The program would be formatted without them
+		return nil, nil, err.NewSyntaxError(tok.Position().String() + "\tUnexpected " + strconv.Quote(tok.Lit()) + " after the program body")
+	}
+	return tree, s.Comments(), nil
+}
+
+func serialize(node code.AstNode) string {
+	var buffer bytes.Buffer
+	node.Serialize(&buffer)
+	return buffer.String()
+}
+
+/* Write the source of node, with the comments of the source it was parsed
+ * from, which may be nil. Nodes without a source form, such as strings
+ * holding a quote, are errors. */
+func Node(w io.Writer, node code.AstNode, comments []parser.Comment) err.Error {
+	p := &printer{comments: comments}
+	p.startLine(node)
+	p.node(node)
+	p.trailing()
+	for _, comment := range p.comments {
+		p.buffer.WriteRune('\n')
+		p.comment(comment)
+	}
+	p.buffer.WriteRune('\n')
+
+	if p.err != nil {
+		return p.err
+	} else if _, e := w.Write(p.buffer.Bytes()); e != nil {
+		return err.NewRuntimeError(e.Error())
+	}
+	return nil
+}
+
+/*=================================================================================*/
+
+func (p *printer) write(s string) {
+	p.buffer.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.trailing()
+	p.lineBreak()
+}
+
+func (p *printer) lineBreak() {
+	p.buffer.WriteRune('\n')
+	p.lineStart = p.buffer.Len()
+	for i := 0; i < p.indent; i++ {
+		p.buffer.WriteRune('\t')
+	}
+}
+
+func (p *printer) fail(msg string) {
+	if p.err == nil {
+		p.err = err.NewSyntaxError("Cannot format: " + msg)
+	}
+}
+
+func (p *printer) comment(comment parser.Comment) {
+	p.write("#" + strings.TrimRight(comment.Text, " \t\r"))
+}
+
+/* End the line with the comments from the source lines of the code on it,
+ * each of which is on a line of its own */
+func (p *printer) trailing() {
+	for len(p.comments) > 0 && p.comments[0].Pos.Line <= p.last.Line {
+		if len(p.comments) > 1 && p.comments[1].Pos.Line <= p.last.Line {
+			p.above(p.comments[0])
+		} else {
+			p.write(" ")
+			p.comment(p.comments[0])
+		}
+		p.comments = p.comments[1:]
+	}
+}
+
+/* Write a comment on a line of its own above the line being written, at
+ * the same indentation */
+func (p *printer) above(comment parser.Comment) {
+	line := p.buffer.Bytes()[p.lineStart:]
+	indent := len(line) - len(bytes.TrimLeft(line, "\t"))
+	text := string(line[:indent]) + "#" + strings.TrimRight(comment.Text, " \t\r") + "\n"
+
+	rest := append([]byte(text), line...)
+	p.buffer.Truncate(p.lineStart)
+	p.buffer.Write(rest)
+	p.lineStart += len(text)
+}
+
+/* Write the comments before a node which starts a line on lines of their
+ * own */
+func (p *printer) startLine(node code.AstNode) {
+	pos := node.Position()
+	for len(p.comments) > 0 {
+		c := p.comments[0].Pos
+		if c.Line > pos.Line || (c.Line == pos.Line && c.Column > pos.Column) {
+			return
+		}
+		p.comment(p.comments[0])
+		p.comments = p.comments[1:]
+		p.lineBreak()
+	}
+}
+
+func (p *printer) name(name string) {
+	valid := name != ""
+	for i, ch := range name {
+		letter := (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+		valid = valid && (letter || (i > 0 && ((ch >= '0' && ch <= '9') || ch == '_')))
+	}
+	if !valid {
+		p.fail(strconv.Quote(name) + " is not a name")
+	}
+	p.write(name)
+}
+
+func (p *printer) list(nodes []code.AstNode) {
+	for i, node := range nodes {
+		if i > 0 {
+			p.write(" ")
+		}
+		p.node(node)
+	}
+}
+
+/*=================================================================================*/
+
+/* Whether a node is written on a single line */
+func flat(node code.AstNode) bool {
+	switch n := node.(type) {
+	case *code.Let, *code.Match:
+		return false
+	case *code.Function:
+		if len(n.Functions()) > 0 {
+			return false
+		}
+	case *code.Lambda:
+		if len(n.Function().Functions()) > 0 {
+			return false
+		}
+	}
+
+	simple := true
+	code.Inspect(node, func(child code.AstNode) bool {
+		if child != nil && child != node {
+			simple = simple && flat(child)
+			return false
+		}
+		return child == node
+	})
+	return simple
+}
+
+func (p *printer) node(node code.AstNode) {
+	if pos := node.Position(); pos.Line > p.last.Line || (pos.Line == p.last.Line && pos.Column > p.last.Column) {
+		p.last = pos
+	}
+	switch n := node.(type) {
+	case *code.Program:
+		p.program(n)
+	case *code.Function:
+		p.write("~")
+		p.name(n.Name())
+		p.function(n)
+	case *code.Lambda:
+		p.write("~")
+		p.function(n.Function())
+	case *code.Let:
+		p.let(n)
+	case *code.Match:
+		p.match(n)
+	case *code.Repeat:
+		p.write("^ ")
+		p.node(n.Condition())
+		p.write(" ")
+		p.node(n.Body())
+	case *code.Assign:
+		p.write(":")
+		p.name(n.Name())
+		p.write(" ")
+		p.node(n.Value())
+	case *code.Concat:
+		p.write(".(")
+		p.list(n.Components())
+		p.write(")")
+	case *code.Call:
+		p.name(n.Name())
+		p.write("{")
+		p.list(n.Args())
+		p.write("}")
+	case *code.Not:
+		p.not(n)
+	case *code.BinOp:
+		p.write(operators[n.Op()] + " ")
+		p.node(n.Left())
+		p.write(" ")
+		p.node(n.Right())
+	case *code.Data:
+		p.data(n)
+	case *code.Variable:
+		p.name(n.Name())
+	default:
+		p.fail(fmt.Sprintf("unknown node %T", node))
+	}
+}
+
+var operators = map[code.BinOpType]string{
+	code.ADD: "+", code.SUB: "-", code.MULT: "*", code.DIV: "/", code.MOD: "%",
+	code.ADD_I: "+=", code.SUB_I: "-=", code.MULT_I: "*=", code.DIV_I: "/=", code.MOD_I: "%=",
+	code.LT: "<", code.LTE: "<=", code.GT: ">", code.GTE: ">=", code.EQ: "==", code.NEQ: "!=",
+	code.AND: "&&", code.OR: "||",
+}
+
+func (p *printer) program(n *code.Program) {
+	for _, fn := range n.Functions() {
+		p.startLine(fn)
+		p.node(fn)
+		p.newline()
+		p.newline()
+	}
+	p.startLine(n.Body())
+	p.node(n.Body())
+}
+
+/* The params, result and body of a function, after its name */
+func (p *printer) function(f *code.Function) {
+	p.write("(")
+	for i, param := range f.Params() {
+		if i > 0 {
+			p.write(" ")
+		}
+		p.param(param)
+	}
+	if f.Rest() != "" {
+		if len(f.Params()) > 0 {
+			p.write(" ")
+		}
+		p.write("...")
+		p.name(f.Rest())
+	}
+	p.write(")")
+	p.annotation(f.Result())
+
+	p.write("(")
+	if len(f.Functions()) == 0 && flat(f.Body()) {
+		p.node(f.Body())
+		p.write(")")
+		return
+	}
+	p.indent++
+	for _, fn := range f.Functions() {
+		p.newline()
+		p.startLine(fn)
+		p.node(fn)
+	}
+	p.newline()
+	p.startLine(f.Body())
+	p.node(f.Body())
+	p.indent--
+	p.newline()
+	p.write(")")
+}
+
+func (p *printer) param(param *code.Param) {
+	p.name(param.Name)
+	p.annotation(param.Annotation)
+	if param.Default == nil {
+		return
+	}
+
+	//A name after ':' is read as a type, so a default starting with one
+	//is parenthesized
+	p.write(":")
+	if startsWithName(param.Default) {
+		p.write("(")
+		p.node(param.Default)
+		p.write(")")
+	} else {
+		p.node(param.Default)
+	}
+}
+
+func startsWithName(node code.AstNode) bool {
+	switch node.(type) {
+	case *code.Variable, *code.Call:
+		return true
+	}
+	return false
+}
+
+func (p *printer) annotation(annotation string) {
+	if annotation == "" {
+		return
+	} else if _, ok := types.Named(annotation); !ok {
+		p.fail(strconv.Quote(annotation) + " is not a type")
+	}
+	p.write(":" + annotation)
+}
+
+func (p *printer) let(n *code.Let) {
+	bindings := n.Bindings()
+	p.write(":(")
+	values := []code.AstNode{}
+	for i, binding := range bindings {
+		if i > 0 {
+			p.write(" ")
+		}
+		p.name(binding.Name)
+		p.annotation(binding.Annotation)
+		values = append(values, binding.Value)
+	}
+	p.write(")(")
+
+	simple := true
+	for _, value := range values {
+		simple = simple && flat(value)
+	}
+	if simple {
+		p.list(values)
+	} else {
+		p.indent++
+		for _, value := range values {
+			p.newline()
+			p.startLine(value)
+			p.node(value)
+		}
+		p.indent--
+		p.newline()
+	}
+	p.write(")")
+
+	for _, fn := range n.Functions() {
+		p.newline()
+		p.startLine(fn)
+		p.node(fn)
+	}
+	p.newline()
+	p.startLine(n.Body())
+	p.node(n.Body())
+}
+
+func (p *printer) match(n *code.Match) {
+	if n.MatchType() == code.ALL {
+		p.write("@(")
+	} else {
+		p.write("|(")
+	}
+	p.indent++
+	for _, c := range n.Cases() {
+		p.newline()
+		p.startLine(c.Condition)
+		p.node(c.Condition)
+		p.write(" ")
+		p.node(c.Branch)
+	}
+	p.indent--
+	p.newline()
+	p.write(")")
+}
+
+/* Operations are parenthesized after '!', which would otherwise join
+ * with the '=' of '==' */
+func (p *printer) not(n *code.Not) {
+	p.write("!")
+	if _, ok := n.Expression().(*code.BinOp); ok {
+		p.write("(")
+		p.node(n.Expression())
+		p.write(")")
+	} else {
+		p.node(n.Expression())
+	}
+}
+
+/* Literals have no sign, exponent or escapes */
+func (p *printer) data(n *code.Data) {
+	if n.DataType() == code.STRING {
+		if strings.ContainsRune(n.StringValue(), '\'') {
+			p.fail("the string " + strconv.Quote(n.StringValue()) + " holds a quote")
+		}
+		p.write("'" + n.StringValue() + "'")
+		return
+	}
+
+	num := n.NumberValue()
+	if num < 0 || math.IsNaN(num) || math.IsInf(num, 0) || (num == 0 && math.Signbit(num)) {
+		p.fail("the number " + strconv.FormatFloat(num, 'g', -1, 64) + " has no literal")
+	}
+	p.write(strconv.FormatFloat(num, 'f', -1, 64))
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package format_test
+
+import (
+	"bytes"
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/format"
+	"github.com/rkophs/presta/lint"
+	"testing"
+)
+
+var SOURCES = []string{
+	"+ 1 2",
+	"~f(a)( # inner\n+ a 1 ) # trailing\nf{1}",
+	"~f(a:num b:str:'x' ...r):num(\n\t~g(c)(c)\n\tg{a}\n)\nf{1}",
+	":(x y)(1 2) # lint:ignore unused\nx",
+	"# noinline\n~f(a)(+ a 1)\n\n# lint:ignore\n:(unused)(3)\nf{2}",
+	"~f(a)( # noinline\n+ a 1 # lint:ignore\n) # kept\n:(b)(f{1}) |(== b 2 'two' # first\n1 'other') # last",
+	"@(< 1 2 'a' # one\n> 1 2 'b' # two\n1 'c')",
+	"^ < 1 2 # loop\n:(i)(0) # binding\n:i + i 1",
+	"!.( 'a' # part\n'b')",
+	"~(x)( # lambda\n* x 2 )",
+	"~g(x)(x) ~f(a b:(a) c:(g{a}) d:+ a 1)(+ b c) f{1}",
+}
+
+/* The tree a source parses to, serialized */
+func parse(t *testing.T, src []byte) string {
+	tokens, e := presta.Tokenize(bytes.NewReader(src))
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	tree, e := presta.Parse(tokens)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	var b bytes.Buffer
+	tree.Serialize(&b)
+	return b.String()
+}
+
+/* What the comments of a source direct: the lint warnings left once
+ * suppressions apply and the tree once noinline comments apply */
+func directed(t *testing.T, src []byte) (string, string) {
+	tokens, comments, e := presta.TokenizeWithComments(bytes.NewReader(src))
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	tree, e := presta.Parse(tokens)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	warnings, e := presta.Lint(tree, comments, lint.NewConfig())
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	var lints bytes.Buffer
+	for _, warning := range warnings {
+		lints.WriteString(warning.Rule() + "\n")
+	}
+
+	optimized, e := presta.Optimize(tree, comments)
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	var b bytes.Buffer
+	optimized.Serialize(&b)
+	return lints.String(), b.String()
+}
+
+func TestSourceIsIdempotent(t *testing.T) {
+	for _, src := range SOURCES {
+		out, e := format.Source([]byte(src))
+		if e != nil {
+			t.Errorf("%q: %s", src, e.Message())
+			continue
+		}
+		again, e := format.Source(out)
+		if e != nil {
+			t.Errorf("%q: reformatting: %s", src, e.Message())
+		} else if !bytes.Equal(out, again) {
+			t.Errorf("%q: formatted to\n%s\nthen to\n%s", src, out, again)
+		}
+	}
+}
+
+func TestSourceKeepsTree(t *testing.T) {
+	for _, src := range SOURCES {
+		out, e := format.Source([]byte(src))
+		if e != nil {
+			t.Errorf("%q: %s", src, e.Message())
+			continue
+		}
+		if before, after := parse(t, []byte(src)), parse(t, out); before != after {
+			t.Errorf("%q: formatted to\n%s\nwhich parses to %s, not %s", src, out, after, before)
+		}
+	}
+}
+
+func TestSourceKeepsDirectives(t *testing.T) {
+	for _, src := range SOURCES {
+		out, e := format.Source([]byte(src))
+		if e != nil {
+			t.Errorf("%q: %s", src, e.Message())
+			continue
+		}
+		lints, optimized := directed(t, []byte(src))
+		formattedLints, formattedOptimized := directed(t, out)
+		if lints != formattedLints {
+			t.Errorf("%q: formatted to\n%s\nwhich warns %q, not %q", src, out, formattedLints, lints)
+		}
+		if optimized != formattedOptimized {
+			t.Errorf("%q: formatted to\n%s\nwhich optimizes to %s, not %s", src, out, formattedOptimized, optimized)
+		}
+	}
+}
+
+func TestSourceKeepsCommentsApart(t *testing.T) {
+	out, e := format.Source([]byte("~f(a)( # inner\n+ a 1 ) # trailing\nf{1}"))
+	if e != nil {
+		t.Fatal(e.Message())
+	} else if want := "# inner\n~f(a)(+ a 1) # trailing\n\nf{1}\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package presta_test
+
+import (
+	"errors"
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/vm"
+	"strings"
+	"testing"
+)
+
+func testHost(t *testing.T) *system.Host {
+	h := system.NewHost()
+	register := []err.Error{
+		h.Register("double", 1, func(args []system.Value) (system.Value, error) {
+			n, e := args[0].ToNumber()
+			if e != nil {
+				return nil, errors.New(e.Message())
+			}
+			return system.NewNumber(2 * n), nil
+		}),
+		h.RegisterVariadic("count", 0, func(args []system.Value) (system.Value, error) {
+			return system.NewNumber(float64(len(args))), nil
+		}),
+		h.Register("fail", 0, func(args []system.Value) (system.Value, error) {
+			return nil, errors.New("failed")
+		}),
+		h.Register("nothing", 0, func(args []system.Value) (system.Value, error) {
+			return nil, nil
+		}),
+		h.Register("explode", 0, func(args []system.Value) (system.Value, error) {
+			panic("boom")
+		}),
+		h.Register("nth", 1, func(args []system.Value) (system.Value, error) {
+			return []system.Value{}[len(args)], nil
+		}),
+		//Shadows the builtin
+		h.Register("len", 1, func(args []system.Value) (system.Value, error) {
+			return system.NewString("host"), nil
+		}),
+	}
+	for _, e := range register {
+		if e != nil {
+			t.Fatal(e.Message())
+		}
+	}
+	return h
+}
+
+func TestHostFunctions(t *testing.T) {
+	host := testHost(t)
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"double{21}", "42"},
+		{"+ count{} count{1 'a' 3}", "3"},
+		{"len{'abc'}", "host"},
+		{"typeOf{nothing{}}", "undefined"},
+		//Script functions shadow host functions
+		{"~double(a)(a) double{21}", "21"},
+		{"fail{}", "error: fail: failed"},
+		{"double{'x'}", "error: double: string type not convertable to number."},
+		{"explode{}", "error: explode: panic: boom"},
+		{"nth{1}", "error: nth: panic: runtime error: index out of range [1] with length 0"},
+		{"double{1 2}", "error: [1:1]\tFunction 'double' expects 1 argument, got 2"},
+		{"undeclared{1}", "error: [1:1]\tFunction 'undeclared' not found"},
+	}
+	for _, test := range tests {
+		got := run(t, test.src, presta.Options{Host: host})
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* A program calling a host function must run with it */
+func TestHostNotRegistered(t *testing.T) {
+	instrs, e := presta.CompileWithOptions(strings.NewReader("double{1}"), presta.Options{Host: testHost(t)})
+	if e != nil {
+		t.Fatal(e.Message())
+	}
+	for _, host := range []*system.Host{nil, system.NewHost()} {
+		v := vm.NewVM(instrs)
+		v.SetHost(host)
+		if e := v.Run(); e == nil || e.Code() != err.RUNTIME_ERROR || e.Message() != "Host function 'double' is not registered" {
+			t.Errorf("ran without the host function, got %v", e)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/system"
 )
 
 type Error struct {
@@ -42,6 +43,12 @@ type Code struct {
 	Ax           *ir.RegisterAccess
 	count        int
 	frameOffset  int
+	labels       []*ir.InstructionLocation //Block relative jump targets
+	tails        map[interface{}]bool      //Nodes in tail position
+	params       int                       //Argument count of the frame
+	registers    []bool                    //Temporary registers in use
+	peephole     bool                      //Rewrite blocks when linking
+	constants    *ir.ConstantPool          //Shared by every block
 }
 
 func NewCode(linker *Linker) *Code {
@@ -52,9 +59,50 @@ func NewCode(linker *Linker) *Code {
 		count:        0,
 		frameOffset:  0,
 		Ax:           ir.NewRegisterAccess(0),
+		labels:       make([]*ir.InstructionLocation, 0),
+		tails:        make(map[interface{}]bool),
+		params:       -1,
+		registers:    make([]bool, ir.REGISTERS),
+		peephole:     true,
+		constants:    ir.NewConstantPool(),
 	}
 }
 
+/* A code block for a function body, configured like this one */
+func (c *Code) NewBlock() *Code {
+	block := NewCode(c.linker)
+	block.registers = make([]bool, len(c.registers))
+	block.peephole = c.peephole
+	block.constants = c.constants
+	return block
+}
+
+/* Set the size of the register file temporaries may be allocated from,
+ * counting the accumulator */
+func (c *Code) SetRegisterCount(count int) {
+	c.registers = make([]bool, count)
+}
+
+func (c *Code) SetPeephole(enabled bool) {
+	c.peephole = enabled
+}
+
+/* Reserve a register for a temporary. Values are kept on the stack
+ * instead when every register is in use. */
+func (c *Code) AllocRegister() (*ir.RegisterAccess, bool) {
+	for id := 1; id < len(c.registers); id++ {
+		if !c.registers[id] {
+			c.registers[id] = true
+			return ir.NewRegisterAccess(id), true
+		}
+	}
+	return nil, false
+}
+
+func (c *Code) FreeRegister(id int) {
+	c.registers[id] = false
+}
+
 func (c *Code) Append(elem ir.Instruction) {
 	c.instructions = append(c.instructions, elem)
 	c.count++
@@ -73,22 +121,92 @@ func (c *Code) IncrFrameOffset(amount int) {
 }
 
 func (c *Code) ResetFrameOffset(amount int) {
-	c.frameOffset = 0
+	c.frameOffset = amount
 }
 
 func (c *Code) AppendBlock(block *Code) {
+	for _, label := range block.labels {
+		label.SetLocation(label.GetLocation() + c.count)
+	}
+	c.labels = append(c.labels, block.labels...)
 	c.instructions = append(c.instructions, block.instructions...)
 	c.count += block.count
 }
 
-func (c *Code) SetFunctionOffset(id string, offset *ir.InstructionLocation) {
+/* Shrink the stack back to a previous frame offset */
+func (c *Code) RestoreFrameOffset(offset int) {
+	if c.frameOffset != offset {
+		c.Append(ir.NewShrink(offset))
+		c.frameOffset = offset
+	}
+}
+
+/* A jump target relative to this block, relocated when appended */
+func (c *Code) NewLabel() *ir.InstructionLocation {
+	label := ir.NewInstructionLocation(-1)
+	c.labels = append(c.labels, label)
+	return label
+}
+
+/* Point the label at the next appended instruction */
+func (c *Code) PlaceLabel(label *ir.InstructionLocation) {
+	label.SetLocation(c.count)
+}
+
+/* Frames generated for function bodies record their argument count so
+ * tail calls know how much of the frame to replace */
+func (c *Code) SetParamCount(params int) {
+	c.params = params
+}
+
+func (c *Code) GetParamCount() int {
+	return c.params
+}
+
+func (c *Code) MarkTail(node interface{}) {
+	c.tails[node] = true
+}
+
+/* Only nodes within a function body can be in tail position */
+func (c *Code) IsTail(node interface{}) bool {
+	return c.params >= 0 && c.tails[node]
+}
+
+func (c *Code) SetFunctionOffset(id int, offset *ir.InstructionLocation) {
 	c.linker.SetFunctionOffset(id, offset)
 }
 
-func (c *Code) GetFunctionOffset(id string) *ir.InstructionLocation {
+func (c *Code) SetFunctionName(id int, name string) {
+	c.linker.SetFunctionName(id, name)
+}
+
+func (c *Code) GetFunctionOffset(id int) *ir.InstructionLocation {
 	return c.linker.GetFunctionOffset(id)
 }
 
+func (c *Code) AddFunctionBlock(location *ir.InstructionLocation, block *Code) {
+	c.linker.AddFunctionBlock(location, block)
+}
+
+/* Append every generated function body after the current instructions
+ * and resolve their linker offsets */
+func (c *Code) LinkFunctions() {
+	c.optimize()
+	for _, block := range c.linker.blocks {
+		block.code.optimize()
+		block.location.SetLocation(c.count)
+		c.AppendBlock(block.code)
+	}
+	c.linker.blocks = c.linker.blocks[:0]
+}
+
+func (c *Code) optimize() {
+	if c.peephole {
+		c.instructions = ir.Peephole(c.instructions, c.labels)
+		c.count = len(c.instructions)
+	}
+}
+
 func (c *Code) GetLinker() *Linker {
 	return c.linker
 }
@@ -101,10 +219,20 @@ func (c *Code) GetVariableLocation(id int) ir.Accessor {
 	return c.vars[id]
 }
 
+/* An accessor for a constant in the program's pool */
+func (c *Code) Constant(entry system.StackEntry) *ir.ConstantAccess {
+	return c.constants.Access(entry)
+}
+
 func (c *Code) GetInstructions() []ir.Instruction {
 	return c.instructions
 }
 
+/* The linked program with its function table */
+func (c *Code) GetProgram() *ir.Program {
+	return &ir.Program{Instructions: c.instructions, Constants: c.constants, Functions: c.linker.Functions(), Debug: true, Registers: len(c.registers)}
+}
+
 func (c *Code) Serialize(buffer *bytes.Buffer) {
 	for i, instr := range c.instructions {
 		fmt.Println(i)
@@ -29,17 +29,41 @@ import (
 )
 
 type Linker struct {
-	linker map[string]*ir.InstructionLocation
+	linker map[int]*ir.InstructionLocation
+	names  map[int]string
+	blocks []*functionBlock
+}
+
+type functionBlock struct {
+	location *ir.InstructionLocation
+	code     *Code
 }
 
 func NewLinker() *Linker {
-	return &Linker{linker: make(map[string]*ir.InstructionLocation)}
+	return &Linker{linker: make(map[int]*ir.InstructionLocation), names: make(map[int]string), blocks: make([]*functionBlock, 0)}
+}
+
+func (c *Linker) SetFunctionName(id int, name string) {
+	c.names[id] = name
 }
 
-func (c *Linker) SetFunctionOffset(id string, offset *ir.InstructionLocation) {
+/* The function table once every block is linked */
+func (c *Linker) Functions() []ir.Function {
+	functions := []ir.Function{}
+	for id, location := range c.linker {
+		functions = append(functions, ir.Function{Id: id, Offset: location.GetLocation(), Name: c.names[id]})
+	}
+	return functions
+}
+
+func (c *Linker) SetFunctionOffset(id int, offset *ir.InstructionLocation) {
 	c.linker[id] = offset
 }
 
-func (c *Linker) GetFunctionOffset(id string) *ir.InstructionLocation {
+func (c *Linker) GetFunctionOffset(id int) *ir.InstructionLocation {
 	return c.linker[id]
 }
+
+func (c *Linker) AddFunctionBlock(location *ir.InstructionLocation, block *Code) {
+	c.blocks = append(c.blocks, &functionBlock{location: location, code: block})
+}
@@ -72,6 +72,10 @@ type MemoryAccess struct {
 	addr int
 }
 
+func NewMemoryAccess(addr int) *MemoryAccess {
+	return &MemoryAccess{addr: addr}
+}
+
 func (m *MemoryAccess) ToValue(s system.System) system.StackEntry {
 	return s.FetchM(m.addr)
 }
@@ -99,6 +103,10 @@ func NewRegisterAccess(id int) *RegisterAccess {
 	return &RegisterAccess{id: id}
 }
 
+func (r *RegisterAccess) Id() int {
+	return r.id
+}
+
 func (r *RegisterAccess) ToValue(s system.System) system.StackEntry {
 	return s.FetchR(r.id)
 }
@@ -114,15 +122,24 @@ func (r *RegisterAccess) Serialize(buffer *bytes.Buffer) {
 
 /*=================================================================================*/
 type ConstantAccess struct {
-	entry system.StackEntry
+	pool  *ConstantPool
+	index int
 }
 
-func NewConstantAccess(entry system.StackEntry) *ConstantAccess {
-	return &ConstantAccess{entry: entry}
+func NewConstantAccess(pool *ConstantPool, index int) *ConstantAccess {
+	return &ConstantAccess{pool: pool, index: index}
+}
+
+func (c *ConstantAccess) Index() int {
+	return c.index
+}
+
+func (c *ConstantAccess) Entry() system.StackEntry {
+	return c.pool.Get(c.index)
 }
 
 func (c *ConstantAccess) ToValue(s system.System) system.StackEntry {
-	return c.entry
+	return c.pool.Get(c.index)
 }
 
 func (c *ConstantAccess) Assign(s system.System, entry system.StackEntry) {
@@ -130,6 +147,41 @@ func (c *ConstantAccess) Assign(s system.System, entry system.StackEntry) {
 }
 
 func (c *ConstantAccess) Serialize(buffer *bytes.Buffer) {
-	hex, _ := c.entry.ToHex()
-	buffer.WriteString(hex)
+	buffer.WriteString(literal(c.Entry()))
+}
+
+/*=================================================================================*/
+type EnvAccess struct {
+	index int
+}
+
+func NewEnvAccess(index int) *EnvAccess {
+	return &EnvAccess{index: index}
+}
+
+func (e *EnvAccess) addr(s system.System) (int, bool) {
+	if fn, ok := s.FetchS(0).(*system.Function); ok {
+		return fn.Env() + e.index, true
+	}
+	s.SetError("Frame has no closure environment.")
+	return -1, false
+}
+
+func (e *EnvAccess) ToValue(s system.System) system.StackEntry {
+	if addr, ok := e.addr(s); ok {
+		return s.FetchM(addr)
+	}
+	return system.NewUndefined()
+}
+
+func (e *EnvAccess) Assign(s system.System, entry system.StackEntry) {
+	if addr, ok := e.addr(s); ok {
+		s.SetM(addr, entry)
+	}
+}
+
+func (e *EnvAccess) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("E(0x")
+	buffer.WriteString(strconv.FormatInt(int64(e.index), 16))
+	buffer.WriteRune(')')
 }
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+import (
+	"bytes"
+	"github.com/rkophs/presta/system"
+	"math"
+)
+
+/* Binary operations other than addition. Like Add, the result is stored
+ * back into the left operand. Comparisons and logical operations yield
+ * 1 for true and 0 for false. */
+type BinOp struct {
+	op InstructionType
+	l  Accessor
+	r  Accessor
+}
+
+func NewBinOp(op InstructionType, l, r Accessor) *BinOp {
+	return &BinOp{op: op, l: l, r: r}
+}
+
+func (b *BinOp) Execute(s system.System) {
+	l := b.l.ToValue(s)
+	r := b.r.ToValue(s)
+
+	switch b.op {
+	case EQ:
+		b.l.Assign(s, fromBool(Equal(l, r)))
+		return
+	case NEQ:
+		b.l.Assign(s, fromBool(!Equal(l, r)))
+		return
+	case AND:
+		b.l.Assign(s, fromBool(Truthy(l) && Truthy(r)))
+		return
+	case OR:
+		b.l.Assign(s, fromBool(Truthy(l) || Truthy(r)))
+		return
+	}
+
+	lv, e := l.ToNumber()
+	if e != nil {
+		s.SetError(b.op.Mnemonic() + " requires 2 numbers")
+		return
+	}
+	rv, e := r.ToNumber()
+	if e != nil {
+		s.SetError(b.op.Mnemonic() + " requires 2 numbers")
+		return
+	}
+
+	var result system.StackEntry
+	switch b.op {
+	case SUB:
+		result = system.NewNumber(lv - rv)
+	case MULT:
+		result = system.NewNumber(lv * rv)
+	case DIV:
+		if rv == 0 {
+			s.SetError("Division by zero")
+			return
+		}
+		result = system.NewNumber(lv / rv)
+	case MOD:
+		if rv == 0 {
+			s.SetError("Division by zero")
+			return
+		}
+		result = system.NewNumber(math.Mod(lv, rv))
+	case LT:
+		result = fromBool(lv < rv)
+	case LTE:
+		result = fromBool(lv <= rv)
+	case GT:
+		result = fromBool(lv > rv)
+	case GTE:
+		result = fromBool(lv >= rv)
+	default:
+		s.SetError("Unsupported binary operation")
+		return
+	}
+	b.l.Assign(s, result)
+}
+
+/* The mnemonic of a binary operation */
+func (t InstructionType) Mnemonic() string {
+	switch t {
+	case SUB:
+		return "sub"
+	case MULT:
+		return "mult"
+	case DIV:
+		return "div"
+	case MOD:
+		return "mod"
+	case LT:
+		return "lt"
+	case LTE:
+		return "lte"
+	case GT:
+		return "gt"
+	case GTE:
+		return "gte"
+	case EQ:
+		return "eq"
+	case NEQ:
+		return "neq"
+	case AND:
+		return "and"
+	case OR:
+		return "or"
+	default:
+		return "binop"
+	}
+}
+
+func (b *BinOp) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString(b.op.Mnemonic())
+	buffer.WriteRune('\t')
+	b.l.Serialize(buffer)
+	buffer.WriteRune(',')
+	b.r.Serialize(buffer)
+	buffer.WriteRune('\n')
+}
+
+/* Numbers are true when non zero, strings, lists and maps when non empty and
+ * functions always */
+func Truthy(entry system.StackEntry) bool {
+	switch v := entry.(type) {
+	case *system.Number:
+		n, _ := v.ToNumber()
+		return n != 0
+	case *system.String:
+		str, _ := v.ToString()
+		return str != ""
+	case *system.List:
+		return len(v.Entries()) > 0
+	case *system.Map:
+		return v.Len() > 0
+	case *system.Undefined, nil:
+		return false
+	default:
+		return true
+	}
+}
+
+/* Values are equal when they have the same type and contents */
+func Equal(l, r system.StackEntry) bool {
+	switch lv := l.(type) {
+	case *system.Number:
+		if rv, ok := r.(*system.Number); ok {
+			ln, _ := lv.ToNumber()
+			rn, _ := rv.ToNumber()
+			return ln == rn
+		}
+	case *system.String:
+		if rv, ok := r.(*system.String); ok {
+			ls, _ := lv.ToString()
+			rs, _ := rv.ToString()
+			return ls == rs
+		}
+	case *system.Function:
+		if rv, ok := r.(*system.Function); ok {
+			return lv.Offset() == rv.Offset() && lv.Env() == rv.Env()
+		}
+	case *system.List:
+		if rv, ok := r.(*system.List); ok && len(lv.Entries()) == len(rv.Entries()) {
+			for i, entry := range lv.Entries() {
+				if !Equal(entry, rv.Entries()[i]) {
+					return false
+				}
+			}
+			return true
+		}
+	case *system.Map:
+		if rv, ok := r.(*system.Map); ok && lv.Len() == rv.Len() {
+			for _, key := range lv.Keys() {
+				entry, ok := rv.Get(key)
+				if !ok {
+					return false
+				}
+				if value, _ := lv.Get(key); !Equal(value, entry) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func fromBool(b bool) *system.Number {
+	if b {
+		return system.NewNumber(1)
+	}
+	return system.NewNumber(0)
+}
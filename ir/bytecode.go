@@ -0,0 +1,528 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/system"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+)
+
+/* A linked program, as stored in a bytecode file */
+type Program struct {
+	Instructions []Instruction
+	Constants    *ConstantPool
+	Functions    []Function
+	Debug        bool //Whether function names are kept
+	Registers    int  //Size of the register file, REGISTERS if zero
+}
+
+/* An entry of the function table: the linker id of a named function and
+ * the offset of its body */
+type Function struct {
+	Id     int
+	Offset int
+	Name   string //Debug info, empty when stripped
+}
+
+/* The file layout, all counts and operands being varints:
+ *
+ *	magic "PRST", version uint16, flags uint16, register file size
+ *	constant pool: count, then per constant a tag and its value
+ *	function table: count, then per function its id, offset and, with
+ *	                debug info, its name
+ *	instructions: count, then per instruction its opcode and operands
+ *	CRC-32 (IEEE) of everything before it, uint32
+ *
+ * Integers are big endian. */
+const (
+	BYTECODE_MAGIC   = "PRST"
+	BYTECODE_VERSION = 2
+
+	FLAG_DEBUG = 1 << 0
+)
+
+const (
+	constantNumber byte = iota
+	constantString
+)
+
+const (
+	accessStack byte = iota
+	accessMemory
+	accessRegister
+	accessConstant
+	accessEnv
+)
+
+/*=================================================================================*/
+
+type encoder struct {
+	buffer    bytes.Buffer
+	constants *ConstantPool
+	e         err.Error
+}
+
+func (c *encoder) uvarint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	c.buffer.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (c *encoder) varint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	c.buffer.Write(b[:binary.PutVarint(b[:], int64(n))])
+}
+
+func (c *encoder) str(s string) {
+	c.uvarint(len(s))
+	c.buffer.WriteString(s)
+}
+
+/* Index of a constant in the written pool, which starts as the
+ * program's own so indexes are kept */
+func (c *encoder) constant(entry system.StackEntry) int {
+	index, ok := c.constants.Intern(entry)
+	if !ok {
+		c.fail("Only numbers and strings can be constants")
+	}
+	return index
+}
+
+func (c *encoder) fail(msg string) {
+	if c.e == nil {
+		c.e = err.NewLoadError(msg)
+	}
+}
+
+func (c *encoder) access(a Accessor) {
+	switch v := a.(type) {
+	case *StackAccess:
+		c.buffer.WriteByte(accessStack)
+		c.varint(v.offset)
+	case *MemoryAccess:
+		c.buffer.WriteByte(accessMemory)
+		c.uvarint(v.addr)
+	case *RegisterAccess:
+		c.buffer.WriteByte(accessRegister)
+		c.uvarint(v.id)
+	case *ConstantAccess:
+		c.buffer.WriteByte(accessConstant)
+		c.uvarint(c.constant(v.Entry()))
+	case *EnvAccess:
+		c.buffer.WriteByte(accessEnv)
+		c.uvarint(v.index)
+	default:
+		c.fail("Unknown accessor")
+	}
+}
+
+func (c *encoder) params(p system.Params) {
+	c.uvarint(p.Required)
+	c.uvarint(p.Optional)
+	if p.Rest {
+		c.buffer.WriteByte(1)
+	} else {
+		c.buffer.WriteByte(0)
+	}
+}
+
+func (c *encoder) instruction(instr Instruction) {
+	switch i := instr.(type) {
+	case *Add:
+		c.buffer.WriteByte(byte(ADD))
+		c.access(i.l)
+		c.access(i.r)
+	case *BinOp:
+		c.buffer.WriteByte(byte(i.op))
+		c.access(i.l)
+		c.access(i.r)
+	case *Push:
+		c.buffer.WriteByte(byte(PUSH))
+		c.access(i.v)
+	case *Release:
+		c.buffer.WriteByte(byte(RELEASE))
+		c.access(i.v)
+	case *Mov:
+		c.buffer.WriteByte(byte(MOV))
+		c.access(i.l)
+		c.access(i.r)
+	case *Call:
+		c.buffer.WriteByte(byte(CALL))
+		c.uvarint(i.location.GetLocation())
+		c.params(i.params)
+		c.uvarint(i.argc)
+	case *CallIndirect:
+		c.buffer.WriteByte(byte(CALL_INDIRECT))
+		c.access(i.fn)
+		c.uvarint(i.argc)
+	case *Closure:
+		c.buffer.WriteByte(byte(CLOSURE))
+		c.access(i.to)
+		c.uvarint(i.location.GetLocation())
+		c.params(i.params)
+		c.uvarint(len(i.captures))
+		for _, capture := range i.captures {
+			c.access(capture)
+		}
+	case *Result:
+		c.buffer.WriteByte(byte(RESULT))
+		c.access(i.from)
+	case *Exit:
+		c.buffer.WriteByte(byte(EXIT))
+		c.access(i.from)
+	case *Goto:
+		c.buffer.WriteByte(byte(GOTO))
+		c.uvarint(i.location.GetLocation())
+	case *JumpUnless:
+		c.buffer.WriteByte(byte(JUMP_UNLESS))
+		c.access(i.cond)
+		c.uvarint(i.location.GetLocation())
+	case *JumpDefined:
+		c.buffer.WriteByte(byte(JUMP_DEFINED))
+		c.access(i.slot)
+		c.uvarint(i.location.GetLocation())
+	case *Shrink:
+		c.buffer.WriteByte(byte(SHRINK))
+		c.varint(i.offset)
+	case *TailCall:
+		c.buffer.WriteByte(byte(TAIL_CALL))
+		c.uvarint(i.location.GetLocation())
+		c.params(i.params)
+		c.uvarint(i.argc)
+		c.uvarint(i.frameArgs)
+	case *TailCallIndirect:
+		c.buffer.WriteByte(byte(TAIL_CALL_INDIRECT))
+		c.access(i.fn)
+		c.uvarint(i.argc)
+		c.uvarint(i.frameArgs)
+	case *HostCall:
+		c.buffer.WriteByte(byte(HOST_CALL))
+		c.str(i.name)
+		c.uvarint(i.argc)
+	case *Guard:
+		c.buffer.WriteByte(byte(GUARD))
+		c.access(i.v)
+		c.str(i.annotation)
+		c.str(i.what)
+	default:
+		c.fail("Instruction has no bytecode encoding")
+	}
+}
+
+/* Write a program in the bytecode format */
+func Encode(w io.Writer, p *Program) err.Error {
+	c := &encoder{constants: NewConstantPool()}
+	if p.Constants != nil {
+		for _, entry := range p.Constants.Entries() {
+			c.constant(entry)
+		}
+	}
+
+	//Instructions are encoded first to collect the constant pool
+	c.uvarint(len(p.Instructions))
+	for _, instr := range p.Instructions {
+		c.instruction(instr)
+	}
+	if c.e != nil {
+		return c.e
+	}
+	instructions := append([]byte{}, c.buffer.Bytes()...)
+	c.buffer.Reset()
+
+	var flags uint16
+	if p.Debug {
+		flags |= FLAG_DEBUG
+	}
+	c.buffer.WriteString(BYTECODE_MAGIC)
+	binary.Write(&c.buffer, binary.BigEndian, uint16(BYTECODE_VERSION))
+	binary.Write(&c.buffer, binary.BigEndian, flags)
+	c.uvarint(p.RegisterCount())
+
+	c.uvarint(c.constants.Len())
+	for _, constant := range c.constants.Entries() {
+		if n, ok := constant.(*system.Number); ok {
+			v, _ := n.ToNumber()
+			c.buffer.WriteByte(constantNumber)
+			binary.Write(&c.buffer, binary.BigEndian, math.Float64bits(v))
+		} else {
+			s, _ := constant.ToString()
+			c.buffer.WriteByte(constantString)
+			c.str(s)
+		}
+	}
+
+	functions := append([]Function{}, p.Functions...)
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Id < functions[j].Id })
+	c.uvarint(len(functions))
+	for _, f := range functions {
+		c.uvarint(f.Id)
+		c.uvarint(f.Offset)
+		if p.Debug {
+			c.str(f.Name)
+		}
+	}
+
+	c.buffer.Write(instructions)
+	binary.Write(&c.buffer, binary.BigEndian, crc32.ChecksumIEEE(c.buffer.Bytes()))
+
+	if _, e := w.Write(c.buffer.Bytes()); e != nil {
+		return err.NewLoadError("Writing bytecode: " + e.Error())
+	}
+	return nil
+}
+
+/*=================================================================================*/
+
+type decoder struct {
+	r         *bytes.Reader
+	constants *ConstantPool
+	e         err.Error
+}
+
+func (d *decoder) fail(msg string) {
+	if d.e == nil {
+		d.e = err.NewLoadError(msg)
+	}
+}
+
+func (d *decoder) byte() byte {
+	b, e := d.r.ReadByte()
+	if e != nil {
+		d.fail("Bytecode is truncated")
+	}
+	return b
+}
+
+func (d *decoder) uvarint() int {
+	n, e := binary.ReadUvarint(d.r)
+	if e != nil {
+		d.fail("Bytecode is truncated")
+	}
+	return int(n)
+}
+
+func (d *decoder) varint() int {
+	n, e := binary.ReadVarint(d.r)
+	if e != nil {
+		d.fail("Bytecode is truncated")
+	}
+	return int(n)
+}
+
+/* A count of items each taking at least a byte, checked against what is
+ * left so corrupt counts cannot exhaust memory */
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > d.r.Len() {
+		d.fail("Bytecode count exceeds its data")
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) str() string {
+	b := make([]byte, d.count())
+	if _, e := io.ReadFull(d.r, b); e != nil {
+		d.fail("Bytecode is truncated")
+	}
+	return string(b)
+}
+
+func (d *decoder) location() *InstructionLocation {
+	return NewInstructionLocation(d.uvarint())
+}
+
+func (d *decoder) access() Accessor {
+	switch d.byte() {
+	case accessStack:
+		return NewStackAccess(d.varint())
+	case accessMemory:
+		return NewMemoryAccess(d.uvarint())
+	case accessRegister:
+		return NewRegisterAccess(d.uvarint())
+	case accessConstant:
+		if index := d.uvarint(); index < d.constants.Len() {
+			return NewConstantAccess(d.constants, index)
+		}
+		d.fail("Constant index out of range")
+	case accessEnv:
+		return NewEnvAccess(d.uvarint())
+	default:
+		d.fail("Unknown accessor")
+	}
+	return NewRegisterAccess(0)
+}
+
+func (d *decoder) params() system.Params {
+	return system.Params{Required: d.uvarint(), Optional: d.uvarint(), Rest: d.byte() == 1}
+}
+
+func (d *decoder) instruction() Instruction {
+	switch op := InstructionType(d.byte()); op {
+	case ADD:
+		return NewAdd(d.access(), d.access())
+	case SUB, MULT, DIV, MOD, LT, LTE, GT, GTE, EQ, NEQ, AND, OR:
+		return NewBinOp(op, d.access(), d.access())
+	case PUSH:
+		return NewPush(d.access())
+	case RELEASE:
+		if m, ok := d.access().(*MemoryAccess); ok {
+			return NewRelease(m)
+		}
+		d.fail("Release requires a memory address")
+	case MOV:
+		return NewMov(d.access(), d.access())
+	case CALL:
+		return NewCall(d.location(), d.params(), d.uvarint())
+	case CALL_INDIRECT:
+		return NewCallIndirect(d.access(), d.uvarint())
+	case CLOSURE:
+		to, location, params := d.access(), d.location(), d.params()
+		captures := make([]Accessor, d.count())
+		for i := range captures {
+			captures[i] = d.access()
+		}
+		return NewClosure(to, location, params, captures...)
+	case RESULT:
+		return NewResult(d.access())
+	case EXIT:
+		return NewExit(d.access())
+	case GOTO:
+		return NewGoto(d.location())
+	case JUMP_UNLESS:
+		return NewJumpUnless(d.access(), d.location())
+	case JUMP_DEFINED:
+		return NewJumpDefined(d.access(), d.location())
+	case SHRINK:
+		return NewShrink(d.varint())
+	case TAIL_CALL:
+		return NewTailCall(d.location(), d.params(), d.uvarint(), d.uvarint())
+	case TAIL_CALL_INDIRECT:
+		return NewTailCallIndirect(d.access(), d.uvarint(), d.uvarint())
+	case GUARD:
+		return NewGuard(d.access(), d.str(), d.str())
+	case HOST_CALL:
+		return NewHostCall(d.str(), d.uvarint())
+	default:
+		d.fail("Unknown opcode 0x" + strconv.FormatInt(int64(op), 16))
+	}
+	return nil
+}
+
+/* Read a program in the bytecode format, verifying its checksum and
+ * validating its instructions */
+func Decode(r io.Reader) (*Program, err.Error) {
+	data, e := ioutil.ReadAll(r)
+	if e != nil {
+		return nil, err.NewLoadError("Reading bytecode: " + e.Error())
+	} else if len(data) < len(BYTECODE_MAGIC)+8 || string(data[:len(BYTECODE_MAGIC)]) != BYTECODE_MAGIC {
+		return nil, err.NewLoadError("Not a presta bytecode file")
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, err.NewLoadError("Bytecode checksum mismatch")
+	}
+
+	d := &decoder{r: bytes.NewReader(body[len(BYTECODE_MAGIC):])}
+	var version, flags uint16
+	binary.Read(d.r, binary.BigEndian, &version)
+	binary.Read(d.r, binary.BigEndian, &flags)
+	if version != BYTECODE_VERSION {
+		return nil, err.NewLoadError("Unsupported bytecode version " + strconv.Itoa(int(version)))
+	}
+	p := &Program{Debug: flags&FLAG_DEBUG != 0, Registers: d.uvarint()}
+
+	d.constants = NewConstantPool()
+	for i, n := 0, d.count(); i < n && d.e == nil; i++ {
+		var entry system.StackEntry
+		switch d.byte() {
+		case constantNumber:
+			var bits uint64
+			if binary.Read(d.r, binary.BigEndian, &bits) != nil {
+				d.fail("Bytecode is truncated")
+			}
+			entry = system.NewNumber(math.Float64frombits(bits))
+		case constantString:
+			entry = system.NewString(d.str())
+		default:
+			d.fail("Unknown constant type")
+			continue
+		}
+		//Indexes are positions in the pool, so each constant is distinct
+		if index, _ := d.constants.Intern(entry); index != i {
+			d.fail("Duplicate constant in pool")
+		}
+	}
+	p.Constants = d.constants
+
+	p.Functions = make([]Function, d.count())
+	for i := range p.Functions {
+		p.Functions[i] = Function{Id: d.uvarint(), Offset: d.uvarint()}
+		if p.Debug {
+			p.Functions[i].Name = d.str()
+		}
+	}
+
+	p.Instructions = make([]Instruction, d.count())
+	for i := range p.Instructions {
+		if d.e != nil {
+			break
+		}
+		p.Instructions[i] = d.instruction()
+	}
+
+	if d.e != nil {
+		return nil, d.e
+	} else if d.r.Len() != 0 {
+		return nil, err.NewLoadError("Trailing data after bytecode instructions")
+	}
+	for _, f := range p.Functions {
+		if f.Offset < 0 || f.Offset >= len(p.Instructions) {
+			return nil, err.NewLoadError("Function offset outside of the program")
+		}
+	}
+	if e := Validate(p.Instructions); e != nil {
+		return nil, e
+	} else if Registers(p.Instructions) > p.RegisterCount() {
+		return nil, err.NewLoadError("Program uses registers outside its register file of " + strconv.Itoa(p.RegisterCount()))
+	}
+	return p, nil
+}
+
+/* The size of the register file the program runs with */
+func (p *Program) RegisterCount() int {
+	if p.Registers > 0 {
+		return p.Registers
+	}
+	return REGISTERS
+}
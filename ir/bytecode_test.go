@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/vm"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+/* Compile a program and store it as bytecode */
+func encode(t *testing.T, src string) []byte {
+	program, e := presta.CompileProgram(strings.NewReader(src), presta.Options{})
+	if e != nil {
+		t.Fatalf("%s: %s", src, e.Message())
+	}
+	var b bytes.Buffer
+	if e := ir.Encode(&b, program); e != nil {
+		t.Fatalf("%s: %s", src, e.Message())
+	}
+	return b.Bytes()
+}
+
+func TestDecodeRejectsInvalidInstructions(t *testing.T) {
+	exit := ir.NewExit(ir.NewRegisterAccess(0))
+	tests := []struct {
+		name   string
+		instrs []ir.Instruction
+		msg    string
+	}{
+		{"goto", []ir.Instruction{ir.NewGoto(ir.NewInstructionLocation(0x99)), exit}, "location outside of the program"},
+		{"jmpf", []ir.Instruction{ir.NewJumpUnless(ir.NewRegisterAccess(0), ir.NewInstructionLocation(2)), exit}, "location outside of the program"},
+		{"call", []ir.Instruction{ir.NewCall(ir.NewInstructionLocation(5), system.Params{}, 0), exit}, "location outside of the program"},
+		{"closure", []ir.Instruction{ir.NewClosure(ir.NewRegisterAccess(0), ir.NewInstructionLocation(7), system.Params{}), exit}, "location outside of the program"},
+		{"tcall", []ir.Instruction{ir.NewTailCall(ir.NewInstructionLocation(3), system.Params{}, 0, 0), exit}, "location outside of the program"},
+		{"shrink", []ir.Instruction{ir.NewShrink(-3), exit}, "Shrink offset is negative"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if e := ir.Encode(&b, &ir.Program{Instructions: test.instrs}); e != nil {
+			t.Fatalf("%s: encode: %s", test.name, e.Message())
+		}
+		_, e := ir.Decode(&b)
+		if e == nil {
+			t.Errorf("%s: decoded without error", test.name)
+		} else if e.Code() != err.LOAD_ERROR || !strings.Contains(e.Message(), test.msg) {
+			t.Errorf("%s: got %q, want a load error containing %q", test.name, e.Message(), test.msg)
+		}
+	}
+}
+
+func TestDecodeRejectsFunctionOutsideProgram(t *testing.T) {
+	var b bytes.Buffer
+	p := &ir.Program{
+		Instructions: []ir.Instruction{ir.NewExit(ir.NewRegisterAccess(0))},
+		Functions:    []ir.Function{{Id: 0, Offset: 4}},
+	}
+	if e := ir.Encode(&b, p); e != nil {
+		t.Fatal(e.Message())
+	}
+	if _, e := ir.Decode(&b); e == nil {
+		t.Error("decoded a function table entry past the end of the program")
+	}
+}
+
+func TestDecodeAcceptsValidProgram(t *testing.T) {
+	var b bytes.Buffer
+	p := &ir.Program{Instructions: []ir.Instruction{
+		ir.NewGoto(ir.NewInstructionLocation(1)),
+		ir.NewShrink(0),
+		ir.NewExit(ir.NewRegisterAccess(0)),
+	}}
+	if e := ir.Encode(&b, p); e != nil {
+		t.Fatal(e.Message())
+	}
+	decoded, e := ir.Decode(&b)
+	if e != nil {
+		t.Fatal(e.Message())
+	} else if len(decoded.Instructions) != 3 {
+		t.Errorf("decoded %d instructions, want 3", len(decoded.Instructions))
+	}
+}
+
+func TestDecodeRejectsRegistersOutsideRegisterFile(t *testing.T) {
+	var b bytes.Buffer
+	p := &ir.Program{Instructions: []ir.Instruction{ir.NewExit(ir.NewRegisterAccess(9))}}
+	if e := ir.Encode(&b, p); e != nil {
+		t.Fatal(e.Message())
+	}
+	if _, e := ir.Decode(&b); e == nil || e.Code() != err.LOAD_ERROR {
+		t.Errorf("decoded %%9 into a register file of %d, got %v", ir.REGISTERS, e)
+	}
+
+	b.Reset()
+	p.Registers = 10
+	if e := ir.Encode(&b, p); e != nil {
+		t.Fatal(e.Message())
+	}
+	if decoded, e := ir.Decode(&b); e != nil {
+		t.Fatal(e.Message())
+	} else if decoded.Registers != 10 {
+		t.Errorf("decoded a register file of %d, want 10", decoded.Registers)
+	}
+}
+
+/* Compiled programs run the same after being stored as bytecode */
+func TestDecodedProgramsRun(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"+ 1 2", "3"},
+		{"'presta'", "presta"},
+		{"~fib(n)(|(< n 2 n 1 + fib{- n 1} fib{- n 2})) fib{10}", "55"},
+		{"~add(a)(~(b)(+ a b)) :(f)(add{3}) f{4}", "7"},
+	}
+	for _, test := range tests {
+		p, e := ir.Decode(bytes.NewReader(encode(t, test.src)))
+		if e != nil {
+			t.Errorf("%s: %s", test.src, e.Message())
+			continue
+		}
+		for _, run := range []func(*vm.VM) err.Error{(*vm.VM).Run, (*vm.VM).Interpret} {
+			v := vm.NewVMForProgram(p)
+			if e := run(v); e != nil {
+				t.Errorf("%s: %s", test.src, e.Message())
+			} else if got, _ := v.Result().ToString(); got != test.want {
+				t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+			}
+		}
+	}
+}
+
+func TestDecodeRejectsChecksumMismatch(t *testing.T) {
+	code := encode(t, "+ 1 2")
+	code[len(code)/2] ^= 0xff
+	_, e := ir.Decode(bytes.NewReader(code))
+	if e == nil || e.Code() != err.LOAD_ERROR || e.Message() != "Bytecode checksum mismatch" {
+		t.Errorf("decoded corrupted bytecode, got %v", e)
+	}
+}
+
+func TestDecodeRejectsOtherVersions(t *testing.T) {
+	code := encode(t, "+ 1 2")
+	body := code[:len(code)-4]
+	binary.BigEndian.PutUint16(body[4:], ir.BYTECODE_VERSION+1)
+	binary.BigEndian.PutUint32(code[len(body):], crc32.ChecksumIEEE(body))
+	_, e := ir.Decode(bytes.NewReader(code))
+	if e == nil || e.Code() != err.LOAD_ERROR || !strings.HasPrefix(e.Message(), "Unsupported bytecode version") {
+		t.Errorf("decoded bytecode of another version, got %v", e)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+import (
+	"github.com/rkophs/presta/system"
+	"strconv"
+)
+
+/* The constants of a program. Numbers and strings are interned so each
+ * distinct value is stored once and instructions refer to it by index */
+type ConstantPool struct {
+	entries []system.StackEntry
+	index   map[string]int
+}
+
+func NewConstantPool() *ConstantPool {
+	return &ConstantPool{entries: []system.StackEntry{}, index: make(map[string]int)}
+}
+
+/* Distinguishes numbers from strings with the same text */
+func constantKey(entry system.StackEntry) (string, bool) {
+	switch v := entry.(type) {
+	case *system.Number:
+		n, _ := v.ToNumber()
+		return "n" + strconv.FormatFloat(n, 'g', -1, 64), true
+	case *system.String:
+		s, _ := v.ToString()
+		return "s" + s, true
+	}
+	return "", false
+}
+
+/* Index of a constant in the pool, adding it if it is new. Only numbers
+ * and strings can be constants */
+func (p *ConstantPool) Intern(entry system.StackEntry) (int, bool) {
+	key, ok := constantKey(entry)
+	if !ok {
+		return 0, false
+	}
+	if index, ok := p.index[key]; ok {
+		return index, true
+	}
+	p.index[key] = len(p.entries)
+	p.entries = append(p.entries, entry)
+	return len(p.entries) - 1, true
+}
+
+/* An accessor for a constant, interning it first */
+func (p *ConstantPool) Access(entry system.StackEntry) *ConstantAccess {
+	index, _ := p.Intern(entry)
+	return NewConstantAccess(p, index)
+}
+
+func (p *ConstantPool) Get(index int) system.StackEntry {
+	return p.entries[index]
+}
+
+func (p *ConstantPool) Len() int {
+	return len(p.entries)
+}
+
+func (p *ConstantPool) Entries() []system.StackEntry {
+	return p.entries
+}
+
+/* Constants are written as literals, numbers in decimal and strings
+ * quoted, which the assembler reads back */
+func literal(entry system.StackEntry) string {
+	switch v := entry.(type) {
+	case *system.Number:
+		n, _ := v.ToNumber()
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	case *system.String:
+		s, _ := v.ToString()
+		return strconv.Quote(s)
+	}
+	hex, _ := entry.ToHex()
+	return hex
+}
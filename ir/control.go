@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+import (
+	"bytes"
+	"github.com/rkophs/presta/system"
+	"strconv"
+)
+
+type Goto struct {
+	location *InstructionLocation
+}
+
+func NewGoto(location *InstructionLocation) *Goto {
+	return &Goto{location: location}
+}
+
+func (g *Goto) Execute(s system.System) {
+	s.Goto(g.location.GetLocation())
+}
+
+func (g *Goto) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("goto\t")
+	g.location.Serialize(buffer)
+	buffer.WriteRune('\n')
+}
+
+type JumpUnless struct {
+	cond     Accessor
+	location *InstructionLocation
+}
+
+func NewJumpUnless(cond Accessor, location *InstructionLocation) *JumpUnless {
+	return &JumpUnless{cond: cond, location: location}
+}
+
+func (j *JumpUnless) Execute(s system.System) {
+	if !Truthy(j.cond.ToValue(s)) {
+		s.Goto(j.location.GetLocation())
+	}
+}
+
+func (j *JumpUnless) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("jmpf\t")
+	j.cond.Serialize(buffer)
+	buffer.WriteRune(',')
+	j.location.Serialize(buffer)
+	buffer.WriteRune('\n')
+}
+
+/* Discard every stack entry at or above BP+offset */
+type Shrink struct {
+	offset int
+}
+
+func NewShrink(offset int) *Shrink {
+	return &Shrink{offset: offset}
+}
+
+func (r *Shrink) Execute(s system.System) {
+	s.Shrink(r.offset)
+}
+
+func (r *Shrink) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("shrink\t0x")
+	buffer.WriteString(strconv.FormatInt(int64(r.offset), 16))
+	buffer.WriteRune('\n')
+}
+
+/* Jump when an optional param slot holds a value passed by the caller */
+type JumpDefined struct {
+	slot     Accessor
+	location *InstructionLocation
+}
+
+func NewJumpDefined(slot Accessor, location *InstructionLocation) *JumpDefined {
+	return &JumpDefined{slot: slot, location: location}
+}
+
+func (j *JumpDefined) Execute(s system.System) {
+	if _, undefined := j.slot.ToValue(s).(*system.Undefined); !undefined {
+		s.Goto(j.location.GetLocation())
+	}
+}
+
+func (j *JumpDefined) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("jmpd\t")
+	j.slot.Serialize(buffer)
+	buffer.WriteRune(',')
+	j.location.Serialize(buffer)
+	buffer.WriteRune('\n')
+}
+
+/* Call a function in tail position. The arguments on top of the stack
+ * replace the frameArgs arguments of the running frame, which is then
+ * reused by the callee, so no return address or frame is retained. */
+type TailCall struct {
+	location  *InstructionLocation
+	params    system.Params
+	argc      int
+	frameArgs int
+}
+
+func NewTailCall(location *InstructionLocation, params system.Params, argc, frameArgs int) *TailCall {
+	return &TailCall{location: location, params: params, argc: argc, frameArgs: frameArgs}
+}
+
+func (t *TailCall) Execute(s system.System) {
+	s.TailCall(system.NewFunction(t.location.GetLocation(), t.params, -1), t.argc, t.frameArgs)
+}
+
+func (t *TailCall) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("tcall\t")
+	t.location.Serialize(buffer)
+	buffer.WriteRune(',')
+	serializeParams(buffer, t.params)
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(t.argc), 16))
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(t.frameArgs), 16))
+	buffer.WriteRune('\n')
+}
+
+type TailCallIndirect struct {
+	fn        Accessor
+	argc      int
+	frameArgs int
+}
+
+func NewTailCallIndirect(fn Accessor, argc, frameArgs int) *TailCallIndirect {
+	return &TailCallIndirect{fn: fn, argc: argc, frameArgs: frameArgs}
+}
+
+func (t *TailCallIndirect) Execute(s system.System) {
+	if fn, ok := t.fn.ToValue(s).(*system.Function); !ok {
+		s.SetError("Value is not a function")
+	} else {
+		s.TailCall(fn, t.argc, t.frameArgs)
+	}
+}
+
+func (t *TailCallIndirect) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("tcallr\t")
+	t.fn.Serialize(buffer)
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(t.argc), 16))
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(t.frameArgs), 16))
+	buffer.WriteRune('\n')
+}
+
+/* Check a value against a type annotation, failing with a description of
+ * what was annotated */
+type Guard struct {
+	v          Accessor
+	annotation string
+	what       string
+}
+
+func NewGuard(v Accessor, annotation string, what string) *Guard {
+	return &Guard{v: v, annotation: annotation, what: what}
+}
+
+func (g *Guard) Execute(s system.System) {
+	if got := system.TypeName(g.v.ToValue(s)); g.annotation != "any" && got != g.annotation {
+		s.SetError(g.what + " expects " + g.annotation + ", got " + got)
+	}
+}
+
+func (g *Guard) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("guard\t")
+	g.v.Serialize(buffer)
+	buffer.WriteRune(',')
+	buffer.WriteString(g.annotation)
+	buffer.WriteRune(',')
+	buffer.WriteString(strconv.Quote(g.what))
+	buffer.WriteRune('\n')
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/system"
+)
+
+/* Instructions lowered for the VM's dispatch loop. Each instruction is its
+ * opcode followed by its operands, all as words of Code:
+ *
+ *	add, sub ... or    A l, A r
+ *	push, ret, exit    A v
+ *	release            addr
+ *	mov                A to, A from
+ *	call               fn, argc
+ *	callr              A fn, argc
+ *	closure            A to, location, params, n, A capture * n
+ *	goto               location
+ *	jmpf, jmpd         A v, location
+ *	shrink             offset
+ *	tcall              fn, argc, frameArgs
+ *	tcallr             A fn, argc, frameArgs
+ *	guard              A v, annotation, what
+ *	hcall              name, argc
+ *
+ * An accessor A takes two words, one of the ACCESS kinds and its offset,
+ * address, register, constant or environment index. Locations are
+ * instruction offsets, which Offsets maps to positions in Code, so function
+ * values keep the offsets they have in the instructions. Direct calls
+ * refer to prebuilt function values and the other operands index the
+ * tables of the program, host functions being called by name. */
+type Flat struct {
+	Code      []int
+	Offsets   []int //Instruction offset -> position in Code
+	Constants []system.StackEntry
+	Functions []*system.Function
+	Params    []system.Params
+	Strings   []string
+}
+
+const (
+	ACCESS_STACK = iota
+	ACCESS_MEMORY
+	ACCESS_REGISTER
+	ACCESS_CONSTANT
+	ACCESS_ENV
+)
+
+type flattener struct {
+	flat      *Flat
+	constants *ConstantPool
+	strings   map[string]int
+	e         err.Error
+}
+
+func (f *flattener) fail(msg string) {
+	if f.e == nil {
+		f.e = err.NewLoadError(msg)
+	}
+}
+
+func (f *flattener) word(words ...int) {
+	f.flat.Code = append(f.flat.Code, words...)
+}
+
+func (f *flattener) access(a Accessor) {
+	switch v := a.(type) {
+	case *StackAccess:
+		f.word(ACCESS_STACK, v.offset)
+	case *MemoryAccess:
+		f.word(ACCESS_MEMORY, v.addr)
+	case *RegisterAccess:
+		f.word(ACCESS_REGISTER, v.id)
+	case *ConstantAccess:
+		index, ok := f.constants.Intern(v.Entry())
+		if !ok {
+			f.fail("Only numbers and strings can be constants")
+		}
+		f.word(ACCESS_CONSTANT, index)
+	case *EnvAccess:
+		f.word(ACCESS_ENV, v.index)
+	default:
+		f.fail("Unknown accessor")
+	}
+}
+
+func (f *flattener) function(location *InstructionLocation, params system.Params) int {
+	f.flat.Functions = append(f.flat.Functions, system.NewFunction(location.GetLocation(), params, -1))
+	return len(f.flat.Functions) - 1
+}
+
+func (f *flattener) params(params system.Params) int {
+	f.flat.Params = append(f.flat.Params, params)
+	return len(f.flat.Params) - 1
+}
+
+func (f *flattener) str(s string) int {
+	if index, ok := f.strings[s]; ok {
+		return index
+	}
+	f.strings[s] = len(f.flat.Strings)
+	f.flat.Strings = append(f.flat.Strings, s)
+	return len(f.flat.Strings) - 1
+}
+
+func (f *flattener) instruction(instr Instruction) {
+	switch i := instr.(type) {
+	case *Add:
+		f.word(int(ADD))
+		f.access(i.l)
+		f.access(i.r)
+	case *BinOp:
+		f.word(int(i.op))
+		f.access(i.l)
+		f.access(i.r)
+	case *Push:
+		f.word(int(PUSH))
+		f.access(i.v)
+	case *Release:
+		f.word(int(RELEASE), i.v.addr)
+	case *Mov:
+		f.word(int(MOV))
+		f.access(i.l)
+		f.access(i.r)
+	case *Call:
+		f.word(int(CALL), f.function(i.location, i.params), i.argc)
+	case *CallIndirect:
+		f.word(int(CALL_INDIRECT))
+		f.access(i.fn)
+		f.word(i.argc)
+	case *Closure:
+		f.word(int(CLOSURE))
+		f.access(i.to)
+		f.word(i.location.GetLocation(), f.params(i.params), len(i.captures))
+		for _, capture := range i.captures {
+			f.access(capture)
+		}
+	case *Result:
+		f.word(int(RESULT))
+		f.access(i.from)
+	case *Exit:
+		f.word(int(EXIT))
+		f.access(i.from)
+	case *Goto:
+		f.word(int(GOTO), i.location.GetLocation())
+	case *JumpUnless:
+		f.word(int(JUMP_UNLESS))
+		f.access(i.cond)
+		f.word(i.location.GetLocation())
+	case *JumpDefined:
+		f.word(int(JUMP_DEFINED))
+		f.access(i.slot)
+		f.word(i.location.GetLocation())
+	case *Shrink:
+		f.word(int(SHRINK), i.offset)
+	case *TailCall:
+		f.word(int(TAIL_CALL), f.function(i.location, i.params), i.argc, i.frameArgs)
+	case *TailCallIndirect:
+		f.word(int(TAIL_CALL_INDIRECT))
+		f.access(i.fn)
+		f.word(i.argc, i.frameArgs)
+	case *HostCall:
+		f.word(int(HOST_CALL), f.str(i.name), i.argc)
+	case *Guard:
+		f.word(int(GUARD))
+		f.access(i.v)
+		f.word(f.str(i.annotation), f.str(i.what))
+	default:
+		f.fail("Instruction has no flat encoding")
+	}
+}
+
+/* Lower instructions for the dispatch loop, validating them first */
+func Flatten(instrs []Instruction) (*Flat, err.Error) {
+	if e := Validate(instrs); e != nil {
+		return nil, e
+	}
+	f := &flattener{flat: &Flat{}, constants: NewConstantPool(), strings: make(map[string]int)}
+	for _, instr := range instrs {
+		f.flat.Offsets = append(f.flat.Offsets, len(f.flat.Code))
+		f.instruction(instr)
+	}
+	//Running off the end lands past the last instruction
+	f.flat.Offsets = append(f.flat.Offsets, len(f.flat.Code))
+	f.flat.Constants = f.constants.Entries()
+
+	if f.e != nil {
+		return nil, f.e
+	}
+	return f.flat, nil
+}
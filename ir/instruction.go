@@ -90,6 +90,29 @@ const (
 	MOV
 	CALL
 	RESULT
+	CALL_INDIRECT
+	CLOSURE
+	SUB
+	MULT
+	DIV
+	MOD
+	LT
+	LTE
+	GT
+	GTE
+	EQ
+	NEQ
+	AND
+	OR
+	GOTO
+	JUMP_UNLESS
+	SHRINK
+	TAIL_CALL
+	TAIL_CALL_INDIRECT
+	JUMP_DEFINED
+	GUARD
+	EXIT
+	HOST_CALL
 )
 
 type Add struct {
@@ -147,12 +170,16 @@ type Release struct {
 	v *MemoryAccess
 }
 
+func NewRelease(v *MemoryAccess) *Release {
+	return &Release{v: v}
+}
+
 func (r *Release) Execute(s system.System) {
 	r.v.Release(s)
 }
 
 func (r *Release) Serialize(buffer *bytes.Buffer) {
-	buffer.WriteString("push\t")
+	buffer.WriteString("release\t")
 	r.v.Serialize(buffer)
 	buffer.WriteRune('\n')
 }
@@ -178,21 +205,126 @@ func (m *Mov) Serialize(buffer *bytes.Buffer) {
 	buffer.WriteRune('\n')
 }
 
+/* Params are written as P(required,optional,rest) */
+func serializeParams(buffer *bytes.Buffer, params system.Params) {
+	rest := 0
+	if params.Rest {
+		rest = 1
+	}
+	buffer.WriteString("P(0x")
+	buffer.WriteString(strconv.FormatInt(int64(params.Required), 16))
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(params.Optional), 16))
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(rest), 16))
+	buffer.WriteRune(')')
+}
+
 type Call struct {
 	location *InstructionLocation
+	params   system.Params
+	argc     int
 }
 
-func NewCall(location *InstructionLocation) *Call {
-	return &Call{location: location}
+func NewCall(location *InstructionLocation, params system.Params, argc int) *Call {
+	return &Call{location: location, params: params, argc: argc}
 }
 
 func (c *Call) Execute(s system.System) {
-	s.Call(c.location.GetLocation())
+	s.Call(system.NewFunction(c.location.GetLocation(), c.params, -1), c.argc)
 }
 
 func (c *Call) Serialize(buffer *bytes.Buffer) {
 	buffer.WriteString("call\t")
 	c.location.Serialize(buffer)
+	buffer.WriteRune(',')
+	serializeParams(buffer, c.params)
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(c.argc), 16))
+	buffer.WriteRune('\n')
+}
+
+/* Call a function of the host with the argc arguments on top of the
+ * stack. Like any call it leaves the result in AX and the arguments for
+ * the caller to clean up. */
+type HostCall struct {
+	name string
+	argc int
+}
+
+func NewHostCall(name string, argc int) *HostCall {
+	return &HostCall{name: name, argc: argc}
+}
+
+func (h *HostCall) Execute(s system.System) {
+	s.CallHost(h.name, h.argc)
+}
+
+func (h *HostCall) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("hcall\t")
+	buffer.WriteString(h.name)
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(h.argc), 16))
+	buffer.WriteRune('\n')
+}
+
+type CallIndirect struct {
+	fn   Accessor
+	argc int
+}
+
+func NewCallIndirect(fn Accessor, argc int) *CallIndirect {
+	return &CallIndirect{fn: fn, argc: argc}
+}
+
+func (c *CallIndirect) Execute(s system.System) {
+	if fn, ok := c.fn.ToValue(s).(*system.Function); !ok {
+		s.SetError("Value is not a function")
+	} else {
+		s.Call(fn, c.argc)
+	}
+}
+
+func (c *CallIndirect) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("callr\t")
+	c.fn.Serialize(buffer)
+	buffer.WriteString(",0x")
+	buffer.WriteString(strconv.FormatInt(int64(c.argc), 16))
+	buffer.WriteRune('\n')
+}
+
+/* Copy the captured values into a fresh heap environment and store a
+ * function value referencing it */
+type Closure struct {
+	to       Accessor
+	location *InstructionLocation
+	params   system.Params
+	captures []Accessor
+}
+
+func NewClosure(to Accessor, location *InstructionLocation, params system.Params, captures ...Accessor) *Closure {
+	return &Closure{to: to, location: location, params: params, captures: captures}
+}
+
+func (c *Closure) Execute(s system.System) {
+	env := s.Alloc(len(c.captures))
+	for i, capture := range c.captures {
+		s.SetM(env+i, capture.ToValue(s))
+	}
+	c.to.Assign(s, system.NewFunction(c.location.GetLocation(), c.params, env))
+}
+
+func (c *Closure) Serialize(buffer *bytes.Buffer) {
+	buffer.WriteString("closure\t")
+	c.to.Serialize(buffer)
+	buffer.WriteRune(',')
+	c.location.Serialize(buffer)
+	buffer.WriteRune(',')
+	serializeParams(buffer, c.params)
+	for _, capture := range c.captures {
+		buffer.WriteRune(',')
+		capture.Serialize(buffer)
+	}
 	buffer.WriteRune('\n')
 }
 
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+/* The number of registers in the register file unless configured
+ * otherwise. Register 0 is the accumulator every expression leaves its
+ * value in; the rest hold temporaries. */
+const REGISTERS = 8
+
+func isAccumulator(a Accessor) bool {
+	r, ok := a.(*RegisterAccess)
+	return ok && r.id == 0
+}
+
+func sameAccess(a, b Accessor) bool {
+	switch l := a.(type) {
+	case *RegisterAccess:
+		r, ok := b.(*RegisterAccess)
+		return ok && l.id == r.id
+	case *StackAccess:
+		r, ok := b.(*StackAccess)
+		return ok && l.offset == r.offset
+	case *EnvAccess:
+		r, ok := b.(*EnvAccess)
+		return ok && l.index == r.index
+	}
+	return false
+}
+
+/* Whether an instruction replaces the accumulator without reading it.
+ * Called functions always set it before reading it. */
+func overwritesAccumulator(instr Instruction) bool {
+	switch i := instr.(type) {
+	case *Mov:
+		return isAccumulator(i.l) && !isAccumulator(i.r)
+	case *Call, *TailCall, *HostCall:
+		return true
+	case *CallIndirect:
+		return !isAccumulator(i.fn)
+	case *TailCallIndirect:
+		return !isAccumulator(i.fn)
+	}
+	return false
+}
+
+func targeted(labels []*InstructionLocation, at int) bool {
+	for _, label := range labels {
+		if label.GetLocation() == at {
+			return true
+		}
+	}
+	return false
+}
+
+/* Rewrite redundant sequences of a block of instructions. Labels are the
+ * jump targets within the block: a sequence is only rewritten when none
+ * of them points past its first instruction, and they are moved to
+ * account for the instructions removed. */
+func Peephole(instrs []Instruction, labels []*InstructionLocation) []Instruction {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(instrs); i++ {
+			count, replacement := rewrite(instrs, i, labels)
+			if count == 0 {
+				continue
+			}
+
+			removed := count - len(replacement)
+			for _, label := range labels {
+				if label.GetLocation() >= i+count {
+					label.SetLocation(label.GetLocation() - removed)
+				}
+			}
+			instrs = append(instrs[:i], append(replacement, instrs[i+count:]...)...)
+			changed = true
+		}
+	}
+	return instrs
+}
+
+/* Match a pattern starting at i, returning how many instructions it
+ * covers and what to replace them with */
+func rewrite(instrs []Instruction, i int, labels []*InstructionLocation) (int, []Instruction) {
+
+	//A move onto itself does nothing
+	if mov, ok := instrs[i].(*Mov); ok && sameAccess(mov.l, mov.r) {
+		return 1, []Instruction{}
+	}
+
+	if i+1 >= len(instrs) || targeted(labels, i+1) {
+		return 0, nil
+	}
+	first, second := instrs[i], instrs[i+1]
+	dead := i+2 < len(instrs) && overwritesAccumulator(instrs[i+2])
+
+	switch a := first.(type) {
+	case *Mov:
+		if !isAccumulator(a.l) {
+			break
+		}
+		switch b := second.(type) {
+		case *Push:
+			//Push the source rather than moving it through the accumulator
+			if isAccumulator(b.v) && dead {
+				return 2, []Instruction{NewPush(a.r)}
+			}
+		case *Mov:
+			if isAccumulator(b.r) && !isAccumulator(b.l) && dead {
+				return 2, []Instruction{NewMov(b.l, a.r)}
+			} else if overwritesAccumulator(b) {
+				//The first value is never read
+				return 2, []Instruction{b}
+			}
+		}
+	case *Shrink:
+		switch b := second.(type) {
+		case *Shrink:
+			if b.offset < a.offset {
+				return 2, []Instruction{b}
+			}
+			return 2, []Instruction{a}
+		case *Result:
+			//The frame is discarded on return
+			if _, ok := b.from.(*RegisterAccess); ok {
+				return 2, []Instruction{b}
+			}
+		case *Exit:
+			if _, ok := b.from.(*RegisterAccess); ok {
+				return 2, []Instruction{b}
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir_test
+
+import (
+	"bytes"
+	"github.com/rkophs/presta/ir"
+	"testing"
+)
+
+func serialize(instrs []ir.Instruction) string {
+	var b bytes.Buffer
+	for _, instr := range instrs {
+		instr.Serialize(&b)
+	}
+	return b.String()
+}
+
+func TestPeephole(t *testing.T) {
+	acc, tmp, slot := ir.NewRegisterAccess(0), ir.NewRegisterAccess(1), ir.NewStackAccess(-1)
+	exit := ir.NewExit(acc)
+	tests := []struct {
+		name   string
+		instrs []ir.Instruction
+		want   []ir.Instruction
+	}{
+		{"self move",
+			[]ir.Instruction{ir.NewMov(tmp, tmp), exit},
+			[]ir.Instruction{exit}},
+		{"push through the accumulator",
+			[]ir.Instruction{ir.NewMov(acc, tmp), ir.NewPush(acc), ir.NewMov(acc, slot), exit},
+			[]ir.Instruction{ir.NewPush(tmp), ir.NewMov(acc, slot), exit}},
+		{"move through the accumulator",
+			[]ir.Instruction{ir.NewMov(acc, slot), ir.NewMov(tmp, acc), ir.NewMov(acc, slot), exit},
+			[]ir.Instruction{ir.NewMov(tmp, slot), ir.NewMov(acc, slot), exit}},
+		{"accumulator read later",
+			[]ir.Instruction{ir.NewMov(acc, tmp), ir.NewPush(acc), exit},
+			[]ir.Instruction{ir.NewMov(acc, tmp), ir.NewPush(acc), exit}},
+		{"overwritten accumulator",
+			[]ir.Instruction{ir.NewMov(acc, tmp), ir.NewMov(acc, slot), exit},
+			[]ir.Instruction{ir.NewMov(acc, slot), exit}},
+		{"consecutive shrinks",
+			[]ir.Instruction{ir.NewShrink(3), ir.NewShrink(1), ir.NewPush(acc), exit},
+			[]ir.Instruction{ir.NewShrink(1), ir.NewPush(acc), exit}},
+		{"shrink before exit",
+			[]ir.Instruction{ir.NewShrink(2), exit},
+			[]ir.Instruction{exit}},
+		{"shrink before result",
+			[]ir.Instruction{ir.NewShrink(2), ir.NewResult(acc)},
+			[]ir.Instruction{ir.NewResult(acc)}},
+	}
+	for _, test := range tests {
+		got := serialize(ir.Peephole(test.instrs, nil))
+		if want := serialize(test.want); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
+
+/* A sequence a jump lands inside is left alone, and labels past a
+ * rewrite move with the instructions they point at */
+func TestPeepholeLabels(t *testing.T) {
+	acc, tmp, slot := ir.NewRegisterAccess(0), ir.NewRegisterAccess(1), ir.NewStackAccess(-1)
+	inside := ir.NewInstructionLocation(1)
+	instrs := []ir.Instruction{ir.NewMov(acc, tmp), ir.NewPush(acc), ir.NewMov(acc, slot), ir.NewExit(acc)}
+	want := serialize(instrs)
+	if got := serialize(ir.Peephole(instrs, []*ir.InstructionLocation{inside})); got != want {
+		t.Errorf("rewrote a jump target, got\n%s\nwant\n%s", got, want)
+	}
+
+	after := ir.NewInstructionLocation(2)
+	instrs = []ir.Instruction{ir.NewMov(tmp, tmp), ir.NewPush(acc), ir.NewExit(acc)}
+	instrs = ir.Peephole(instrs, []*ir.InstructionLocation{after})
+	if len(instrs) != 2 {
+		t.Errorf("got %d instructions, want 2", len(instrs))
+	} else if after.GetLocation() != 1 {
+		t.Errorf("label at 0x%x, want 0x1", after.GetLocation())
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package ir
+
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/system"
+	"strconv"
+)
+
+/* Check what can be checked of a program before it runs: every location
+ * must be within the program and no address, index, count or shrink
+ * offset may be negative. Stack offsets, heap addresses and returns
+ * depend on the state of a run and are checked by the VM. Returns the
+ * offset of the first invalid instruction and what is wrong with it, or
+ * -1 when the program is valid. */
+func Check(instrs []Instruction) (int, string) {
+	for offset, instr := range instrs {
+		if msg := check(instr, len(instrs)); msg != "" {
+			return offset, msg
+		}
+	}
+	return -1, ""
+}
+
+/* Check a program, failing with a LoadError naming the invalid
+ * instruction */
+func Validate(instrs []Instruction) err.Error {
+	if offset, msg := Check(instrs); offset >= 0 {
+		return err.NewLoadError("Instruction 0x" + strconv.FormatInt(int64(offset), 16) + ": " + msg)
+	}
+	return nil
+}
+
+/* What of an instruction refers outside of it */
+type operands struct {
+	location  *InstructionLocation
+	accessors []Accessor
+	counts    []int
+	params    *system.Params
+}
+
+func operandsOf(instr Instruction) operands {
+	var o operands
+	switch i := instr.(type) {
+	case *Add:
+		o.accessors = []Accessor{i.l, i.r}
+	case *BinOp:
+		o.accessors = []Accessor{i.l, i.r}
+	case *Push:
+		o.accessors = []Accessor{i.v}
+	case *Release:
+		o.accessors = []Accessor{i.v}
+	case *Mov:
+		o.accessors = []Accessor{i.l, i.r}
+	case *Call:
+		o.location, o.params, o.counts = i.location, &i.params, []int{i.argc}
+	case *CallIndirect:
+		o.accessors, o.counts = []Accessor{i.fn}, []int{i.argc}
+	case *Closure:
+		o.location, o.params = i.location, &i.params
+		o.accessors = append([]Accessor{i.to}, i.captures...)
+	case *Result:
+		o.accessors = []Accessor{i.from}
+	case *Exit:
+		o.accessors = []Accessor{i.from}
+	case *Goto:
+		o.location = i.location
+	case *JumpUnless:
+		o.location, o.accessors = i.location, []Accessor{i.cond}
+	case *JumpDefined:
+		o.location, o.accessors = i.location, []Accessor{i.slot}
+	case *TailCall:
+		o.location, o.params, o.counts = i.location, &i.params, []int{i.argc, i.frameArgs}
+	case *TailCallIndirect:
+		o.accessors, o.counts = []Accessor{i.fn}, []int{i.argc, i.frameArgs}
+	case *HostCall:
+		o.counts = []int{i.argc}
+	case *Guard:
+		o.accessors = []Accessor{i.v}
+	}
+	return o
+}
+
+/* The size of the register file a program needs, counting the
+ * accumulator */
+func Registers(instrs []Instruction) int {
+	size := 1
+	for _, instr := range instrs {
+		for _, a := range operandsOf(instr).accessors {
+			if r, ok := a.(*RegisterAccess); ok && r.id >= size {
+				size = r.id + 1
+			}
+		}
+	}
+	return size
+}
+
+func check(instr Instruction, length int) string {
+	if instr == nil {
+		return "Missing instruction"
+	} else if shrink, ok := instr.(*Shrink); ok && shrink.offset < 0 {
+		return "Shrink offset is negative"
+	}
+
+	o := operandsOf(instr)
+	if o.location != nil && (o.location.GetLocation() < 0 || o.location.GetLocation() >= length) {
+		return "Instruction location outside of the program"
+	} else if o.params != nil && (o.params.Required < 0 || o.params.Optional < 0) {
+		return "Negative parameter count"
+	}
+	for _, count := range o.counts {
+		if count < 0 {
+			return "Negative argument count"
+		}
+	}
+	for _, a := range o.accessors {
+		if msg := checkAccess(a); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkAccess(a Accessor) string {
+	switch v := a.(type) {
+	case *MemoryAccess:
+		if v.addr < 0 {
+			return "Negative memory address"
+		}
+	case *RegisterAccess:
+		if v.id < 0 {
+			return "Negative register"
+		}
+	case *EnvAccess:
+		if v.index < 0 {
+			return "Negative environment index"
+		}
+	case nil:
+		return "Missing operand"
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package json
+
+import (
+	"bytes"
+)
+
+/* Pretty print the compact JSON of src into dst: each element on its own
+ * line starting with prefix and indented once per level of nesting.
+ * Empty arrays and objects stay on one line. */
+func Indent(dst *bytes.Buffer, src []byte, prefix, indent string) {
+	depth := 0
+	inString, escaped := false, false
+	newline := func() {
+		dst.WriteByte('\n')
+		dst.WriteString(prefix)
+		for i := 0; i < depth; i++ {
+			dst.WriteString(indent)
+		}
+	}
+
+	for i, c := range src {
+		if inString {
+			dst.WriteByte(c)
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+			dst.WriteByte(c)
+		case '{', '[':
+			dst.WriteByte(c)
+			if i+1 < len(src) && (src[i+1] == '}' || src[i+1] == ']') {
+				break
+			}
+			depth++
+			newline()
+		case '}', ']':
+			if i > 0 && src[i-1] != '{' && src[i-1] != '[' {
+				depth--
+				newline()
+			}
+			dst.WriteByte(c)
+		case ',':
+			dst.WriteByte(c)
+			newline()
+		case ':':
+			dst.WriteString(": ")
+		case ' ', '\t', '\n', '\r':
+		default:
+			dst.WriteByte(c)
+		}
+	}
+}
+
+/* The indented JSON of a value */
+func Pretty(value Serializable, indent string) string {
+	var compact, pretty bytes.Buffer
+	value.Serialize(&compact)
+	Indent(&pretty, compact.Bytes(), "", indent)
+	return pretty.String()
+}
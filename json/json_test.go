@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package json_test
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"github.com/rkophs/presta/json"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+/* An object of key value pairs, written with BuildMap */
+type object []*json.KV
+
+func (o object) Serialize(buffer *bytes.Buffer) {
+	json.BuildMap(buffer, o...)
+}
+
+func str(s string) json.Serializable {
+	return json.NewString(s)
+}
+
+func num(n float64) json.Serializable {
+	return json.NewNumber(n)
+}
+
+func arr(elems ...json.Serializable) json.Serializable {
+	return json.NewArray(elems)
+}
+
+var VALUES = []struct {
+	name  string
+	value json.Serializable
+	want  interface{} //As encoding/json decodes it
+}{
+	{"plain", str("abc"), "abc"},
+	{"empty", str(""), ""},
+	{"quote and backslash", str(`say "hi" \ bye`), `say "hi" \ bye`},
+	{"escapes", str("a\nb\rc\td"), "a\nb\rc\td"},
+	{"control characters", str("\x00\x01\x1f\x7f"), "\x00\x01\x1f\x7f"},
+	{"html", str("<a href='x'>&</a>"), "<a href='x'>&</a>"},
+	{"unicode", str("héllo, 世界 🙂"), "héllo, 世界 🙂"},
+	{"line separators", str("a\u2028b\u2029c"), "a\u2028b\u2029c"},
+	{"invalid utf-8", str("a\xffb"), "a�b"},
+	{"zero", num(0), 0.0},
+	{"integer", num(-42), -42.0},
+	{"fraction", num(3.25), 3.25},
+	{"small", num(1.5e-7), 1.5e-7},
+	{"large", num(1e21), 1e21},
+	{"largest", num(math.MaxFloat64), math.MaxFloat64},
+	{"nan", num(math.NaN()), nil},
+	{"infinity", num(math.Inf(1)), nil},
+	{"negative infinity", num(math.Inf(-1)), nil},
+	{"empty array", arr(), []interface{}{}},
+	{"empty object", object{}, map[string]interface{}{}},
+	{"array", arr(num(1), str("two"), arr(num(3))), []interface{}{1.0, "two", []interface{}{3.0}}},
+	{"nested", object{
+		{K: "a\"key\n", V: arr(object{{K: "b", V: num(1)}}, object{})},
+		{K: "c", V: object{{K: "d", V: arr(arr(), str("e"))}}},
+	}, map[string]interface{}{
+		"a\"key\n": []interface{}{map[string]interface{}{"b": 1.0}, map[string]interface{}{}},
+		"c":        map[string]interface{}{"d": []interface{}{[]interface{}{}, "e"}},
+	}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, test := range VALUES {
+		var buffer bytes.Buffer
+		test.value.Serialize(&buffer)
+		var got interface{}
+		if e := stdjson.Unmarshal(buffer.Bytes(), &got); e != nil {
+			t.Errorf("%s: %s is not valid JSON: %s", test.name, buffer.String(), e)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %s decoded to %#v, want %#v", test.name, buffer.String(), got, test.want)
+		}
+	}
+}
+
+func TestNumbersMatchEncodingJSON(t *testing.T) {
+	for _, n := range []float64{0, -1, 3.25, 1e20, 1e21, 1e-6, 1e-7, 1.5e-7, 123456789, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		var buffer bytes.Buffer
+		json.NewNumber(n).Serialize(&buffer)
+		want, _ := stdjson.Marshal(n)
+		if buffer.String() != string(want) {
+			t.Errorf("%s: got %s, want %s", strconv.FormatFloat(n, 'g', -1, 64), buffer.String(), want)
+		}
+	}
+}
+
+func TestIndentMatchesEncodingJSON(t *testing.T) {
+	for _, test := range VALUES {
+		var compact, want bytes.Buffer
+		test.value.Serialize(&compact)
+		if e := stdjson.Indent(&want, compact.Bytes(), "", "\t"); e != nil {
+			t.Fatalf("%s: %s", test.name, e)
+		}
+		if got := json.Pretty(test.value, "\t"); got != want.String() {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want.String())
+		}
+	}
+}
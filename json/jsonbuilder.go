@@ -26,9 +26,9 @@ package json
 
 import (
 	"bytes"
-	"encoding/binary"
-	"encoding/hex"
 	"math"
+	"strconv"
+	"unicode/utf8"
 )
 
 type Serializable interface {
@@ -66,47 +66,47 @@ func NewArray(elems []Serializable) *Array {
 }
 
 func (s *String) Serialize(buffer *bytes.Buffer) {
-	buffer.WriteRune('"')
-	buffer.WriteString(s.v)
-	buffer.WriteRune('"')
+	writeString(buffer, s.v)
 }
 
+/* Numbers are written as encoding/json writes them. JSON has no NaN or
+ * infinity, so those are written as null. */
 func (n *Number) Serialize(buffer *bytes.Buffer) {
-	bits := math.Float64bits(n.n)
-	bytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(bytes, bits)
+	if math.IsNaN(n.n) || math.IsInf(n.n, 0) {
+		buffer.WriteString("null")
+		return
+	}
 
-	buffer.WriteRune('"')
-	buffer.WriteString(hex.EncodeToString(bytes))
-	buffer.WriteRune('"')
+	format := byte('f')
+	if abs := math.Abs(n.n); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b := strconv.AppendFloat(nil, n.n, format, -1, 64)
+	if format == 'e' {
+		//Shorten exponents such as e-07 to e-7
+		if l := len(b); l >= 4 && b[l-4] == 'e' && b[l-3] == '-' && b[l-2] == '0' {
+			b[l-2] = b[l-1]
+			b = b[:l-1]
+		}
+	}
+	buffer.Write(b)
 }
 
 func (a *Array) Serialize(buffer *bytes.Buffer) {
-	buffer.WriteRune('[')
-	last := len(a.l) - 1
-	for it, elem := range a.l {
-		elem.Serialize(buffer)
-		if it != last {
-			buffer.WriteRune(',')
-		}
-	}
-	//buffer.UnreadRune()
-	buffer.WriteRune(']')
+	BuildArray(buffer, a.l)
 }
 
 func BuildMap(buffer *bytes.Buffer, tuples ...*KV) {
 	buffer.WriteString("{")
 	last := len(tuples) - 1
 	for it, tuple := range tuples {
-		buffer.WriteString("\"")
-		buffer.WriteString(tuple.K)
-		buffer.WriteString("\":")
+		writeString(buffer, tuple.K)
+		buffer.WriteRune(':')
 		tuple.V.Serialize(buffer)
 		if it != last {
 			buffer.WriteRune(',')
 		}
 	}
-	//buffer.UnreadRune()
 	buffer.WriteString("}")
 }
 
@@ -119,6 +119,40 @@ func BuildArray(buffer *bytes.Buffer, values []Serializable) {
 			buffer.WriteRune(',')
 		}
 	}
-	//buffer.UnreadRune()
 	buffer.WriteRune(']')
 }
+
+/* Quote s, escaping quotes, backslashes and control characters. Invalid
+ * UTF-8 is replaced by U+FFFD. */
+func writeString(buffer *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buffer.WriteRune('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			buffer.WriteRune('\\')
+			buffer.WriteRune(r)
+		case r == '\n':
+			buffer.WriteString(`\n`)
+		case r == '\r':
+			buffer.WriteString(`\r`)
+		case r == '\t':
+			buffer.WriteString(`\t`)
+		case r < 0x20:
+			buffer.WriteString(`\u00`)
+			buffer.WriteByte(hex[r>>4])
+			buffer.WriteByte(hex[r&0xF])
+		case r == utf8.RuneError && size == 1:
+			buffer.WriteString(`\ufffd`)
+		case r == '\u2028' || r == '\u2029':
+			//Valid JSON, but not valid in JavaScript strings
+			buffer.WriteString(`\u202`)
+			buffer.WriteByte(hex[r&0xF])
+		default:
+			buffer.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	buffer.WriteRune('"')
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package lint
+
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/parser"
+	"sort"
+	"strings"
+)
+
+const (
+	UNUSED             = "unused"
+	SHADOW             = "shadow"
+	SHADOWED_PARAM     = "shadowed-param"
+	UNREACHABLE        = "unreachable"
+	CONSTANT_CONDITION = "constant-condition"
+	SELF_ASSIGN        = "self-assign"
+)
+
+var Rules = []string{UNUSED, SHADOW, SHADOWED_PARAM, UNREACHABLE, CONSTANT_CONDITION, SELF_ASSIGN}
+
+/* The rules to report; all of them unless disabled */
+type Config struct {
+	disabled map[string]bool
+}
+
+func NewConfig() *Config {
+	return &Config{disabled: make(map[string]bool)}
+}
+
+func known(rule string) err.Error {
+	for _, r := range Rules {
+		if r == rule {
+			return nil
+		}
+	}
+	return err.NewSymanticError("Unknown lint rule '" + rule + "'")
+}
+
+func (c *Config) Enable(rule string) err.Error {
+	if e := known(rule); e != nil {
+		return e
+	}
+	delete(c.disabled, rule)
+	return nil
+}
+
+func (c *Config) Disable(rule string) err.Error {
+	if e := known(rule); e != nil {
+		return e
+	}
+	c.disabled[rule] = true
+	return nil
+}
+
+func (c *Config) Enabled(rule string) bool {
+	return !c.disabled[rule]
+}
+
+/* Collects the warnings of the enabled rules. A comment of the form
+ * "# lint:ignore rule..." suppresses the rules named, or every rule if
+ * none are, on its own line and the line after it. */
+type Linter struct {
+	config     *Config
+	suppressed map[int64][]string
+	warnings   []*err.Warning
+}
+
+const suppression = "lint:ignore"
+
+func NewLinter(config *Config, comments []parser.Comment) *Linter {
+	l := &Linter{config: config, suppressed: make(map[int64][]string), warnings: []*err.Warning{}}
+	for _, comment := range comments {
+		text := strings.TrimSpace(comment.Text)
+		if !strings.HasPrefix(text, suppression) {
+			continue
+		}
+		rules := strings.Fields(text[len(suppression):])
+		if len(rules) == 0 {
+			rules = Rules
+		}
+		for _, line := range []int64{comment.Pos.Line, comment.Pos.Line + 1} {
+			l.suppressed[line] = append(l.suppressed[line], rules...)
+		}
+	}
+	return l
+}
+
+func (l *Linter) Report(rule string, pos parser.Position, msg string) {
+	if !l.config.Enabled(rule) {
+		return
+	}
+	for _, suppressed := range l.suppressed[pos.Line] {
+		if suppressed == rule {
+			return
+		}
+	}
+	l.warnings = append(l.warnings, err.NewWarning(rule, pos.Line+1, pos.Column, msg))
+}
+
+/* Report the unused and shadowing declarations found by name resolution */
+func (l *Linter) CheckSymbols(table *parser.SymbolTable) {
+	for _, symbol := range table.Unused() {
+		l.Report(UNUSED, symbol.Pos, symbol.Kind.String()+" '"+symbol.Name+"' is never used")
+	}
+	for _, shadow := range table.Shadows() {
+		rule := SHADOW
+		if shadow.Outer.Kind == parser.PARAMETER {
+			rule = SHADOWED_PARAM
+		}
+		l.Report(rule, shadow.Symbol.Pos, shadow.Symbol.Kind.String()+" '"+shadow.Symbol.Name+
+			"' shadows the "+strings.ToLower(shadow.Outer.Kind.String())+" declared at "+shadow.Outer.Pos.String())
+	}
+}
+
+/* The warnings reported, in source order */
+func (l *Linter) Warnings() []*err.Warning {
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		a, b := l.warnings[i], l.warnings[j]
+		return a.Line() < b.Line() || (a.Line() == b.Line() && a.Column() < b.Column())
+	})
+	return l.warnings
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package lint_test
+
+import (
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/lint"
+	"strings"
+	"testing"
+)
+
+func warnings(t *testing.T, src string, config *lint.Config) []*err.Warning {
+	tokens, comments, e := presta.TokenizeWithComments(strings.NewReader(src))
+	if e != nil {
+		t.Fatalf("%q: %s", src, e.Message())
+	}
+	tree, e := presta.Parse(tokens)
+	if e != nil {
+		t.Fatalf("%q: %s", src, e.Message())
+	}
+	found, e := presta.Lint(tree, comments, config)
+	if e != nil {
+		t.Fatalf("%q: %s", src, e.Message())
+	}
+	return found
+}
+
+func messages(found []*err.Warning) []string {
+	msgs := []string{}
+	for _, w := range found {
+		msgs = append(msgs, w.Message())
+	}
+	return msgs
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{":(x)(1) 2", []string{"[1:3]\tVariable 'x' is never used (unused)"}},
+		{":(x)(1) ~f(a)(:(x)(a) x) f{x}", []string{"[1:17]\tVariable 'x' shadows the variable declared at [1:3] (shadow)"}},
+		{"~f(a)(~g(b)(:(a)(b) a) g{a}) f{1}", []string{"[1:15]\tVariable 'a' shadows the parameter declared at [1:4] (shadowed-param)"}},
+		{"|(1 'a' 0 'b')", []string{"[1:9]\tMatch branch is unreachable after an always-true condition (unreachable)"}},
+		{"|(== 1 2 'a' 1 'b' 0 'c')", []string{"[1:20]\tMatch branch is unreachable after an always-true condition (unreachable)"}},
+		{"^ 0 1", []string{"[1:3]\tLoop condition is always false (constant-condition)"}},
+		{"^ 1 1", []string{"[1:3]\tLoop condition is always true (constant-condition)"}},
+		{":(x)(1) :x x", []string{"[1:9]\t'x' is assigned to itself (self-assign)"}},
+		//In source order
+		{":(x)(1) |(1 :x x 1 2)", []string{"[1:13]\t'x' is assigned to itself (self-assign)", "[1:18]\tMatch branch is unreachable after an always-true condition (unreachable)"}},
+	}
+	for _, test := range tests {
+		if got := messages(warnings(t, test.src, lint.NewConfig())); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+/* A suppression comment covers its own line and the next one, for the
+ * rules it names or all of them */
+func TestSuppression(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{":(x)(1) 2 # lint:ignore unused", []string{}},
+		{"# lint:ignore unused\n:(x)(1) 2", []string{}},
+		{"# lint:ignore\n:(x)(1) :x x", []string{}},
+		{"# lint:ignore shadow\n:(x)(1) :x x", []string{"[2:9]\t'x' is assigned to itself (self-assign)"}},
+		{"# lint:ignore unused\n\n:(x)(1) 2", []string{"[3:3]\tVariable 'x' is never used (unused)"}},
+		{"# not lint:ignore unused\n:(x)(1) 2", []string{"[2:3]\tVariable 'x' is never used (unused)"}},
+	}
+	for _, test := range tests {
+		if got := messages(warnings(t, test.src, lint.NewConfig())); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	config := lint.NewConfig()
+	if e := config.Disable("unused"); e != nil {
+		t.Fatal(e.Message())
+	} else if got := warnings(t, ":(x)(1) :x x", config); len(got) != 1 || got[0].Rule() != lint.SELF_ASSIGN {
+		t.Errorf("got %q, want only the self-assign warning", messages(got))
+	}
+	if e := config.Enable("unused"); e != nil {
+		t.Fatal(e.Message())
+	} else if !config.Enabled(lint.UNUSED) {
+		t.Error("unused is still disabled")
+	}
+	if e := config.Disable("nmu"); e == nil {
+		t.Error("disabled an unknown rule")
+	}
+}
+
+/* Lines and columns count from 1, as the message does */
+func TestWarningPosition(t *testing.T) {
+	found := warnings(t, ":(y)(1)\n  :(x)(y) 2", lint.NewConfig())
+	if len(found) != 1 {
+		t.Fatalf("got %q, want one warning", messages(found))
+	}
+	w := found[0]
+	if w.Line() != 2 || w.Column() != 5 || w.Message() != "[2:5]\tVariable 'x' is never used (unused)" {
+		t.Errorf("got line %d column %d in %q, want line 2 column 5", w.Line(), w.Column(), w.Message())
+	}
+}
@@ -31,9 +31,10 @@ import (
 )
 
 type LexScanner struct {
-	r    *bufio.Reader
-	line int64
-	pos  int64
+	r        *bufio.Reader
+	line     int64
+	pos      int64
+	comments []Comment
 }
 
 func NewLexScanner(r io.Reader) *LexScanner {
@@ -42,8 +43,14 @@ func NewLexScanner(r io.Reader) *LexScanner {
 
 func (s *LexScanner) Scan() *Token {
 
-	if ch, _, _ := s.peek(); isWhitespace(ch) {
-		s.discardWhitespace()
+	for {
+		if ch, _, _ := s.peek(); isWhitespace(ch) {
+			s.discardWhitespace()
+		} else if isComment(ch) {
+			s.scanComment()
+		} else {
+			break
+		}
 	}
 
 	if ch, _, _ := s.peek(); isLetter(ch) {
@@ -117,7 +124,7 @@ func (s *LexScanner) scanOperation() *Token {
 	case '~':
 		tok, lit = FUNC, buf.String()
 	case '.':
-		tok, lit = CONCAT, buf.String()
+		tok, lit = s.handleEllipsis(buf)
 	default:
 		tok, lit = ILLEGAL, buf.String()
 	}
@@ -135,6 +142,22 @@ func (s *LexScanner) handleTwoOptions(cmp rune, yes Tok, no Tok, buf bytes.Buffe
 	}
 }
 
+/* A single '.' concatenates while '...' marks a rest param */
+func (s *LexScanner) handleEllipsis(buf bytes.Buffer) (tok Tok, lit string) {
+	if ch, _, _ := s.peek(); ch != '.' {
+		return CONCAT, buf.String()
+	}
+	for i := 0; i < 2; i++ {
+		if ch, _, _ := s.peek(); ch != '.' {
+			return ILLEGAL, buf.String()
+		} else {
+			s.read()
+			buf.WriteRune(ch)
+		}
+	}
+	return REST, buf.String()
+}
+
 func (s *LexScanner) handleThreeOptions(ifCmp rune, elifCmp rune, a Tok, b Tok, c Tok, buf bytes.Buffer) (tok Tok, lit string) {
 	if ch, _, _ := s.peek(); ch == ifCmp {
 		s.read()
@@ -209,6 +232,25 @@ func (s *LexScanner) scanIdent() *Token {
 	return &Token{tok: IDENTIFIER, lit: buf.String(), line: l, pos: p}
 }
 
+/* Comments run from '#' to the end of the line. They are not tokens but
+ * are kept for tools which read them, such as lint suppressions. */
+func (s *LexScanner) scanComment() {
+	var buf bytes.Buffer
+	_, l, p := s.read()
+	for {
+		if ch, _, _ := s.peek(); ch == eof || ch == '\n' {
+			break
+		}
+		ch, _, _ := s.read()
+		buf.WriteRune(ch)
+	}
+	s.comments = append(s.comments, Comment{Pos: Position{Line: l, Column: p}, Text: buf.String()})
+}
+
+func (s *LexScanner) Comments() []Comment {
+	return s.comments
+}
+
 func (s *LexScanner) discardWhitespace() {
 	for {
 		if ch, _, _ := s.peek(); ch == eof || !isWhitespace(ch) {
@@ -252,6 +294,8 @@ func isSymbol(ch rune) bool {
 
 func isQuote(ch rune) bool { return ch == '\'' }
 
+func isComment(ch rune) bool { return ch == '#' }
+
 func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' }
 
 func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
@@ -0,0 +1,386 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package parser
+
+import (
+	"github.com/rkophs/presta/err"
+)
+
+type SymbolKind int64
+
+const (
+	VARIABLE SymbolKind = iota
+	FUNCTION
+	PARAMETER
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case FUNCTION:
+		return "Function"
+	case PARAMETER:
+		return "Parameter"
+	default:
+		return "Variable"
+	}
+}
+
+/* A declared name: a function, param or let binding */
+type Symbol struct {
+	Name   string
+	Kind   SymbolKind
+	Id     int
+	Pos    Position
+	Uses   int           //References which read the symbol
+	Writes int           //Assignments to the symbol
+	frame  *resolveFrame //Function body declaring it, nil at top level
+	body   *resolveFrame //Body of a declared function
+}
+
+/* A declaration hiding a symbol of an enclosing scope */
+type Shadow struct {
+	Symbol *Symbol
+	Outer  *Symbol
+}
+
+/* Every symbol of a program, the symbol each name reference resolved to,
+ * and the declarations which shadow another */
+type SymbolTable struct {
+	symbols []*Symbol
+	refs    map[interface{}]*Symbol
+	decls   map[interface{}][]*Symbol
+	fns     map[interface{}]*Symbol
+	shadows []Shadow
+}
+
+func newSymbolTable() *SymbolTable {
+	return &SymbolTable{symbols: []*Symbol{}, refs: make(map[interface{}]*Symbol),
+		decls: make(map[interface{}][]*Symbol), fns: make(map[interface{}]*Symbol), shadows: []Shadow{}}
+}
+
+func (t *SymbolTable) Symbols() []*Symbol {
+	return t.symbols
+}
+
+/* The symbol a referencing node resolved to, nil if it was not resolved */
+func (t *SymbolTable) Lookup(node interface{}) *Symbol {
+	return t.refs[node]
+}
+
+/* The params or bindings a declaring node introduced, in order */
+func (t *SymbolTable) Declarations(node interface{}) []*Symbol {
+	return t.decls[node]
+}
+
+/* The symbol of a function declaration, nil if it was not declared */
+func (t *SymbolTable) Function(node interface{}) *Symbol {
+	return t.fns[node]
+}
+
+/* Symbols never read, in declaration order */
+func (t *SymbolTable) Unused() []*Symbol {
+	unused := []*Symbol{}
+	for _, symbol := range t.symbols {
+		if symbol.Uses == 0 {
+			unused = append(unused, symbol)
+		}
+	}
+	return unused
+}
+
+func (t *SymbolTable) Shadows() []Shadow {
+	return t.shadows
+}
+
+type resolveScope struct {
+	vars  map[string]*Symbol
+	fns   map[string]*Symbol
+	frame *resolveFrame
+}
+
+/* The body of a function, which generates into its own stack frame, with
+ * the symbols of enclosing frames it uses */
+type resolveFrame struct {
+	outer *resolveFrame
+	node  interface{} //The declaration, nil for a lambda
+	free  []*Symbol   //Variables of enclosing frames
+	calls []*Symbol   //Declared functions referred to
+}
+
+/* A reference to a declared function and the scopes it was made in */
+type functionRef struct {
+	symbol *Symbol
+	scopes []*resolveScope
+	pos    Position
+}
+
+/* The name resolution pass. It follows the scoping rules of code
+ * generation, so every reference it accepts can be generated, but runs
+ * over the whole tree before any code is emitted. Ids come from the
+ * Semantic the tree will then be generated with. */
+type Resolver struct {
+	s      *Semantic
+	scopes []*resolveScope
+	table  *SymbolTable
+	frames []*resolveFrame //Declared function bodies
+	refs   []functionRef
+}
+
+func NewResolver(s *Semantic) *Resolver {
+	r := &Resolver{s: s, scopes: []*resolveScope{}, table: newSymbolTable()}
+	r.PushScope()
+	return r
+}
+
+func (r *Resolver) Table() *SymbolTable {
+	return r.table
+}
+
+func (r *Resolver) PushScope() {
+	r.pushScope(r.frame())
+}
+
+/* Open the scope of a function body, which is a new frame. Node is the
+ * declaration of a named function, or the function of a lambda. */
+func (r *Resolver) PushFunctionScope(node interface{}) {
+	frame := &resolveFrame{outer: r.frame()}
+	if symbol := r.table.fns[node]; symbol != nil {
+		frame.node, symbol.body = node, frame
+		r.frames = append(r.frames, frame)
+	}
+	r.pushScope(frame)
+}
+
+func (r *Resolver) pushScope(frame *resolveFrame) {
+	scope := &resolveScope{vars: make(map[string]*Symbol), fns: make(map[string]*Symbol), frame: frame}
+	r.scopes = append([]*resolveScope{scope}, r.scopes...)
+}
+
+func (r *Resolver) frame() *resolveFrame {
+	if len(r.scopes) == 0 {
+		return nil
+	}
+	return r.scopes[0].frame
+}
+
+func (r *Resolver) PopScope() {
+	r.scopes = r.scopes[1:]
+}
+
+func (r *Resolver) declare(names map[string]*Symbol, name string, kind SymbolKind, pos Position) (*Symbol, err.Error) {
+	if previous, ok := names[name]; ok {
+		return nil, err.NewSymanticError(pos.String() + "\t" + kind.String() + " '" + name + "' is already declared at " + previous.Pos.String())
+	}
+
+	symbol := &Symbol{Name: name, Kind: kind, Id: r.s.nextId(), Pos: pos, frame: r.frame()}
+	for _, scope := range r.scopes[1:] {
+		if outer, ok := scope.vars[name]; ok {
+			r.table.shadows = append(r.table.shadows, Shadow{Symbol: symbol, Outer: outer})
+			break
+		} else if outer, ok := scope.fns[name]; ok {
+			r.table.shadows = append(r.table.shadows, Shadow{Symbol: symbol, Outer: outer})
+			break
+		}
+	}
+
+	names[name] = symbol
+	r.table.symbols = append(r.table.symbols, symbol)
+	return symbol, nil
+}
+
+/* Declare the let bindings of node in the innermost scope, each at the
+ * position it is named at */
+func (r *Resolver) DeclareVariables(node interface{}, names []string, positions []Position) err.Error {
+	return r.declareAll(node, names, VARIABLE, positions)
+}
+
+func (r *Resolver) DeclareParams(node interface{}, names []string, positions []Position) err.Error {
+	return r.declareAll(node, names, PARAMETER, positions)
+}
+
+func (r *Resolver) declareAll(node interface{}, names []string, kind SymbolKind, positions []Position) err.Error {
+	symbols := make([]*Symbol, len(names))
+	for i, name := range names {
+		if symbol, e := r.declare(r.scopes[0].vars, name, kind, positions[i]); e != nil {
+			return e
+		} else {
+			symbols[i] = symbol
+		}
+	}
+	r.table.decls[node] = symbols
+	return nil
+}
+
+func (r *Resolver) DeclareFunction(node interface{}, name string, pos Position) err.Error {
+	symbol, e := r.declare(r.scopes[0].fns, name, FUNCTION, pos)
+	if e == nil {
+		r.table.fns[node] = symbol
+	}
+	return e
+}
+
+func (r *Resolver) lookupVariable(name string) *Symbol {
+	for _, scope := range r.scopes {
+		if symbol, ok := scope.vars[name]; ok {
+			return symbol
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) lookupFunction(name string) *Symbol {
+	for _, scope := range r.scopes {
+		if symbol, ok := scope.fns[name]; ok {
+			return symbol
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) use(node interface{}, symbol *Symbol, read bool, pos Position) {
+	if read {
+		symbol.Uses++
+	} else {
+		symbol.Writes++
+	}
+	r.table.refs[node] = symbol
+
+	//Record what each frame between the use and the declaration needs
+	for frame := r.frame(); frame != nil && frame != symbol.frame; frame = frame.outer {
+		if symbol.Kind == FUNCTION {
+			frame.calls = appendSymbol(frame.calls, symbol)
+		} else {
+			frame.free = appendSymbol(frame.free, symbol)
+		}
+	}
+	if symbol.Kind == FUNCTION {
+		r.refs = append(r.refs, functionRef{symbol: symbol, scopes: r.scopes, pos: pos})
+	}
+}
+
+func appendSymbol(symbols []*Symbol, symbol *Symbol) []*Symbol {
+	for _, s := range symbols {
+		if s == symbol {
+			return symbols
+		}
+	}
+	return append(symbols, symbol)
+}
+
+/* Whether a symbol is declared outside of a frame */
+func (f *resolveFrame) outside(symbol *Symbol) bool {
+	for outer := f.outer; outer != nil; outer = outer.outer {
+		if outer == symbol.frame {
+			return true
+		}
+	}
+	return symbol.frame == nil
+}
+
+/* Complete the pass once the whole tree is resolved by working out the
+ * variables each declared function captures: those of enclosing frames
+ * it uses and those of the functions it refers to, as it builds their
+ * function values. Every reference to a capturing function must see the
+ * variables it captures under their names. */
+func (r *Resolver) Finish() err.Error {
+	for changed := true; changed; {
+		changed = false
+		for _, frame := range r.frames {
+			for _, fn := range frame.calls {
+				for _, symbol := range fn.body.free {
+					if n := len(frame.free); frame.outside(symbol) {
+						frame.free = appendSymbol(frame.free, symbol)
+						changed = changed || len(frame.free) != n
+					}
+				}
+			}
+		}
+	}
+
+	for _, ref := range r.refs {
+		for _, symbol := range ref.symbol.body.free {
+			if visible := lookupIn(ref.scopes, symbol.Name); visible != symbol {
+				return err.NewSymanticError(ref.pos.String() + "	Function '" + ref.symbol.Name + "' uses the variable '" +
+					symbol.Name + "' declared at " + symbol.Pos.String() + ", which is hidden here")
+			}
+		}
+	}
+
+	for _, frame := range r.frames {
+		names := make([]string, len(frame.free))
+		for i, symbol := range frame.free {
+			names[i] = symbol.Name
+		}
+		r.s.setCaptures(frame.node, names)
+	}
+	return nil
+}
+
+func lookupIn(scopes []*resolveScope, name string) *Symbol {
+	for _, scope := range scopes {
+		if symbol, ok := scope.vars[name]; ok {
+			return symbol
+		}
+	}
+	return nil
+}
+
+/* Resolve a name read as a value: variables come before functions */
+func (r *Resolver) ResolveValue(node interface{}, name string, pos Position) err.Error {
+	if symbol := r.lookupVariable(name); symbol != nil {
+		r.use(node, symbol, true, pos)
+	} else if symbol := r.lookupFunction(name); symbol != nil {
+		r.use(node, symbol, true, pos)
+	} else {
+		return err.NewSymanticError(pos.String() + "\tUndefined variable '" + name + "'")
+	}
+	return nil
+}
+
+/* Resolve a callee name: the innermost binding wins */
+func (r *Resolver) ResolveCallee(node interface{}, name string, pos Position) err.Error {
+	for _, scope := range r.scopes {
+		if symbol, ok := scope.vars[name]; ok {
+			r.use(node, symbol, true, pos)
+			return nil
+		} else if symbol, ok := scope.fns[name]; ok {
+			r.use(node, symbol, true, pos)
+			return nil
+		}
+	}
+	if _, ok := r.s.host.Lookup(name); ok {
+		return nil
+	}
+	return err.NewSymanticError(pos.String() + "\tFunction '" + name + "' not found")
+}
+
+/* Resolve the target of an assignment, which does not count as a use */
+func (r *Resolver) ResolveAssignment(node interface{}, name string, pos Position) err.Error {
+	if symbol := r.lookupVariable(name); symbol != nil {
+		r.use(node, symbol, false, pos)
+		return nil
+	}
+	return err.NewSymanticError(pos.String() + "\tUndefined variable '" + name + "'")
+}
@@ -24,32 +24,59 @@
 
 package parser
 
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/system"
+	"strconv"
+)
+
 type Semantic struct {
-	fns   map[string]*fnTuple
-	vars  []*scope
-	ids   int
-	scope int
+	scopes   []*scope
+	frames   []*frame
+	ids      int
+	host     *system.Host
+	captures map[interface{}][]string //Declaration -> variables it captures
 }
 
 type fnTuple struct {
-	arity int
-	id    int
+	params   system.Params
+	id       int
+	captures []string
 }
 
 type scope struct {
-	vars map[string]int
+	vars  map[string]int
+	fns   map[string]*fnTuple
+	frame int
+	opens bool
+}
+
+/* A stack frame of generated code. Lambdas capture the variables bound in
+ * enclosing frames as they are used; named functions capture those the
+ * Resolver found they use, which are sealed. */
+type frame struct {
+	capturing bool
+	sealed    bool
+	captures  []string
 }
 
 func NewSemantic() *Semantic {
-	return &Semantic{fns: make(map[string]*fnTuple), vars: make([]*scope, 0), ids: 0, scope: 0}
+	s := &Semantic{scopes: make([]*scope, 0), frames: make([]*frame, 0), ids: 0, captures: make(map[interface{}][]string)}
+	s.pushFrame([]string{}, &frame{captures: []string{}})
+	return s
 }
 
-func newFnType(arity, id int) *fnTuple {
-	return &fnTuple{arity: arity, id: id}
+/* Make the functions of a host callable from the script */
+func (s *Semantic) SetHost(host *system.Host) {
+	s.host = host
 }
 
-func newScope(vars map[string]int) *scope {
-	return &scope{vars: vars}
+func newFnType(params system.Params, id int, captures []string) *fnTuple {
+	return &fnTuple{params: params, id: id, captures: captures}
+}
+
+func newScope(vars map[string]int, frame int, opens bool) *scope {
+	return &scope{vars: vars, fns: make(map[string]*fnTuple), frame: frame, opens: opens}
 }
 
 func (s *Semantic) nextId() int {
@@ -57,50 +84,195 @@ func (s *Semantic) nextId() int {
 	return s.ids
 }
 
-func (s *Semantic) AddFunction(name string, arity int) {
-	s.fns[name] = newFnType(arity, s.nextId())
+func (s *Semantic) currentFrame() int {
+	return len(s.frames) - 1
+}
+
+/* Functions are declared in the innermost scope and are visible to every
+ * scope nested beneath it, including the bodies of nested functions.
+ * Captures are the variables of enclosing frames the function uses. */
+func (s *Semantic) AddFunction(name string, params system.Params, captures []string) int {
+	fn := newFnType(params, s.nextId(), captures)
+	s.scopes[0].fns[name] = fn
+	return fn.id
+}
+
+func (s *Semantic) setCaptures(node interface{}, captures []string) {
+	s.captures[node] = captures
+}
+
+/* The variables of enclosing frames a function declaration captures, as
+ * worked out by the Resolver */
+func (s *Semantic) Captured(node interface{}) []string {
+	return s.captures[node]
+}
+
+/* The variables a declared function captures, in environment order */
+func (s *Semantic) FunctionCaptures(name string) []string {
+	if fn := s.lookupFunction(name); fn != nil {
+		return fn.captures
+	}
+	return nil
+}
+
+func (s *Semantic) lookupFunction(name string) *fnTuple {
+	for _, scope := range s.scopes {
+		if fn, ok := scope.fns[name]; ok {
+			return fn
+		}
+	}
+	return nil
 }
 
 func (s *Semantic) FunctionExists(name string) bool {
-	_, ok := s.fns[name]
-	return ok
+	return s.lookupFunction(name) != nil
+}
+
+func (s *Semantic) FunctionParams(name string) system.Params {
+	if fn := s.lookupFunction(name); fn != nil {
+		return fn.params
+	} else {
+		return system.Params{}
+	}
 }
 
-func (s *Semantic) FunctionArity(name string) int {
-	if fn, ok := s.fns[name]; ok {
-		return fn.arity
+/* Check a direct call at pos passes an argument count the function
+ * accepts */
+func (s *Semantic) CheckArity(name string, argc int, pos Position) err.Error {
+	var params system.Params
+	if fn := s.lookupFunction(name); fn != nil {
+		params = fn.params
+	} else if host, ok := s.host.Lookup(name); ok {
+		params = host.Params()
+	} else {
+		return err.NewSymanticError(pos.String() + "\tFunction '" + name + "' not found")
+	}
+	if !params.Accepts(argc) {
+		return err.NewSymanticError(pos.String() + "\tFunction '" + name + "' expects " + params.Describe() +
+			", got " + strconv.Itoa(argc))
+	}
+	return nil
+}
+
+func (s *Semantic) GetFunctionId(name string) int {
+	if fn := s.lookupFunction(name); fn != nil {
+		return fn.id
 	} else {
 		return -1
 	}
 }
 
-func (s *Semantic) PushNewScope(names []string) {
+func (s *Semantic) pushScope(names []string, opens bool) {
 	vars := make(map[string]int)
 	for _, name := range names {
 		vars[name] = s.nextId()
 	}
 
-	s.vars = append([]*scope{newScope(vars)}, s.vars...)
+	s.scopes = append([]*scope{newScope(vars, s.currentFrame(), opens)}, s.scopes...)
+}
+
+func (s *Semantic) pushFrame(params []string, f *frame) {
+	s.frames = append(s.frames, f)
+	s.pushScope(params, true)
+}
+
+/* Let bindings share the stack frame of the enclosing function */
+func (s *Semantic) PushNewScope(names []string) {
+	s.pushScope(names, false)
+}
+
+/* Function parameters open a new stack frame, whose closure environment
+ * holds the given captures */
+func (s *Semantic) PushFunctionScope(params []string, captures []string) {
+	s.pushFrame(params, &frame{capturing: true, sealed: true, captures: captures})
+}
+
+/* Lambda parameters open a new stack frame which captures free variables */
+func (s *Semantic) PushLambdaScope(params []string) {
+	s.pushFrame(params, &frame{capturing: true, captures: []string{}})
 }
 
 func (s *Semantic) PopScope() {
-	s.vars = s.vars[1:]
+	if s.scopes[0].opens {
+		s.frames = s.frames[:s.currentFrame()]
+	}
+	s.scopes = s.scopes[1:]
+}
+
+func (s *Semantic) lookupVariable(name string) (id int, frame int) {
+	for _, scope := range s.scopes {
+		if v, ok := scope.vars[name]; ok {
+			return v, scope.frame
+		}
+	}
+	return -1, -1
 }
 
 func (s *Semantic) VariableExists(name string) bool {
-	for _, scope := range s.vars {
+	id, _ := s.lookupVariable(name)
+	return id != -1
+}
+
+/* A variable is captured when it is bound in the frame of an enclosing
+ * function rather than the frame currently being generated. */
+func (s *Semantic) VariableCaptured(name string) bool {
+	id, frame := s.lookupVariable(name)
+	return id != -1 && frame != s.currentFrame()
+}
+
+/* Whether a name used as a callee refers to a variable holding a function
+ * value rather than a declared function. The innermost binding wins. */
+func (s *Semantic) IsVariableCall(name string) bool {
+	for _, scope := range s.scopes {
 		if _, ok := scope.vars[name]; ok {
 			return true
+		} else if _, ok := scope.fns[name]; ok {
+			return false
 		}
 	}
 	return false
 }
 
-func (s *Semantic) GetVariableId(name string) int {
-	for _, scope := range s.vars {
-		if v, ok := scope.vars[name]; ok {
-			return v
+/* Whether a callee is a host function, which it is when no variable or
+ * declared function of the script has its name */
+func (s *Semantic) IsHostCall(name string) bool {
+	if s.VariableExists(name) || s.FunctionExists(name) {
+		return false
+	}
+	_, ok := s.host.Lookup(name)
+	return ok
+}
+
+func (s *Semantic) HostFunction(name string) (*system.HostFunction, bool) {
+	return s.host.Lookup(name)
+}
+
+/* Record a captured variable in the current lambda frame and return its
+ * index in the closure environment. The environment of a sealed frame
+ * cannot grow. */
+func (s *Semantic) CaptureVariable(name string) (index int, ok bool) {
+	f := s.frames[s.currentFrame()]
+	if !f.capturing {
+		return -1, false
+	}
+	for i, capture := range f.captures {
+		if capture == name {
+			return i, true
 		}
 	}
-	return -1
+	if f.sealed {
+		return -1, false
+	}
+	f.captures = append(f.captures, name)
+	return len(f.captures) - 1, true
+}
+
+/* Variables captured by the current lambda frame in environment order */
+func (s *Semantic) Captures() []string {
+	return s.frames[s.currentFrame()].captures
+}
+
+func (s *Semantic) GetVariableId(name string) int {
+	id, _ := s.lookupVariable(name)
+	return id
 }
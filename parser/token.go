@@ -24,6 +24,10 @@
 
 package parser
 
+import (
+	"strconv"
+)
+
 // Token represents a lexical token.
 
 type Token struct {
@@ -84,6 +88,7 @@ const (
 
 	NOT
 	CONCAT
+	REST
 )
 
 func (t *Token) Type() Tok {
@@ -101,3 +106,24 @@ func (t *Token) Line() int64 {
 func (t *Token) Pos() int64 {
 	return t.pos
 }
+
+func (t *Token) Position() Position {
+	return Position{Line: t.line, Column: t.pos}
+}
+
+/* Source location of a token. Lines are counted from 0 by the lexer and
+ * printed from 1. */
+type Position struct {
+	Line   int64
+	Column int64
+}
+
+func (p Position) String() string {
+	return "[" + strconv.FormatInt(p.Line+1, 10) + ":" + strconv.FormatInt(p.Column, 10) + "]"
+}
+
+/* A comment and the position of its '#' */
+type Comment struct {
+	Pos  Position
+	Text string
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* The standard library. Builtins are host functions every script can
+ * call, unless a registered host function or one of its own functions
+ * shadows them. Strings are indexed by character. */
+var builtins = map[string]*HostFunction{}
+
+func define(name string, params Params, fn func(args []Value) (Value, error)) {
+	builtins[name] = &HostFunction{name: name, params: params, fn: fn, pattern: -1}
+}
+
+/* Define a builtin taking a regular expression as argument pattern */
+func definePattern(name string, params Params, pattern int, fn func(args []Value) (Value, error)) {
+	builtins[name] = &HostFunction{name: name, params: params, fn: fn, pattern: pattern}
+}
+
+func init() {
+	//Strings
+	define("len", Params{Required: 1}, length)
+	define("substr", Params{Required: 2, Optional: 1}, substr)
+	define("upper", Params{Required: 1}, stringFunction(strings.ToUpper))
+	define("lower", Params{Required: 1}, stringFunction(strings.ToLower))
+	define("trim", Params{Required: 1}, stringFunction(strings.TrimSpace))
+	define("split", Params{Required: 2}, split)
+	define("replace", Params{Required: 3}, replace)
+	define("index", Params{Required: 2}, index)
+
+	//Regular expressions. The pattern replacement is replaceMatches, as
+	//replace above replaces plain text: replace{s '.' ','} changes dots
+	//where a pattern would change every character
+	definePattern("match", Params{Required: 2}, 1, match)
+	definePattern("find", Params{Required: 2}, 1, find)
+	definePattern("findAll", Params{Required: 2}, 1, findAll)
+	definePattern("groups", Params{Required: 2}, 1, groups)
+	definePattern("replaceMatches", Params{Required: 3}, 1, replaceMatches)
+
+	//Math
+	define("abs", Params{Required: 1}, mathFunction(math.Abs))
+	define("floor", Params{Required: 1}, mathFunction(math.Floor))
+	define("ceil", Params{Required: 1}, mathFunction(math.Ceil))
+	define("round", Params{Required: 1}, mathFunction(math.Round))
+	define("sqrt", Params{Required: 1}, mathFunction(math.Sqrt))
+	define("min", Params{Required: 1, Rest: true}, extreme(math.Min))
+	define("max", Params{Required: 1, Rest: true}, extreme(math.Max))
+	define("pow", Params{Required: 2}, pow)
+
+	//Conversions and type inspection
+	define("toNumber", Params{Required: 1}, toNumber)
+	define("toString", Params{Required: 1}, toString)
+	define("typeOf", Params{Required: 1}, typeOf)
+
+	//JSON and maps
+	define("parseJson", Params{Required: 1}, parseJson)
+	define("toJson", Params{Required: 1}, toJson)
+	define("get", Params{Required: 2}, get)
+	define("keys", Params{Required: 1}, keys)
+}
+
+/* The builtin of a name, if there is one */
+func Builtin(name string) (*HostFunction, bool) {
+	fn, ok := builtins[name]
+	return fn, ok
+}
+
+/*=================================================================================*/
+
+func argError(i int, want string, got Value) error {
+	return errors.New("argument " + strconv.Itoa(i+1) + " expects " + want + ", got " + TypeName(got))
+}
+
+func numberArg(args []Value, i int) (float64, error) {
+	if n, ok := args[i].(*Number); ok {
+		return n.number, nil
+	}
+	return 0, argError(i, "num", args[i])
+}
+
+func stringArg(args []Value, i int) (string, error) {
+	if s, ok := args[i].(*String); ok {
+		return s.str, nil
+	}
+	return "", argError(i, "str", args[i])
+}
+
+/* A number used as a count or position, which must be whole and not
+ * negative */
+func countArg(args []Value, i int) (int, error) {
+	n, e := numberArg(args, i)
+	if e != nil {
+		return 0, e
+	} else if n < 0 || n != math.Trunc(n) {
+		return 0, errors.New("argument " + strconv.Itoa(i+1) + " expects a whole number of at least 0, got " + strconv.FormatFloat(n, 'g', -1, 64))
+	}
+	return int(n), nil
+}
+
+func stringFunction(f func(string) string) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		s, e := stringArg(args, 0)
+		if e != nil {
+			return nil, e
+		}
+		return NewString(f(s)), nil
+	}
+}
+
+func mathFunction(f func(float64) float64) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		n, e := numberArg(args, 0)
+		if e != nil {
+			return nil, e
+		}
+		return NewNumber(f(n)), nil
+	}
+}
+
+/* The least or greatest of the args, as picked by f */
+func extreme(f func(float64, float64) float64) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		result, e := numberArg(args, 0)
+		if e != nil {
+			return nil, e
+		}
+		for i := 1; i < len(args); i++ {
+			n, e := numberArg(args, i)
+			if e != nil {
+				return nil, e
+			}
+			result = f(result, n)
+		}
+		return NewNumber(result), nil
+	}
+}
+
+func stringList(strs []string) *List {
+	entries := make([]StackEntry, len(strs))
+	for i, s := range strs {
+		entries[i] = NewString(s)
+	}
+	return NewList(entries)
+}
+
+/*=================================================================================*/
+
+func length(args []Value) (Value, error) {
+	switch v := args[0].(type) {
+	case *String:
+		return NewNumber(float64(len([]rune(v.str)))), nil
+	case *List:
+		return NewNumber(float64(len(v.entries))), nil
+	case *Map:
+		return NewNumber(float64(len(v.entries))), nil
+	}
+	return nil, argError(0, "str, list or map", args[0])
+}
+
+/* The characters from start, up to length of them or to the end of the
+ * string */
+func substr(args []Value) (Value, error) {
+	s, e := stringArg(args, 0)
+	if e != nil {
+		return nil, e
+	}
+	start, e := countArg(args, 1)
+	if e != nil {
+		return nil, e
+	}
+	runes := []rune(s)
+	if start > len(runes) {
+		start = len(runes)
+	}
+	end := len(runes)
+	if len(args) > 2 {
+		length, e := countArg(args, 2)
+		if e != nil {
+			return nil, e
+		} else if start+length < end {
+			end = start + length
+		}
+	}
+	return NewString(string(runes[start:end])), nil
+}
+
+func split(args []Value) (Value, error) {
+	s, e := stringArg(args, 0)
+	if e != nil {
+		return nil, e
+	}
+	sep, e := stringArg(args, 1)
+	if e != nil {
+		return nil, e
+	}
+	return stringList(strings.Split(s, sep)), nil
+}
+
+func replace(args []Value) (Value, error) {
+	strs := make([]string, 3)
+	for i := range strs {
+		s, e := stringArg(args, i)
+		if e != nil {
+			return nil, e
+		}
+		strs[i] = s
+	}
+	return NewString(strings.Replace(strs[0], strs[1], strs[2], -1)), nil
+}
+
+/* The character position of the first occurrence of sub, or -1 */
+func index(args []Value) (Value, error) {
+	s, e := stringArg(args, 0)
+	if e != nil {
+		return nil, e
+	}
+	sub, e := stringArg(args, 1)
+	if e != nil {
+		return nil, e
+	}
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return NewNumber(-1), nil
+	}
+	return NewNumber(float64(len([]rune(s[:i])))), nil
+}
+
+func pow(args []Value) (Value, error) {
+	base, e := numberArg(args, 0)
+	if e != nil {
+		return nil, e
+	}
+	exponent, e := numberArg(args, 1)
+	if e != nil {
+		return nil, e
+	}
+	return NewNumber(math.Pow(base, exponent)), nil
+}
+
+func toNumber(args []Value) (Value, error) {
+	switch v := args[0].(type) {
+	case *Number:
+		return v, nil
+	case *String:
+		n, e := strconv.ParseFloat(strings.TrimSpace(v.str), 64)
+		if e != nil {
+			return nil, errors.New("cannot convert " + strconv.Quote(v.str) + " to a number")
+		}
+		return NewNumber(n), nil
+	}
+	return nil, argError(0, "num or str", args[0])
+}
+
+func toString(args []Value) (Value, error) {
+	s, e := args[0].ToString()
+	if e != nil {
+		return nil, errors.New(e.Message())
+	}
+	return NewString(s), nil
+}
+
+func typeOf(args []Value) (Value, error) {
+	return NewString(TypeName(args[0])), nil
+}
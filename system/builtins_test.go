@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system_test
+
+import (
+	"github.com/rkophs/presta/system"
+	"strings"
+	"testing"
+)
+
+func num(n float64) system.Value {
+	return system.NewNumber(n)
+}
+
+func str(s string) system.Value {
+	return system.NewString(s)
+}
+
+func list(entries ...system.Value) system.Value {
+	return system.NewList(entries)
+}
+
+/* Call a builtin, returning its result as a string or the message of the
+ * error it failed with */
+func call(t *testing.T, name string, args ...system.Value) string {
+	fn, ok := system.Builtin(name)
+	if !ok {
+		t.Fatalf("%s is not a builtin", name)
+	} else if !fn.Params().Accepts(len(args)) {
+		t.Fatalf("%s does not take %d arguments", name, len(args))
+	}
+	result, e := fn.Call(args)
+	if e != nil {
+		return "error: " + e.Error()
+	}
+	s, se := result.ToString()
+	if se != nil {
+		t.Fatalf("%s: %s", name, se.Message())
+	}
+	return system.TypeName(result) + " " + s
+}
+
+func describe(args []system.Value) string {
+	s, _ := system.NewList(args).ToString()
+	return strings.Trim(s, "[]")
+}
+
+type builtinTest struct {
+	name string
+	args []system.Value
+	want string
+}
+
+func testBuiltins(t *testing.T, tests []builtinTest) {
+	for _, test := range tests {
+		if got := call(t, test.name, test.args...); got != test.want {
+			t.Errorf("%s{%s}: got %q, want %q", test.name, describe(test.args), got, test.want)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinTest{
+		{"len", []system.Value{str("héllo")}, "num 5"},
+		{"len", []system.Value{list(num(1), num(2))}, "num 2"},
+		{"len", []system.Value{num(1)}, "error: argument 1 expects str, list or map, got num"},
+		{"substr", []system.Value{str("héllo"), num(1)}, "str éllo"},
+		{"substr", []system.Value{str("héllo"), num(1), num(2)}, "str él"},
+		{"substr", []system.Value{str("abc"), num(5)}, "str "},
+		{"substr", []system.Value{str("abc"), num(1), num(9)}, "str bc"},
+		{"substr", []system.Value{str("abc"), num(-1)}, "error: argument 2 expects a whole number of at least 0, got -1"},
+		{"substr", []system.Value{str("abc"), num(1.5)}, "error: argument 2 expects a whole number of at least 0, got 1.5"},
+		{"substr", []system.Value{str("abc"), num(0), num(-2)}, "error: argument 3 expects a whole number of at least 0, got -2"},
+		{"substr", []system.Value{num(1), num(0)}, "error: argument 1 expects str, got num"},
+		{"upper", []system.Value{str("aB")}, "str AB"},
+		{"lower", []system.Value{str("aB")}, "str ab"},
+		{"trim", []system.Value{str(" a b \n")}, "str a b"},
+		{"upper", []system.Value{num(1)}, "error: argument 1 expects str, got num"},
+		{"split", []system.Value{str("a,b,,c"), str(",")}, "list [a, b, , c]"},
+		{"split", []system.Value{str("a"), num(1)}, "error: argument 2 expects str, got num"},
+		{"replace", []system.Value{str("a-b-c"), str("-"), str("+")}, "str a+b+c"},
+		{"replace", []system.Value{str("a"), str("a"), num(1)}, "error: argument 3 expects str, got num"},
+		{"index", []system.Value{str("héllo"), str("l")}, "num 2"},
+		{"index", []system.Value{str("abc"), str("z")}, "num -1"},
+	})
+}
+
+func TestMathBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinTest{
+		{"abs", []system.Value{num(-2.5)}, "num 2.5"},
+		{"floor", []system.Value{num(-2.5)}, "num -3"},
+		{"ceil", []system.Value{num(2.1)}, "num 3"},
+		{"round", []system.Value{num(2.5)}, "num 3"},
+		{"sqrt", []system.Value{num(16)}, "num 4"},
+		{"sqrt", []system.Value{str("16")}, "error: argument 1 expects num, got str"},
+		{"min", []system.Value{num(3), num(-1), num(2)}, "num -1"},
+		{"max", []system.Value{num(3), num(-1), num(2)}, "num 3"},
+		{"max", []system.Value{num(3)}, "num 3"},
+		{"min", []system.Value{num(3), str("a")}, "error: argument 2 expects num, got str"},
+		{"pow", []system.Value{num(2), num(10)}, "num 1024"},
+		{"pow", []system.Value{num(2), str("a")}, "error: argument 2 expects num, got str"},
+	})
+}
+
+func TestConversionBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinTest{
+		{"toNumber", []system.Value{str(" 2.5 ")}, "num 2.5"},
+		{"toNumber", []system.Value{num(3)}, "num 3"},
+		{"toNumber", []system.Value{str("x")}, "error: cannot convert \"x\" to a number"},
+		{"toNumber", []system.Value{list()}, "error: argument 1 expects num or str, got list"},
+		{"toString", []system.Value{num(2.5)}, "str 2.5"},
+		{"toString", []system.Value{list(num(1), str("a"))}, "str [1, a]"},
+		{"typeOf", []system.Value{num(1)}, "str num"},
+		{"typeOf", []system.Value{str("a")}, "str str"},
+		{"typeOf", []system.Value{list()}, "str list"},
+	})
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system
+
+import (
+	"github.com/rkophs/presta/err"
+)
+
+/* The values passed to and returned from host functions */
+type Value = StackEntry
+
+/* A Go function of the host application which scripts call like one of
+ * their own, as name{args} */
+type HostFunction struct {
+	name    string
+	params  Params
+	fn      func(args []Value) (Value, error)
+	pattern int //Index of the regular expression argument, -1 for none
+}
+
+func (h *HostFunction) Name() string {
+	return h.name
+}
+
+func (h *HostFunction) Params() Params {
+	return h.params
+}
+
+func (h *HostFunction) Call(args []Value) (Value, error) {
+	return h.fn(args)
+}
+
+/* The argument holding a regular expression, which is compiled ahead of
+ * the run when the script passes a constant */
+func (h *HostFunction) PatternArg() (int, bool) {
+	return h.pattern, h.pattern >= 0
+}
+
+/* The host functions registered with a compiler and VM, on top of the
+ * builtins. Functions declared by a script, and variables, shadow host
+ * functions of the same name. */
+type Host struct {
+	fns map[string]*HostFunction
+}
+
+func NewHost() *Host {
+	return &Host{fns: make(map[string]*HostFunction)}
+}
+
+/* Register a function taking exactly arity arguments */
+func (h *Host) Register(name string, arity int, fn func(args []Value) (Value, error)) err.Error {
+	return h.RegisterParams(name, Params{Required: arity}, fn)
+}
+
+/* Register a function taking at least required arguments */
+func (h *Host) RegisterVariadic(name string, required int, fn func(args []Value) (Value, error)) err.Error {
+	return h.RegisterParams(name, Params{Required: required, Rest: true}, fn)
+}
+
+/* Register a function with optional arguments, which are left out of
+ * args when the script does not pass them */
+func (h *Host) RegisterParams(name string, params Params, fn func(args []Value) (Value, error)) err.Error {
+	if _, ok := h.fns[name]; ok {
+		return err.NewSymanticError("Host function '" + name + "' is already registered")
+	} else if fn == nil {
+		return err.NewSymanticError("Host function '" + name + "' has no implementation")
+	}
+	h.fns[name] = &HostFunction{name: name, params: params, fn: fn, pattern: -1}
+	return nil
+}
+
+/* The function registered under name, or else the builtin of that name.
+ * A nil host has only the builtins. */
+func (h *Host) Lookup(name string) (*HostFunction, bool) {
+	if h != nil {
+		if fn, ok := h.fns[name]; ok {
+			return fn, true
+		}
+	}
+	return Builtin(name)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system_test
+
+import (
+	"github.com/rkophs/presta/system"
+	"testing"
+)
+
+func identity(args []system.Value) (system.Value, error) {
+	return args[0], nil
+}
+
+func TestHostRegister(t *testing.T) {
+	h := system.NewHost()
+	if e := h.Register("id", 1, identity); e != nil {
+		t.Fatal(e.Message())
+	}
+	if e := h.RegisterVariadic("all", 1, identity); e != nil {
+		t.Fatal(e.Message())
+	}
+	if e := h.RegisterParams("opt", system.Params{Required: 1, Optional: 2}, identity); e != nil {
+		t.Fatal(e.Message())
+	}
+
+	tests := []struct {
+		name   string
+		params system.Params
+	}{
+		{"id", system.Params{Required: 1}},
+		{"all", system.Params{Required: 1, Rest: true}},
+		{"opt", system.Params{Required: 1, Optional: 2}},
+	}
+	for _, test := range tests {
+		fn, ok := h.Lookup(test.name)
+		if !ok {
+			t.Errorf("%s: not found", test.name)
+		} else if fn.Name() != test.name || fn.Params() != test.params {
+			t.Errorf("%s: got %s with %+v, want %+v", test.name, fn.Name(), fn.Params(), test.params)
+		} else if result, e := fn.Call([]system.Value{system.NewNumber(7)}); e != nil || result.(*system.Number) == nil {
+			t.Errorf("%s: call gave %v, %v", test.name, result, e)
+		}
+	}
+}
+
+func TestHostRegisterRejects(t *testing.T) {
+	h := system.NewHost()
+	if e := h.Register("id", 1, identity); e != nil {
+		t.Fatal(e.Message())
+	}
+	if e := h.Register("id", 2, identity); e == nil || e.Message() != "Host function 'id' is already registered" {
+		t.Errorf("registered id twice, got %v", e)
+	}
+	if e := h.RegisterVariadic("none", 0, nil); e == nil || e.Message() != "Host function 'none' has no implementation" {
+		t.Errorf("registered a nil function, got %v", e)
+	}
+	if _, ok := h.Lookup("none"); ok {
+		t.Error("found a function which failed to register")
+	}
+}
+
+/* Registered functions shadow builtins, which a nil host still has */
+func TestHostLookup(t *testing.T) {
+	var none *system.Host
+	builtin, ok := none.Lookup("len")
+	if !ok {
+		t.Fatal("a nil host has no builtins")
+	}
+	if _, ok := none.Lookup("nothing"); ok {
+		t.Error("found an unknown function")
+	}
+
+	h := system.NewHost()
+	if fn, ok := h.Lookup("len"); !ok || fn != builtin {
+		t.Error("an empty host hides the builtins")
+	}
+	if e := h.Register("len", 1, identity); e != nil {
+		t.Fatal(e.Message())
+	}
+	if fn, ok := h.Lookup("len"); !ok || fn == builtin {
+		t.Error("a registered function does not shadow the builtin")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+/* Values cross JSON as: numbers, strings, lists as arrays, maps as
+ * objects and undefined as null. Booleans decode to 1 and 0, as
+ * comparisons give. Functions have no JSON form. */
+
+func parseJson(args []Value) (Value, error) {
+	s, e := stringArg(args, 0)
+	if e != nil {
+		return nil, e
+	}
+	var decoded interface{}
+	if e := json.Unmarshal([]byte(s), &decoded); e != nil {
+		return nil, errors.New("invalid JSON: " + e.Error())
+	}
+	return fromJson(decoded), nil
+}
+
+func fromJson(decoded interface{}) Value {
+	switch v := decoded.(type) {
+	case float64:
+		return NewNumber(v)
+	case string:
+		return NewString(v)
+	case bool:
+		if v {
+			return NewNumber(1)
+		}
+		return NewNumber(0)
+	case []interface{}:
+		entries := make([]StackEntry, len(v))
+		for i, elem := range v {
+			entries[i] = fromJson(elem)
+		}
+		return NewList(entries)
+	case map[string]interface{}:
+		entries := make(map[string]StackEntry, len(v))
+		for key, elem := range v {
+			entries[key] = fromJson(elem)
+		}
+		return NewMap(entries)
+	}
+	return NewUndefined()
+}
+
+func toJson(args []Value) (Value, error) {
+	encodable, e := encodable(args[0])
+	if e != nil {
+		return nil, e
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if e := encoder.Encode(encodable); e != nil {
+		return nil, e
+	}
+	return NewString(string(bytes.TrimRight(buffer.Bytes(), "\n"))), nil
+}
+
+/* The Go value encoding/json encodes the same as v */
+func encodable(v Value) (interface{}, error) {
+	switch entry := v.(type) {
+	case *Number:
+		if math.IsNaN(entry.number) || math.IsInf(entry.number, 0) {
+			return nil, errors.New("cannot encode " + strconv.FormatFloat(entry.number, 'g', -1, 64) + " as JSON")
+		}
+		return entry.number, nil
+	case *String:
+		return entry.str, nil
+	case *List:
+		elems := make([]interface{}, len(entry.entries))
+		for i, elem := range entry.entries {
+			encoded, e := encodable(elem)
+			if e != nil {
+				return nil, e
+			}
+			elems[i] = encoded
+		}
+		return elems, nil
+	case *Map:
+		elems := make(map[string]interface{}, len(entry.entries))
+		for key, elem := range entry.entries {
+			encoded, e := encodable(elem)
+			if e != nil {
+				return nil, e
+			}
+			elems[key] = encoded
+		}
+		return elems, nil
+	case *Undefined, nil:
+		return nil, nil
+	}
+	return nil, errors.New("cannot encode " + TypeName(v) + " as JSON")
+}
+
+/* The entry of a map by key or of a list by position, undefined when
+ * there is none */
+func get(args []Value) (Value, error) {
+	switch v := args[0].(type) {
+	case *Map:
+		key, e := stringArg(args, 1)
+		if e != nil {
+			return nil, e
+		}
+		if entry, ok := v.Get(key); ok {
+			return entry, nil
+		}
+		return NewUndefined(), nil
+	case *List:
+		i, e := countArg(args, 1)
+		if e != nil {
+			return nil, e
+		}
+		if i < len(v.entries) {
+			return v.entries[i], nil
+		}
+		return NewUndefined(), nil
+	}
+	return nil, argError(0, "map or list", args[0])
+}
+
+func keys(args []Value) (Value, error) {
+	if m, ok := args[0].(*Map); ok {
+		return stringList(m.Keys()), nil
+	}
+	return nil, argError(0, "map", args[0])
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system_test
+
+import (
+	"github.com/rkophs/presta/system"
+	"math"
+	"testing"
+)
+
+func TestParseJson(t *testing.T) {
+	testBuiltins(t, []builtinTest{
+		{"parseJson", []system.Value{str("2.5")}, "num 2.5"},
+		{"parseJson", []system.Value{str(`"aé"`)}, "str aé"},
+		{"parseJson", []system.Value{str("[1, \"a\", [2]]")}, "list [1, a, [2]]"},
+		{"parseJson", []system.Value{str(`{"b": 2, "a": {"c": [1]}}`)}, "map {a: {c: [1]}, b: 2}"},
+		{"parseJson", []system.Value{str("null")}, "undefined undefined"},
+		{"parseJson", []system.Value{str("[true, false]")}, "list [1, 0]"},
+		{"parseJson", []system.Value{str("[1,")}, "error: invalid JSON: unexpected end of JSON input"},
+		{"parseJson", []system.Value{num(1)}, "error: argument 1 expects str, got num"},
+	})
+}
+
+func TestToJson(t *testing.T) {
+	m := system.NewMap(map[string]system.StackEntry{"b": num(1), "a": list(str("<x>"), system.NewUndefined())})
+	testBuiltins(t, []builtinTest{
+		{"toJson", []system.Value{num(2.5)}, `str 2.5`},
+		{"toJson", []system.Value{str("a\"b")}, `str "a\"b"`},
+		{"toJson", []system.Value{m}, `str {"a":["<x>",null],"b":1}`},
+		{"toJson", []system.Value{system.NewUndefined()}, `str null`},
+		{"toJson", []system.Value{num(math.NaN())}, `error: cannot encode NaN as JSON`},
+		{"toJson", []system.Value{list(num(math.Inf(1)))}, `error: cannot encode +Inf as JSON`},
+		{"toJson", []system.Value{system.NewFunction(0, system.Params{}, 0)}, `error: cannot encode fn as JSON`},
+	})
+}
+
+func TestGet(t *testing.T) {
+	m := system.NewMap(map[string]system.StackEntry{"a": num(1)})
+	l := list(str("x"), str("y"))
+	testBuiltins(t, []builtinTest{
+		{"get", []system.Value{m, str("a")}, "num 1"},
+		{"get", []system.Value{m, str("b")}, "undefined undefined"},
+		{"get", []system.Value{m, num(0)}, "error: argument 2 expects str, got num"},
+		{"get", []system.Value{l, num(1)}, "str y"},
+		{"get", []system.Value{l, num(2)}, "undefined undefined"},
+		{"get", []system.Value{l, num(-1)}, "error: argument 2 expects a whole number of at least 0, got -1"},
+		{"get", []system.Value{l, num(0.5)}, "error: argument 2 expects a whole number of at least 0, got 0.5"},
+		{"get", []system.Value{str("a"), num(0)}, "error: argument 1 expects map or list, got str"},
+		{"keys", []system.Value{m}, "list [a]"},
+		{"keys", []system.Value{l}, "error: argument 1 expects map, got list"},
+	})
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+/* Patterns kept compiled at once. The least recently used are dropped
+ * first, to be compiled again when next used */
+const PATTERN_CACHE_SIZE = 256
+
+/* Compiled regular expressions shared by every compile and run, constants
+ * being added when the script is compiled */
+var patterns = &patternCache{entries: make(map[string]*list.Element), order: list.New()}
+
+type patternCache struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List //Most recently used first
+}
+
+type cachedPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+/* The compiled pattern, compiling and caching it if it is not cached */
+func (c *patternCache) get(pattern string) (*regexp.Regexp, error) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*cachedPattern).re, nil
+	}
+
+	re, e := regexp.Compile(pattern)
+	if e != nil {
+		return nil, e
+	}
+	c.entries[pattern] = c.order.PushFront(&cachedPattern{pattern: pattern, re: re})
+	if c.order.Len() > PATTERN_CACHE_SIZE {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedPattern).pattern)
+	}
+	return re, nil
+}
+
+/* Compile a constant pattern ahead of the runs using it */
+func CachePattern(pattern string) error {
+	_, e := patterns.get(pattern)
+	return e
+}
+
+/* The compiled pattern argument i */
+func patternArg(args []Value, i int) (*regexp.Regexp, error) {
+	pattern, e := stringArg(args, i)
+	if e != nil {
+		return nil, e
+	}
+	return patterns.get(pattern)
+}
+
+/* The string and compiled pattern every regular expression builtin
+ * starts with */
+func subjectAndPattern(args []Value) (string, *regexp.Regexp, error) {
+	s, e := stringArg(args, 0)
+	if e != nil {
+		return "", nil, e
+	}
+	re, e := patternArg(args, 1)
+	return s, re, e
+}
+
+/* 1 when the pattern matches anywhere in the string, else 0 */
+func match(args []Value) (Value, error) {
+	s, re, e := subjectAndPattern(args)
+	if e != nil {
+		return nil, e
+	} else if re.MatchString(s) {
+		return NewNumber(1), nil
+	}
+	return NewNumber(0), nil
+}
+
+/* The first match, empty when there is none */
+func find(args []Value) (Value, error) {
+	s, re, e := subjectAndPattern(args)
+	if e != nil {
+		return nil, e
+	}
+	return NewString(re.FindString(s)), nil
+}
+
+func findAll(args []Value) (Value, error) {
+	s, re, e := subjectAndPattern(args)
+	if e != nil {
+		return nil, e
+	}
+	return stringList(re.FindAllString(s, -1)), nil
+}
+
+/* The first match followed by its capture groups, empty when there is no
+ * match. Groups which did not take part in the match are empty. */
+func groups(args []Value) (Value, error) {
+	s, re, e := subjectAndPattern(args)
+	if e != nil {
+		return nil, e
+	}
+	return stringList(re.FindStringSubmatch(s)), nil
+}
+
+/* Replace every match, the replacement referring to groups as $1 or
+ * ${name} */
+func replaceMatches(args []Value) (Value, error) {
+	s, re, e := subjectAndPattern(args)
+	if e != nil {
+		return nil, e
+	}
+	replacement, e := stringArg(args, 2)
+	if e != nil {
+		return nil, e
+	}
+	return NewString(re.ReplaceAllString(s, replacement)), nil
+}
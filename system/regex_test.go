@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package system
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPatternCacheIsBounded(t *testing.T) {
+	for i := 0; i < PATTERN_CACHE_SIZE+10; i++ {
+		if e := CachePattern("a{" + strconv.Itoa(i) + "}"); e != nil {
+			t.Fatal(e)
+		}
+		//Kept in use, so never the least recently used
+		if _, e := patterns.get("b+"); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if got := len(patterns.entries); got != PATTERN_CACHE_SIZE || patterns.order.Len() != PATTERN_CACHE_SIZE {
+		t.Errorf("cached %d patterns, want %d", got, PATTERN_CACHE_SIZE)
+	}
+	if _, ok := patterns.entries["a{0}"]; ok {
+		t.Errorf("kept the least recently used pattern")
+	}
+	for _, pattern := range []string{"b+", "a{" + strconv.Itoa(PATTERN_CACHE_SIZE+9) + "}"} {
+		if _, ok := patterns.entries[pattern]; !ok {
+			t.Errorf("dropped the recently used pattern %s", pattern)
+		}
+	}
+	if e := CachePattern("("); e == nil {
+		t.Errorf("cached an invalid pattern")
+	}
+}
+
+/* replace replaces text, replaceMatches a pattern */
+func TestReplaceAndReplaceMatches(t *testing.T) {
+	args := []Value{NewString("a.b.c"), NewString("."), NewString("-")}
+	if got, e := replace(args); e != nil || got.(*String).str != "a-b-c" {
+		t.Errorf("replace: got %v, %v", got, e)
+	}
+	if got, e := replaceMatches(args); e != nil || got.(*String).str != "-----" {
+		t.Errorf("replaceMatches: got %v, %v", got, e)
+	}
+	args = []Value{NewString("x=1 y=2"), NewString(`(\w)=(\d)`), NewString("$2=$1")}
+	if got, e := replaceMatches(args); e != nil || got.(*String).str != "1=x 2=y" {
+		t.Errorf("replaceMatches: got %v, %v", got, e)
+	}
+}
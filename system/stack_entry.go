@@ -25,10 +25,12 @@
 package system
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"github.com/rkophs/presta/err"
 	"math"
+	"sort"
 	"strconv"
 )
 
@@ -95,3 +97,257 @@ func (s *String) ToHex() (string, err.Error) {
 func (s *String) Clone() StackEntry {
 	return NewString(s.str)
 }
+
+/* The shape of a function's argument area: required params, optional
+ * params with default values and an optional trailing rest param which
+ * collects any extra arguments into a list */
+type Params struct {
+	Required int
+	Optional int
+	Rest     bool
+}
+
+/* Number of argument slots the callee reads */
+func (p Params) Count() int {
+	if p.Rest {
+		return p.Required + p.Optional + 1
+	}
+	return p.Required + p.Optional
+}
+
+func (p Params) Accepts(argc int) bool {
+	return argc >= p.Required && (p.Rest || argc <= p.Required+p.Optional)
+}
+
+/* The argument counts accepted, as in "at least 1 argument" */
+func (p Params) Describe() string {
+	if p.Rest {
+		return "at least " + Arguments(p.Required)
+	} else if p.Optional == 0 {
+		return Arguments(p.Required)
+	}
+	return "between " + strconv.Itoa(p.Required) + " and " + Arguments(p.Required+p.Optional)
+}
+
+/* A count of arguments, as in "1 argument" or "2 arguments" */
+func Arguments(count int) string {
+	if count == 1 {
+		return "1 argument"
+	}
+	return strconv.Itoa(count) + " arguments"
+}
+
+/* A callable value: the entry offset of the function body, its params and
+ * the heap address of the environment holding its captured variables. */
+type Function struct {
+	offset int
+	params Params
+	env    int
+}
+
+func NewFunction(offset int, params Params, env int) *Function {
+	return &Function{offset: offset, params: params, env: env}
+}
+
+func (f *Function) Offset() int {
+	return f.offset
+}
+
+func (f *Function) Params() Params {
+	return f.params
+}
+
+func (f *Function) Env() int {
+	return f.env
+}
+
+func (f *Function) ToNumber() (float64, err.Error) {
+	return -1, err.NewRuntimeError("function type not convertable to number.")
+}
+
+func (f *Function) ToString() (string, err.Error) {
+	return "function@0x" + strconv.FormatInt(int64(f.offset), 16), nil
+}
+
+func (f *Function) ToHex() (string, err.Error) {
+	bytes := make([]byte, 33)
+	binary.LittleEndian.PutUint64(bytes[0:], uint64(f.offset))
+	binary.LittleEndian.PutUint64(bytes[8:], uint64(f.params.Required))
+	binary.LittleEndian.PutUint64(bytes[16:], uint64(f.params.Optional))
+	binary.LittleEndian.PutUint64(bytes[24:], uint64(f.env))
+	if f.params.Rest {
+		bytes[32] = 1
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func (f *Function) Clone() StackEntry {
+	return NewFunction(f.offset, f.params, f.env)
+}
+
+type List struct {
+	entries []StackEntry
+}
+
+func NewList(entries []StackEntry) *List {
+	return &List{entries: entries}
+}
+
+func (l *List) Entries() []StackEntry {
+	return l.entries
+}
+
+func (l *List) ToNumber() (float64, err.Error) {
+	return -1, err.NewRuntimeError("list type not convertable to number.")
+}
+
+func (l *List) ToString() (string, err.Error) {
+	var buffer bytes.Buffer
+	buffer.WriteRune('[')
+	for i, entry := range l.entries {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		str, e := entry.ToString()
+		if e != nil {
+			return "", e
+		}
+		buffer.WriteString(str)
+	}
+	buffer.WriteRune(']')
+	return buffer.String(), nil
+}
+
+func (l *List) ToHex() (string, err.Error) {
+	var buffer bytes.Buffer
+	for _, entry := range l.entries {
+		str, e := entry.ToHex()
+		if e != nil {
+			return "", e
+		}
+		buffer.WriteString(str)
+	}
+	return buffer.String(), nil
+}
+
+func (l *List) Clone() StackEntry {
+	entries := make([]StackEntry, len(l.entries))
+	for i, entry := range l.entries {
+		entries[i] = entry.Clone()
+	}
+	return NewList(entries)
+}
+
+/* A string keyed map, built by decoding JSON objects. Keys are listed in
+ * sorted order. */
+type Map struct {
+	entries map[string]StackEntry
+}
+
+func NewMap(entries map[string]StackEntry) *Map {
+	return &Map{entries: entries}
+}
+
+func (m *Map) Get(key string) (StackEntry, bool) {
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+func (m *Map) Len() int {
+	return len(m.entries)
+}
+
+func (m *Map) Keys() []string {
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *Map) ToNumber() (float64, err.Error) {
+	return -1, err.NewRuntimeError("map type not convertable to number.")
+}
+
+func (m *Map) ToString() (string, err.Error) {
+	var buffer bytes.Buffer
+	buffer.WriteRune('{')
+	for i, key := range m.Keys() {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		str, e := m.entries[key].ToString()
+		if e != nil {
+			return "", e
+		}
+		buffer.WriteString(key)
+		buffer.WriteString(": ")
+		buffer.WriteString(str)
+	}
+	buffer.WriteRune('}')
+	return buffer.String(), nil
+}
+
+func (m *Map) ToHex() (string, err.Error) {
+	var buffer bytes.Buffer
+	for _, key := range m.Keys() {
+		str, e := m.entries[key].ToHex()
+		if e != nil {
+			return "", e
+		}
+		buffer.WriteString(hex.EncodeToString([]byte(key)))
+		buffer.WriteString(str)
+	}
+	return buffer.String(), nil
+}
+
+func (m *Map) Clone() StackEntry {
+	entries := make(map[string]StackEntry, len(m.entries))
+	for key, entry := range m.entries {
+		entries[key] = entry.Clone()
+	}
+	return NewMap(entries)
+}
+
+/* Fills the slot of an optional param the caller did not pass, until the
+ * callee evaluates its default value */
+type Undefined struct{}
+
+func NewUndefined() *Undefined {
+	return &Undefined{}
+}
+
+func (u *Undefined) ToNumber() (float64, err.Error) {
+	return -1, err.NewRuntimeError("undefined value not convertable to number.")
+}
+
+func (u *Undefined) ToString() (string, err.Error) {
+	return "undefined", nil
+}
+
+func (u *Undefined) ToHex() (string, err.Error) {
+	return "", nil
+}
+
+func (u *Undefined) Clone() StackEntry {
+	return u
+}
+
+/* The name of an entry's type, as written in type annotations */
+func TypeName(entry StackEntry) string {
+	switch entry.(type) {
+	case *Number:
+		return "num"
+	case *String:
+		return "str"
+	case *List:
+		return "list"
+	case *Map:
+		return "map"
+	case *Function:
+		return "fn"
+	default:
+		return "undefined"
+	}
+}
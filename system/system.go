@@ -32,9 +32,13 @@ type System interface {
 	SetS(offset int, entry StackEntry)
 	SetM(memAddr int, entry StackEntry)
 	SetR(id int, entry StackEntry)
+	Alloc(size int) int
 	Release(addr int)
 	Goto(offset int)
-	Call(offset int)
+	Call(fn *Function, argc int)
+	TailCall(fn *Function, argc int, frameArgs int)
+	CallHost(name string, argc int)
+	Shrink(offset int)
 	Return(result StackEntry)
 	Exit(result StackEntry)
 	SetError(e string)
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package types
+
+/* The type of a builtin of the standard library, fresh for every use */
+func builtin(name string) (*Type, bool) {
+	num, str := Number(), String()
+	unary := func(param, result *Type) *Type {
+		return NewFunction([]*Type{param}, 1, nil, result)
+	}
+
+	switch name {
+	case "len", "toNumber":
+		return unary(Any(), num), true
+	case "toString", "typeOf", "toJson":
+		return unary(Any(), str), true
+	case "upper", "lower", "trim":
+		return unary(str, str), true
+	case "abs", "floor", "ceil", "round", "sqrt":
+		return unary(num, num), true
+	case "substr":
+		return NewFunction([]*Type{str, num, num}, 2, nil, str), true
+	case "split":
+		return NewFunction([]*Type{str, str}, 2, nil, NewList(str)), true
+	case "replace":
+		return NewFunction([]*Type{str, str, str}, 3, nil, str), true
+	case "index":
+		return NewFunction([]*Type{str, str}, 2, nil, num), true
+	case "match":
+		return NewFunction([]*Type{str, str}, 2, nil, num), true
+	case "find":
+		return NewFunction([]*Type{str, str}, 2, nil, str), true
+	case "findAll", "groups":
+		return NewFunction([]*Type{str, str}, 2, nil, NewList(str)), true
+	case "replaceMatches":
+		return NewFunction([]*Type{str, str, str}, 3, nil, str), true
+	case "min", "max":
+		return NewFunction([]*Type{num}, 1, num, num), true
+	case "parseJson":
+		return unary(str, Any()), true
+	case "get":
+		return NewFunction([]*Type{Any(), Any()}, 2, nil, Any()), true
+	case "keys":
+		return unary(NewMap(Any()), NewList(str)), true
+	case "pow":
+		return NewFunction([]*Type{num, num}, 2, nil, num), true
+	}
+	return nil, false
+}
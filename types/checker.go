@@ -0,0 +1,388 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package types
+
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/parser"
+)
+
+/* State of the type inference pass over an AST: scoped environments for
+ * variables and functions, the unifier and the inferred type of each node */
+type Checker struct {
+	scopes []*scope
+	ids    int
+	level  int
+	types  map[interface{}]*Type
+	errors []err.Error
+	strict bool //Infer undeclared types rather than take them as Any
+}
+
+type scope struct {
+	vars map[string]*Type
+	fns  map[string]*Type
+}
+
+/* A checker inferring the type of every node */
+func NewChecker() *Checker {
+	c := &Checker{scopes: make([]*scope, 0), ids: 0, level: 0, types: make(map[interface{}]*Type), errors: make([]err.Error, 0), strict: true}
+	c.PushScope()
+	return c
+}
+
+/* A checker holding values only to the types the program declares.
+ * Anything undeclared is Any, as it is at run time, so untyped programs
+ * check as they run */
+func NewAnnotationChecker() *Checker {
+	c := NewChecker()
+	c.strict = false
+	return c
+}
+
+func (c *Checker) Strict() bool {
+	return c.strict
+}
+
+/* The type of an annotation, or when there is none a fresh variable to
+ * infer, Any unless strict */
+func (c *Checker) Declared(annotation string) *Type {
+	if t, ok := Named(annotation); ok {
+		return t
+	} else if !c.strict {
+		return Any()
+	}
+	return c.Fresh()
+}
+
+func (c *Checker) Errors() []err.Error {
+	return c.errors
+}
+
+func (c *Checker) Errorf(pos parser.Position, msg string) {
+	c.errors = append(c.errors, err.NewTypeError(pos.String()+"\t"+msg))
+}
+
+/* Annotate a node with its inferred type and return it */
+func (c *Checker) Record(node interface{}, t *Type) *Type {
+	c.types[node] = t
+	return t
+}
+
+func (c *Checker) TypeOf(node interface{}) *Type {
+	if t, ok := c.types[node]; ok {
+		return t.Resolve()
+	}
+	return Any()
+}
+
+func (c *Checker) Fresh() *Type {
+	c.ids++
+	return &Type{kind: VAR, id: c.ids, level: c.level}
+}
+
+/*=================================================================================*/
+
+func (c *Checker) PushScope() {
+	c.scopes = append([]*scope{&scope{vars: make(map[string]*Type), fns: make(map[string]*Type)}}, c.scopes...)
+}
+
+func (c *Checker) PopScope() {
+	c.scopes = c.scopes[1:]
+}
+
+func (c *Checker) BindVariable(name string, t *Type) {
+	c.scopes[0].vars[name] = t
+}
+
+func (c *Checker) BindFunction(name string, t *Type) {
+	c.scopes[0].fns[name] = t
+}
+
+/* Variables are monomorphic. Unknown names are left to the semantic pass
+ * and typed as Any. */
+func (c *Checker) LookupVariable(name string) *Type {
+	for _, scope := range c.scopes {
+		if t, ok := scope.vars[name]; ok {
+			return t
+		}
+	}
+	return Any()
+}
+
+/* Functions are instantiated with fresh variables at each use */
+func (c *Checker) LookupFunction(name string) *Type {
+	for _, scope := range c.scopes {
+		if t, ok := scope.fns[name]; ok {
+			return c.instantiate(t, make(map[*Type]*Type))
+		}
+	}
+	if t, ok := builtin(name); ok {
+		return t
+	}
+	return Any()
+}
+
+/* The type of a name used as a value: variables come before functions,
+ * as in the semantic pass */
+func (c *Checker) LookupValue(name string) *Type {
+	for _, scope := range c.scopes {
+		if t, ok := scope.vars[name]; ok {
+			return t
+		}
+	}
+	return c.LookupFunction(name)
+}
+
+/* The type of a callee name, honoring the innermost binding like the
+ * semantic pass does */
+func (c *Checker) LookupCallee(name string) *Type {
+	for _, scope := range c.scopes {
+		if t, ok := scope.vars[name]; ok {
+			return t
+		} else if t, ok := scope.fns[name]; ok {
+			return c.instantiate(t, make(map[*Type]*Type))
+		}
+	}
+	if t, ok := builtin(name); ok {
+		return t
+	}
+	return Any()
+}
+
+/*=================================================================================*/
+
+/* Function declarations are checked one level deeper so the variables
+ * they introduce can be generalized afterwards */
+func (c *Checker) EnterLevel() {
+	c.level++
+}
+
+func (c *Checker) ExitLevel() {
+	c.level--
+}
+
+/* Mark the variables created inside the current declaration level as
+ * generic so every use of the function instantiates them afresh */
+func (c *Checker) Generalize(t *Type) {
+	t = t.Resolve()
+	switch t.kind {
+	case VAR:
+		if t.level > c.level {
+			t.generic = true
+		}
+	case LIST, MAP:
+		c.Generalize(t.elem)
+	case FUNCTION:
+		for _, param := range t.params {
+			c.Generalize(param)
+		}
+		if t.rest != nil {
+			c.Generalize(t.rest)
+		}
+		c.Generalize(t.ret)
+	}
+}
+
+func (c *Checker) instantiate(t *Type, subst map[*Type]*Type) *Type {
+	t = t.Resolve()
+	switch t.kind {
+	case VAR:
+		if !t.generic {
+			return t
+		} else if fresh, ok := subst[t]; ok {
+			return fresh
+		}
+		fresh := c.Fresh()
+		subst[t] = fresh
+		return fresh
+	case LIST:
+		return NewList(c.instantiate(t.elem, subst))
+	case MAP:
+		return NewMap(c.instantiate(t.elem, subst))
+	case FUNCTION:
+		if t.open {
+			return t
+		}
+		params := make([]*Type, len(t.params))
+		for i, param := range t.params {
+			params[i] = c.instantiate(param, subst)
+		}
+		var rest *Type
+		if t.rest != nil {
+			rest = c.instantiate(t.rest, subst)
+		}
+		return NewFunction(params, t.required, rest, c.instantiate(t.ret, subst))
+	default:
+		return t
+	}
+}
+
+/*=================================================================================*/
+
+/* Unify two types, reporting a mismatch at pos */
+func (c *Checker) Expect(pos parser.Position, got *Type, want *Type, what string) {
+	if !c.Unify(got, want) {
+		c.Errorf(pos, what+" expects "+want.String()+", got "+got.String())
+	}
+}
+
+func (c *Checker) Unify(a, b *Type) bool {
+	a = a.Resolve()
+	b = b.Resolve()
+	if a == b || a.kind == ANY || b.kind == ANY {
+		return true
+	} else if a.kind == VAR {
+		return c.bind(a, b)
+	} else if b.kind == VAR {
+		return c.bind(b, a)
+	} else if a.kind != b.kind {
+		return false
+	}
+
+	switch a.kind {
+	case LIST, MAP:
+		return c.Unify(a.elem, b.elem)
+	case FUNCTION:
+		if a.open || b.open {
+			return true
+		} else if len(a.params) != len(b.params) || a.required != b.required || (a.rest == nil) != (b.rest == nil) {
+			return false
+		}
+		for i := range a.params {
+			if !c.Unify(a.params[i], b.params[i]) {
+				return false
+			}
+		}
+		if a.rest != nil && !c.Unify(a.rest, b.rest) {
+			return false
+		}
+		return c.Unify(a.ret, b.ret)
+	default:
+		return true
+	}
+}
+
+/* Join the types of alternative results: the common type when they are
+ * the same, otherwise Any. Variables are never bound, as one branch does
+ * not constrain what another returns. */
+func (c *Checker) Join(a, b *Type) *Type {
+	ra := a.Resolve()
+	rb := b.Resolve()
+	if ra == rb {
+		return a
+	} else if ra.kind != rb.kind || ra.kind == VAR {
+		return Any()
+	}
+
+	switch ra.kind {
+	case LIST:
+		return NewList(c.Join(ra.elem, rb.elem))
+	case MAP:
+		return NewMap(c.Join(ra.elem, rb.elem))
+	case FUNCTION:
+		if c.same(ra, rb) {
+			return a
+		}
+		return AnyFunction()
+	default:
+		return a
+	}
+}
+
+/* Whether two types are the same without binding any variables */
+func (c *Checker) same(a, b *Type) bool {
+	a = a.Resolve()
+	b = b.Resolve()
+	if a == b {
+		return true
+	} else if a.kind != b.kind || a.kind == VAR {
+		return false
+	}
+
+	switch a.kind {
+	case LIST, MAP:
+		return c.same(a.elem, b.elem)
+	case FUNCTION:
+		if a.open || b.open || len(a.params) != len(b.params) || a.required != b.required || (a.rest == nil) != (b.rest == nil) {
+			return a.open && b.open
+		}
+		for i := range a.params {
+			if !c.same(a.params[i], b.params[i]) {
+				return false
+			}
+		}
+		return (a.rest == nil || c.same(a.rest, b.rest)) && c.same(a.ret, b.ret)
+	default:
+		return true
+	}
+}
+
+func (c *Checker) bind(v *Type, t *Type) bool {
+	if c.occurs(v, t) {
+		return false
+	}
+	c.adjustLevels(t, v.level)
+	v.ref = t
+	return true
+}
+
+func (c *Checker) occurs(v *Type, t *Type) bool {
+	t = t.Resolve()
+	switch t.kind {
+	case VAR:
+		return t == v
+	case LIST, MAP:
+		return c.occurs(v, t.elem)
+	case FUNCTION:
+		for _, param := range t.params {
+			if c.occurs(v, param) {
+				return true
+			}
+		}
+		return (t.rest != nil && c.occurs(v, t.rest)) || c.occurs(v, t.ret)
+	default:
+		return false
+	}
+}
+
+func (c *Checker) adjustLevels(t *Type, level int) {
+	t = t.Resolve()
+	switch t.kind {
+	case VAR:
+		if t.level > level {
+			t.level = level
+		}
+	case LIST, MAP:
+		c.adjustLevels(t.elem, level)
+	case FUNCTION:
+		for _, param := range t.params {
+			c.adjustLevels(param, level)
+		}
+		if t.rest != nil {
+			c.adjustLevels(t.rest, level)
+		}
+		c.adjustLevels(t.ret, level)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package types
+
+import (
+	"bytes"
+	"strconv"
+)
+
+type Kind int64
+
+const (
+	ANY Kind = iota
+	NUMBER
+	STRING
+	LIST
+	MAP
+	FUNCTION
+	VAR
+)
+
+/* A type inferred for an expression. Any is the dynamic type which is
+ * compatible with everything, so untyped code is accepted as is. */
+type Type struct {
+	kind     Kind
+	elem     *Type   //LIST element, MAP value
+	params   []*Type //FUNCTION required then optional params
+	required int     //FUNCTION
+	rest     *Type   //FUNCTION rest list element, nil if none
+	ret      *Type   //FUNCTION result
+	id       int     //VAR
+	level    int     //VAR let depth it was created at
+	ref      *Type   //VAR binding once unified
+	generic  bool    //VAR generalized in a function scheme
+	open     bool    //FUNCTION of unknown signature
+}
+
+var (
+	anyType    = &Type{kind: ANY}
+	numberType = &Type{kind: NUMBER}
+	stringType = &Type{kind: STRING}
+	fnType     = &Type{kind: FUNCTION, open: true, ret: anyType}
+)
+
+/* The type written as an annotation name, if it is one */
+func Named(name string) (*Type, bool) {
+	switch name {
+	case "any":
+		return Any(), true
+	case "num":
+		return Number(), true
+	case "str":
+		return String(), true
+	case "list":
+		return NewList(Any()), true
+	case "map":
+		return NewMap(Any()), true
+	case "fn":
+		return AnyFunction(), true
+	}
+	return nil, false
+}
+
+func Any() *Type {
+	return anyType
+}
+
+func Number() *Type {
+	return numberType
+}
+
+func String() *Type {
+	return stringType
+}
+
+/* Any function, whatever its params and result */
+func AnyFunction() *Type {
+	return fnType
+}
+
+func NewList(elem *Type) *Type {
+	return &Type{kind: LIST, elem: elem}
+}
+
+/* A map of string keys to values of type elem */
+func NewMap(elem *Type) *Type {
+	return &Type{kind: MAP, elem: elem}
+}
+
+func NewFunction(params []*Type, required int, rest *Type, ret *Type) *Type {
+	return &Type{kind: FUNCTION, params: params, required: required, rest: rest, ret: ret}
+}
+
+/* Follow variable bindings to the type they stand for */
+func (t *Type) Resolve() *Type {
+	for t.kind == VAR && t.ref != nil {
+		t = t.ref
+	}
+	return t
+}
+
+func (t *Type) Kind() Kind {
+	return t.Resolve().kind
+}
+
+func (t *Type) Elem() *Type {
+	return t.Resolve().elem
+}
+
+func (t *Type) Params() []*Type {
+	return t.Resolve().params
+}
+
+func (t *Type) Required() int {
+	return t.Resolve().required
+}
+
+func (t *Type) Rest() *Type {
+	return t.Resolve().rest
+}
+
+func (t *Type) Result() *Type {
+	return t.Resolve().ret
+}
+
+func (t *Type) Open() bool {
+	return t.Resolve().open
+}
+
+/* Whether the type is fully known, without variables or Any */
+func (t *Type) Concrete() bool {
+	t = t.Resolve()
+	switch t.kind {
+	case NUMBER, STRING:
+		return true
+	case LIST, MAP:
+		return t.elem.Concrete()
+	case FUNCTION:
+		if t.open {
+			return false
+		}
+		for _, param := range t.params {
+			if !param.Concrete() {
+				return false
+			}
+		}
+		return (t.rest == nil || t.rest.Concrete()) && t.ret.Concrete()
+	default:
+		return false
+	}
+}
+
+func (t *Type) String() string {
+	var buffer bytes.Buffer
+	t.write(&buffer)
+	return buffer.String()
+}
+
+func (t *Type) write(buffer *bytes.Buffer) {
+	t = t.Resolve()
+	switch t.kind {
+	case ANY:
+		buffer.WriteString("any")
+	case NUMBER:
+		buffer.WriteString("num")
+	case STRING:
+		buffer.WriteString("str")
+	case LIST:
+		buffer.WriteString("list[")
+		t.elem.write(buffer)
+		buffer.WriteRune(']')
+	case MAP:
+		buffer.WriteString("map[")
+		t.elem.write(buffer)
+		buffer.WriteRune(']')
+	case FUNCTION:
+		if t.open {
+			buffer.WriteString("fn")
+			return
+		}
+		buffer.WriteString("fn(")
+		for i, param := range t.params {
+			if i > 0 {
+				buffer.WriteRune(' ')
+			}
+			param.write(buffer)
+			if i >= t.required {
+				buffer.WriteRune('?')
+			}
+		}
+		if t.rest != nil {
+			if len(t.params) > 0 {
+				buffer.WriteRune(' ')
+			}
+			buffer.WriteString("...")
+			t.rest.write(buffer)
+		}
+		buffer.WriteString(")")
+		t.ret.write(buffer)
+	case VAR:
+		buffer.WriteString("t")
+		buffer.WriteString(strconv.Itoa(t.id))
+	}
+}
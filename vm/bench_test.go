@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm_test
+
+import (
+	"github.com/rkophs/presta"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/vm"
+	"strings"
+	"testing"
+)
+
+/* Compile a program once, then time it in the dispatch loop and in the
+ * interpreter */
+func benchmark(b *testing.B, src string) {
+	instrs, e := presta.CompileWithOptions(strings.NewReader(src), presta.Options{})
+	if e != nil {
+		b.Fatal(e.Message())
+	}
+	paths := []struct {
+		name string
+		run  func(v *vm.VM) err.Error
+	}{
+		{"Run", (*vm.VM).Run},
+		{"Interpret", (*vm.VM).Interpret},
+	}
+	for _, path := range paths {
+		b.Run(path.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if e := path.run(vm.NewVM(instrs)); e != nil {
+					b.Fatal(e.Message())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRecursion(b *testing.B) {
+	benchmark(b, "~fib(n)( |(< n 2 n 1 + fib{- n 1} fib{- n 2}) ) fib{20}")
+}
+
+func BenchmarkTailCalls(b *testing.B) {
+	benchmark(b, "~lp(n acc)( |(== n 0 acc 1 lp{- n 1 + acc n}) ) lp{100000 0}")
+}
+
+func BenchmarkClosures(b *testing.B) {
+	benchmark(b, "~lp(n acc)( :(f)(~(x)(+ x n)) |(== n 0 acc 1 lp{- n 1 f{acc}}) ) lp{10000 0}")
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm
+
+import (
+	"github.com/rkophs/presta/system"
+)
+
+/* The heap holds closure environments. An environment no function value
+ * on the stack, in the registers or in another live environment refers
+ * to is collected once the heap has doubled since the last collection,
+ * and before a run is stopped for exceeding its heap limit. Memory the
+ * program addresses directly must stay in reach of a function value. */
+const COLLECT_THRESHOLD = 1024
+
+/* Allocate an environment, collecting the heap first when it is due */
+func (v *VM) alloc(size int) int {
+	if len(v.heap.heap)+size > v.nextCollect {
+		live := v.collect()
+		v.nextCollect = 2 * live
+		if v.nextCollect < COLLECT_THRESHOLD {
+			v.nextCollect = COLLECT_THRESHOLD
+		}
+	}
+	return v.heap.Alloc(size)
+}
+
+/* Free the environments out of reach of the program, returning the
+ * number of heap entries left */
+func (v *VM) collect() int {
+	live := make(map[int]bool)
+	pending := []system.StackEntry{}
+	for _, roots := range [][]value{v.stack.stack, v.registers, v.saved} {
+		for _, root := range roots {
+			if !root.number && root.entry != nil {
+				pending = append(pending, root.entry)
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		entry := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		switch e := entry.(type) {
+		case *system.Function:
+			size, ok := v.heap.blocks[e.Env()]
+			if !ok || live[e.Env()] {
+				continue
+			}
+			live[e.Env()] = true
+			for addr := e.Env(); addr < e.Env()+size; addr++ {
+				if x, ok := v.heap.heap[addr]; ok && !x.number && x.entry != nil {
+					pending = append(pending, x.entry)
+				}
+			}
+		case *system.List:
+			pending = append(pending, e.Entries()...)
+		case *system.Map:
+			for _, key := range e.Keys() {
+				value, _ := e.Get(key)
+				pending = append(pending, value)
+			}
+		}
+	}
+
+	v.heap.sweep(live)
+	return len(v.heap.heap)
+}
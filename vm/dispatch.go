@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm
+
+import (
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/system"
+	"math"
+	"strconv"
+)
+
+/* Operands of the dispatch loop are read straight from the VM state, as
+ * the kind and index word pairs of the flat encoding (see ir.Flat) */
+func (v *VM) load(kind, n int) value {
+	switch kind {
+	case ir.ACCESS_STACK:
+		return v.fetchS(n)
+	case ir.ACCESS_REGISTER:
+		if n < len(v.registers) {
+			return v.registers[n]
+		}
+		v.SetError("Register %" + strconv.Itoa(n) + " is outside the register file")
+	case ir.ACCESS_CONSTANT:
+		return v.constants[n]
+	case ir.ACCESS_MEMORY:
+		return v.fetchM(n)
+	case ir.ACCESS_ENV:
+		if addr, ok := v.env(n); ok {
+			return v.fetchM(addr)
+		}
+	}
+	return value{entry: undefined}
+}
+
+func (v *VM) store(kind, n int, x value) {
+	switch kind {
+	case ir.ACCESS_STACK:
+		v.setS(n, x)
+	case ir.ACCESS_REGISTER:
+		if n < len(v.registers) {
+			v.registers[n] = x
+		} else {
+			v.SetError("Register %" + strconv.Itoa(n) + " is outside the register file")
+		}
+	case ir.ACCESS_CONSTANT:
+		v.SetError("Cannot reasign a constant accessor.")
+	case ir.ACCESS_MEMORY:
+		v.setM(n, x)
+	case ir.ACCESS_ENV:
+		if addr, ok := v.env(n); ok {
+			v.setM(addr, x)
+		}
+	}
+}
+
+/* Heap address of a captured variable of the running closure */
+func (v *VM) env(index int) (int, bool) {
+	if fn, ok := v.fetchS(0).entry.(*system.Function); ok {
+		return fn.Env() + index, true
+	}
+	v.SetError("Frame has no closure environment.")
+	return -1, false
+}
+
+func (v *VM) arith(op ir.InstructionType, l, r value) (value, bool) {
+	switch op {
+	case ir.EQ:
+		return boolValue(l.equal(r)), true
+	case ir.NEQ:
+		return boolValue(!l.equal(r)), true
+	case ir.AND:
+		return boolValue(l.truthy() && r.truthy()), true
+	case ir.OR:
+		return boolValue(l.truthy() || r.truthy()), true
+	}
+
+	if !l.number || !r.number {
+		v.SetError(op.Mnemonic() + " requires 2 numbers")
+		return value{}, false
+	}
+	switch op {
+	case ir.SUB:
+		return numberValue(l.num - r.num), true
+	case ir.MULT:
+		return numberValue(l.num * r.num), true
+	case ir.DIV, ir.MOD:
+		if r.num == 0 {
+			v.SetError("Division by zero")
+			return value{}, false
+		} else if op == ir.DIV {
+			return numberValue(l.num / r.num), true
+		}
+		return numberValue(math.Mod(l.num, r.num)), true
+	case ir.LT:
+		return boolValue(l.num < r.num), true
+	case ir.LTE:
+		return boolValue(l.num <= r.num), true
+	case ir.GT:
+		return boolValue(l.num > r.num), true
+	case ir.GTE:
+		return boolValue(l.num >= r.num), true
+	}
+	v.SetError("Unsupported binary operation")
+	return value{}, false
+}
+
+/* Execute a flat program until it exits or fails. Each case reads its
+ * operands following the opcode and moves pc past them or to the target
+ * of a jump. */
+func (v *VM) dispatch(flat *ir.Flat) {
+	code, offsets := flat.Code, flat.Offsets
+	v.constants = make([]value, len(flat.Constants))
+	for i, constant := range flat.Constants {
+		v.constants[i] = unbox(constant)
+	}
+
+	pc := offsets[v.flow.pc]
+	for !v.exited && !v.interrupt && v.withinLimits() {
+		if pc < 0 || pc >= len(code) {
+			v.SetError("Program counter outside of the program")
+			break
+		}
+
+		switch op := ir.InstructionType(code[pc]); op {
+		case ir.ADD:
+			l, r := v.load(code[pc+1], code[pc+2]), v.load(code[pc+3], code[pc+4])
+			if l.number && r.number {
+				v.store(code[pc+1], code[pc+2], numberValue(l.num+r.num))
+			} else {
+				v.SetError("Addition requires 2 numbers")
+			}
+			pc += 5
+		case ir.SUB, ir.MULT, ir.DIV, ir.MOD, ir.LT, ir.LTE, ir.GT, ir.GTE, ir.EQ, ir.NEQ, ir.AND, ir.OR:
+			l, r := v.load(code[pc+1], code[pc+2]), v.load(code[pc+3], code[pc+4])
+			if result, ok := v.arith(op, l, r); ok {
+				v.store(code[pc+1], code[pc+2], result)
+			}
+			pc += 5
+		case ir.PUSH:
+			v.stack.Push(v.load(code[pc+1], code[pc+2]))
+			pc += 3
+		case ir.RELEASE:
+			v.heap.Release(code[pc+1])
+			pc += 2
+		case ir.MOV:
+			v.store(code[pc+1], code[pc+2], v.load(code[pc+3], code[pc+4]))
+			pc += 5
+		case ir.CALL:
+			fn := flat.Functions[code[pc+1]]
+			if v.enter(fn, code[pc+2]) {
+				v.flow.pushReturn(pc + 3)
+				pc = offsets[fn.Offset()]
+			}
+		case ir.CALL_INDIRECT:
+			if fn, ok := v.load(code[pc+1], code[pc+2]).entry.(*system.Function); !ok {
+				v.SetError("Value is not a function")
+			} else if v.enter(fn, code[pc+3]) {
+				v.flow.pushReturn(pc + 4)
+				pc = offsets[fn.Offset()]
+			}
+		case ir.CLOSURE:
+			n := code[pc+5]
+			env := v.alloc(n)
+			for i := 0; i < n; i++ {
+				v.setM(env+i, v.load(code[pc+6+2*i], code[pc+7+2*i]))
+			}
+			fn := system.NewFunction(code[pc+3], flat.Params[code[pc+4]], env)
+			v.store(code[pc+1], code[pc+2], value{entry: fn})
+			pc += 6 + 2*n
+		case ir.RESULT:
+			if len(v.flow.funcs) == 1 {
+				v.SetError("Return outside of a function")
+				break
+			}
+			v.leave(v.load(code[pc+1], code[pc+2]))
+			pc = v.flow.popReturn()
+		case ir.EXIT:
+			v.exit(v.load(code[pc+1], code[pc+2]))
+			pc = v.flow.popReturn()
+		case ir.GOTO:
+			pc = offsets[code[pc+1]]
+		case ir.JUMP_UNLESS:
+			if !v.load(code[pc+1], code[pc+2]).truthy() {
+				pc = offsets[code[pc+3]]
+			} else {
+				pc += 4
+			}
+		case ir.JUMP_DEFINED:
+			slot := v.load(code[pc+1], code[pc+2])
+			if _, undefined := slot.entry.(*system.Undefined); slot.number || !undefined {
+				pc = offsets[code[pc+3]]
+			} else {
+				pc += 4
+			}
+		case ir.SHRINK:
+			v.Shrink(code[pc+1])
+			pc += 2
+		case ir.TAIL_CALL:
+			fn := flat.Functions[code[pc+1]]
+			if v.reenter(fn, code[pc+2], code[pc+3]) {
+				pc = offsets[fn.Offset()]
+			}
+		case ir.TAIL_CALL_INDIRECT:
+			if fn, ok := v.load(code[pc+1], code[pc+2]).entry.(*system.Function); !ok {
+				v.SetError("Value is not a function")
+			} else if v.reenter(fn, code[pc+3], code[pc+4]) {
+				pc = offsets[fn.Offset()]
+			}
+		case ir.HOST_CALL:
+			v.CallHost(flat.Strings[code[pc+1]], code[pc+2])
+			pc += 3
+		case ir.GUARD:
+			annotation, what := flat.Strings[code[pc+3]], flat.Strings[code[pc+4]]
+			if got := v.load(code[pc+1], code[pc+2]).typeName(); annotation != "any" && got != annotation {
+				v.SetError(what + " expects " + annotation + ", got " + got)
+			}
+			pc += 5
+		default:
+			v.SetError("Unknown opcode 0x" + strconv.FormatInt(int64(op), 16))
+		}
+	}
+}
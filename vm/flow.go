@@ -40,21 +40,34 @@ func NewFlow(instr []ir.Instruction) *Flow {
 }
 
 func (f *Flow) Execute(v system.System) {
+	if f.pc < 0 || f.pc >= len(f.instr) {
+		v.SetError("Program counter outside of the program")
+		return
+	}
 	f.instr[f.pc].Execute(v)
 	f.pc++
 }
 
 func (f *Flow) Return() {
-	pc_len := (len(f.funcs) - 1)
-	f.pc = f.funcs[pc_len]
-	f.funcs = f.funcs[:pc_len]
+	f.pc = f.popReturn()
 }
 
 func (f *Flow) Call(offset int) {
-	f.funcs = append(f.funcs, f.pc)
+	f.pushReturn(f.pc)
 	f.pc = offset - 1
 }
 
+func (f *Flow) pushReturn(pc int) {
+	f.funcs = append(f.funcs, pc)
+}
+
+func (f *Flow) popReturn() int {
+	pc_len := (len(f.funcs) - 1)
+	pc := f.funcs[pc_len]
+	f.funcs = f.funcs[:pc_len]
+	return pc
+}
+
 func (f *Flow) GoTo(offset int) {
 	f.pc = offset - 1
 }
@@ -24,24 +24,51 @@
 
 package vm
 
-import (
-	"github.com/rkophs/presta/system"
-)
-
 type Heap struct {
-	heap map[int]system.StackEntry
+	heap   map[int]value
+	blocks map[int]int //First address of each allocation -> its size
+	next   int
 }
 
 func NewHeap() *Heap {
-	return &Heap{heap: make(map[int]system.StackEntry)}
+	return &Heap{heap: make(map[int]value), blocks: make(map[int]int), next: 0}
+}
+
+/* Reserve size consecutive addresses and return the first */
+func (h *Heap) Alloc(size int) int {
+	addr := h.next
+	if size > 0 {
+		h.blocks[addr] = size
+	}
+	h.next += size
+	return addr
+}
+
+/* Free every allocation whose first address is not live */
+func (h *Heap) sweep(live map[int]bool) {
+	for addr, size := range h.blocks {
+		if !live[addr] {
+			for i := 0; i < size; i++ {
+				delete(h.heap, addr+i)
+			}
+			delete(h.blocks, addr)
+		}
+	}
 }
 
-func (h *Heap) Fetch(memAddr int) system.StackEntry {
-	return h.heap[memAddr]
+/* The entry at an address, which must have been set and not released */
+func (h *Heap) Fetch(memAddr int) (value, bool) {
+	entry, ok := h.heap[memAddr]
+	return entry, ok
 }
 
-func (h *Heap) Set(memAddr int, entry system.StackEntry) {
+/* Set an entry at an allocated address */
+func (h *Heap) Set(memAddr int, entry value) bool {
+	if memAddr < 0 || memAddr >= h.next {
+		return false
+	}
 	h.heap[memAddr] = entry
+	return true
 }
 
 func (h *Heap) Release(addr int) {
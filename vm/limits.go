@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm
+
+import (
+	"context"
+	"github.com/rkophs/presta/err"
+	"strconv"
+	"time"
+)
+
+/* Bounds on a run, each zero when unbounded. Exceeding one stops the run
+ * with a LimitError whose code tells which. */
+type Limits struct {
+	Instructions int           //Instructions executed
+	CallDepth    int           //Calls in progress, tail calls not counting
+	StackSize    int           //Stack entries
+	HeapSize     int           //Heap entries in reach of the program
+	Timeout      time.Duration //Wall time
+}
+
+/* Instructions run between checks of the context, which is slower to
+ * query than the other limits */
+const CHECK_INTERVAL = 1024
+
+func (v *VM) SetLimits(limits Limits) {
+	v.limits = limits
+}
+
+/* The context of a run, bounded by the timeout */
+func (v *VM) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if v.limits.Timeout > 0 {
+		return context.WithTimeout(ctx, v.limits.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (v *VM) limit(code err.ErrorCode, msg string) {
+	v.interrupt = true
+	v.err = err.NewLimitError(code, msg)
+}
+
+/* Whether another instruction may run, stopping the run when a limit has
+ * been exceeded. Limits are only checked on the steps due for it. */
+func (v *VM) withinLimits() bool {
+	v.steps++
+	return v.steps < v.checkAt || v.checkLimits()
+}
+
+func (v *VM) checkLimits() bool {
+	l := v.limits
+	if l.Instructions > 0 && v.steps > l.Instructions {
+		v.limit(err.INSTRUCTION_LIMIT_ERROR, "Instruction limit of "+strconv.Itoa(l.Instructions)+" exceeded")
+	} else if l.CallDepth > 0 && len(v.flow.funcs)-1 > l.CallDepth {
+		v.limit(err.CALL_DEPTH_LIMIT_ERROR, "Call depth limit of "+strconv.Itoa(l.CallDepth)+" exceeded")
+	} else if l.StackSize > 0 && len(v.stack.stack) > l.StackSize {
+		v.limit(err.STACK_LIMIT_ERROR, "Stack limit of "+strconv.Itoa(l.StackSize)+" entries exceeded")
+	} else if l.HeapSize > 0 && len(v.heap.heap) > l.HeapSize && v.collect() > l.HeapSize {
+		v.limit(err.HEAP_LIMIT_ERROR, "Heap limit of "+strconv.Itoa(l.HeapSize)+" entries exceeded")
+	} else if v.steps >= v.nextContextCheck && v.ctx.Err() != nil {
+		if v.ctx.Err() == context.DeadlineExceeded {
+			v.limit(err.TIMEOUT_ERROR, "Run timed out")
+		} else {
+			v.limit(err.CANCELED_ERROR, "Run canceled")
+		}
+	} else {
+		if v.steps >= v.nextContextCheck {
+			v.nextContextCheck = v.steps + CHECK_INTERVAL
+		}
+		//The sizes are checked on every step, the rest when due
+		v.checkAt = v.nextContextCheck
+		if l.CallDepth > 0 || l.StackSize > 0 || l.HeapSize > 0 {
+			v.checkAt = v.steps + 1
+		} else if l.Instructions > 0 && l.Instructions+1 < v.checkAt {
+			v.checkAt = l.Instructions + 1
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm_test
+
+import (
+	"context"
+	"github.com/rkophs/presta/asm"
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/vm"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	LOOP    = "loop:\tgoto loop"
+	RECURSE = "call 0x2,P(0,0,0),0\nexit %0\ncall 0x2,P(0,0,0),0\nret %0"
+	PUSH    = "loop:\tpush 1\ngoto loop"
+	COUNT   = "mov %0,0\nloop:\tmov %1,%0\nlt %1,5\njmpf %1,done\nadd %0,1\ngoto loop\ndone:\texit %0"
+)
+
+func assemble(t *testing.T, src string) []ir.Instruction {
+	instrs, e := asm.Assemble(strings.NewReader(src))
+	if e != nil {
+		t.Fatalf("%q: %s", src, e.Message())
+	}
+	return instrs
+}
+
+var runs = []struct {
+	name string
+	run  func(*vm.VM, context.Context) err.Error
+}{
+	{"Run", (*vm.VM).RunContext},
+	{"Interpret", (*vm.VM).InterpretContext},
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		limits vm.Limits
+		code   err.ErrorCode
+		msg    string
+	}{
+		{"instructions", LOOP, vm.Limits{Instructions: 100}, err.INSTRUCTION_LIMIT_ERROR, "Instruction limit of 100 exceeded"},
+		{"call depth", RECURSE, vm.Limits{CallDepth: 10}, err.CALL_DEPTH_LIMIT_ERROR, "Call depth limit of 10 exceeded"},
+		{"stack size", PUSH, vm.Limits{StackSize: 50}, err.STACK_LIMIT_ERROR, "Stack limit of 50 entries exceeded"},
+		{"timeout", LOOP, vm.Limits{Timeout: 10 * time.Millisecond}, err.TIMEOUT_ERROR, "Run timed out"},
+	}
+	for _, test := range tests {
+		instrs := assemble(t, test.src)
+		for _, run := range runs {
+			v := vm.NewVM(instrs)
+			v.SetLimits(test.limits)
+			e := run.run(v, context.Background())
+			if e == nil {
+				t.Errorf("%s: %s ran past the limit", test.name, run.name)
+			} else if e.Code() != test.code || e.Message() != test.msg {
+				t.Errorf("%s: %s failed with %d %q, want %d %q", test.name, run.name, e.Code(), e.Message(), test.code, test.msg)
+			}
+		}
+	}
+}
+
+/* Runs within every limit finish as they would without them */
+func TestWithinLimits(t *testing.T) {
+	instrs := assemble(t, COUNT)
+	limits := vm.Limits{Instructions: 32, CallDepth: 1, StackSize: 1, HeapSize: 1, Timeout: time.Minute}
+	for _, run := range runs {
+		v := vm.NewVM(instrs)
+		v.SetLimits(limits)
+		if e := run.run(v, context.Background()); e != nil {
+			t.Errorf("%s: %s", run.name, e.Message())
+		} else if n, _ := v.Result().ToNumber(); n != 5 {
+			t.Errorf("%s: got %v, want 5", run.name, n)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	instrs := assemble(t, LOOP)
+	for _, run := range runs {
+		//Canceled before the run starts
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if e := run.run(vm.NewVM(instrs), ctx); e == nil || e.Code() != err.CANCELED_ERROR || e.Message() != "Run canceled" {
+			t.Errorf("%s: canceled context, got %v", run.name, e)
+		}
+
+		//Canceled while running
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		if e := run.run(vm.NewVM(instrs), ctx); e == nil || e.Code() != err.CANCELED_ERROR {
+			t.Errorf("%s: canceled while running, got %v", run.name, e)
+		}
+
+		//A deadline of the context is a timeout
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if e := run.run(vm.NewVM(instrs), ctx); e == nil || e.Code() != err.TIMEOUT_ERROR {
+			t.Errorf("%s: context deadline, got %v", run.name, e)
+		}
+		cancel()
+	}
+}
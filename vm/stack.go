@@ -24,14 +24,35 @@
 
 package vm
 
-import (
-	"github.com/rkophs/presta/system"
-)
-
+/*
+ * Calling convention
+ *
+ *	... | arg 0 | ... | arg N-1 | fn | temporaries ...
+ *	      BP-N          BP-1      BP   BP+1
+ *
+ * Argument area: the caller evaluates each argument into AX from left to
+ * right and pushes it, so the N arguments sit directly below the callee's
+ * BP and param i is read at BP-N+i.
+ *
+ * Callee frame: a call saves the caller's BP, sets BP to SP and pushes the
+ * function value being run at BP+0 so closures can reach their captured
+ * environment. Let bindings and temporaries are pushed from BP+1.
+ *
+ * Return value: the callee leaves its result in register %0 (AX). Returning
+ * truncates the stack to BP, restores the caller's BP and jumps back.
+ *
+ * Caller cleanup: after the call the caller shrinks the stack back to where
+ * it was before the arguments were pushed. Every expression leaves the stack
+ * as it found it and its value in AX, so the arguments are contiguous.
+ *
+ * A tail call moves the new arguments over the argument area of the running
+ * frame and reuses it, keeping the saved BP and return address, so the
+ * callee returns straight to the original caller which cleans up as usual.
+ */
 type Stack struct {
 	bp     int
 	sp     int
-	stack  []system.StackEntry
+	stack  []value
 	frames []int
 }
 
@@ -39,31 +60,45 @@ func NewStack() *Stack {
 	return &Stack{
 		bp:     0,
 		sp:     0,
-		stack:  []system.StackEntry{},
+		stack:  []value{},
 		frames: []int{0},
 	}
 }
 
-func (s *Stack) Push(entry system.StackEntry) {
+func (s *Stack) Push(entry value) {
 	s.stack = append(s.stack, entry)
 	s.sp++
 }
 
-func (s *Stack) Pop() system.StackEntry {
+func (s *Stack) Pop() value {
 	s.sp--
 	ret := s.stack[s.sp]
 	s.stack = s.stack[:s.sp]
 	return ret
 }
 
-func (s *Stack) Set(offset int, entry system.StackEntry) {
+/* Whether BP+offset addresses an entry on the stack */
+func (s *Stack) holds(offset int) bool {
+	return s.bp+offset >= 0 && s.bp+offset < s.sp
+}
+
+func (s *Stack) Set(offset int, entry value) bool {
+	if !s.holds(offset) {
+		return false
+	}
 	s.stack[s.bp+offset] = entry
+	return true
 }
 
-func (s *Stack) Fetch(offset int) system.StackEntry {
-	return s.stack[s.bp+offset]
+func (s *Stack) Fetch(offset int) (value, bool) {
+	if !s.holds(offset) {
+		return value{}, false
+	}
+	return s.stack[s.bp+offset], true
 }
 
+/* Discard the frame and restore the caller's BP. The argument area is
+ * left for the caller to clean up. */
 func (s *Stack) PopFrame() {
 	s.stack = s.stack[:s.bp]
 	s.sp = s.bp
@@ -73,7 +108,36 @@ func (s *Stack) PopFrame() {
 	s.frames = s.frames[:frame_len]
 }
 
+/* Save the caller's BP and start a frame at the top of the stack */
 func (s *Stack) PushFrame() {
 	s.frames = append(s.frames, s.bp)
 	s.bp = s.sp
 }
+
+/* Drop every entry at or above BP+offset, which must be on the stack or
+ * just above it */
+func (s *Stack) Shrink(offset int) bool {
+	if s.bp+offset < 0 || s.bp+offset > s.sp {
+		return false
+	}
+	s.sp = s.bp + offset
+	s.stack = s.stack[:s.sp]
+	return true
+}
+
+/* Replace the frameArgs arguments of the current frame with the argc
+ * entries on top of the stack and start a fresh frame above them. The
+ * caller's saved BP is kept so the callee returns straight to it. Fails
+ * when the frame or the stack holds fewer entries. */
+func (s *Stack) ReuseFrame(argc, frameArgs int) bool {
+	start := s.bp - frameArgs
+	if start < 0 || argc > s.sp-s.bp {
+		return false
+	}
+	copy(s.stack[start:], s.stack[s.sp-argc:s.sp])
+
+	s.stack = s.stack[:start+argc]
+	s.sp = len(s.stack)
+	s.bp = s.sp
+	return true
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm
+
+import (
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/system"
+)
+
+/* A slot of the stack, heap or register file. Numbers are held unboxed
+ * so arithmetic does not allocate, keeping the entry they came from, if
+ * any, so it is only boxed again when needed. Any other entry is held in
+ * entry. */
+type value struct {
+	number bool
+	num    float64
+	entry  system.StackEntry
+}
+
+var undefined = system.NewUndefined()
+
+func numberValue(n float64) value {
+	return value{number: true, num: n}
+}
+
+func boolValue(b bool) value {
+	if b {
+		return numberValue(1)
+	}
+	return numberValue(0)
+}
+
+func unbox(entry system.StackEntry) value {
+	if n, ok := entry.(*system.Number); ok {
+		num, _ := n.ToNumber()
+		return value{number: true, num: num, entry: n}
+	}
+	return value{entry: entry}
+}
+
+/* The entry of a value. A slot nothing has written holds undefined */
+func (v value) box() system.StackEntry {
+	if v.entry != nil {
+		return v.entry
+	} else if v.number {
+		return system.NewNumber(v.num)
+	}
+	return undefined
+}
+
+func (v value) truthy() bool {
+	if v.number {
+		return v.num != 0
+	}
+	return ir.Truthy(v.entry)
+}
+
+func (v value) typeName() string {
+	if v.number {
+		return "num"
+	}
+	return system.TypeName(v.entry)
+}
+
+func (v value) equal(o value) bool {
+	if v.number || o.number {
+		return v.number && o.number && v.num == o.num
+	}
+	return ir.Equal(v.entry, o.entry)
+}
@@ -25,53 +25,141 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"github.com/rkophs/presta/err"
 	"github.com/rkophs/presta/ir"
 	"github.com/rkophs/presta/system"
+	"strconv"
 )
 
 type VM struct {
-	stack     *Stack
-	heap      *Heap
-	flow      *Flow
-	registers []system.StackEntry
-	exited    bool
-	err       err.Error
-	interrupt bool
+	stack            *Stack
+	heap             *Heap
+	flow             *Flow
+	registers        []value
+	saved            []value //Temporary registers of each caller, one after another
+	constants        []value //Constants of the dispatched program
+	exited           bool
+	err              err.Error
+	interrupt        bool
+	trace            bool
+	limits           Limits
+	host             *system.Host
+	steps            int //Instructions run
+	checkAt          int //Step on which limits are next checked
+	nextContextCheck int //Step on which the context is next checked
+	nextCollect      int //Heap entries at which the heap is next collected
+	ctx              context.Context
 }
 
+/* A VM with the default register file, or a larger one if the program
+ * needs it */
 func NewVM(instructions []ir.Instruction) *VM {
+	registers := ir.REGISTERS
+	if needed := ir.Registers(instructions); needed > registers {
+		registers = needed
+	}
+	return NewVMWithRegisters(instructions, registers)
+}
+
+/* A VM with the register file the program was compiled for */
+func NewVMForProgram(program *ir.Program) *VM {
+	registers := program.RegisterCount()
+	if needed := ir.Registers(program.Instructions); needed > registers {
+		registers = needed
+	}
+	return NewVMWithRegisters(program.Instructions, registers)
+}
+
+/* A VM with a register file of the given size, which must be at least
+ * the size the program was compiled for */
+func NewVMWithRegisters(instructions []ir.Instruction, registers int) *VM {
 	return &VM{
-		flow:      NewFlow(instructions),
-		stack:     NewStack(),
-		heap:      NewHeap(),
-		interrupt: false,
-		err:       nil,
-		registers: make([]system.StackEntry, 1),
-		exited:    false,
+		flow:        NewFlow(instructions),
+		stack:       NewStack(),
+		heap:        NewHeap(),
+		interrupt:   false,
+		err:         nil,
+		registers:   make([]value, registers),
+		saved:       []value{},
+		exited:      false,
+		nextCollect: COLLECT_THRESHOLD,
 	}
 }
 
+/* Print the state of the VM before every instruction. Tracing steps
+ * through the instructions one at a time. */
+func (v *VM) SetTrace(trace bool) {
+	v.trace = trace
+}
+
 func (v *VM) Run() err.Error {
-	for !v.exited && !v.interrupt {
-		v.Print()
+	return v.RunContext(context.Background())
+}
+
+/* Run the program in the dispatch loop, or stepping through it when
+ * tracing, until it exits, fails, exceeds a limit or ctx is done */
+func (v *VM) RunContext(ctx context.Context) err.Error {
+	if v.trace {
+		return v.InterpretContext(ctx)
+	}
+	flat, e := ir.Flatten(v.flow.instr)
+	if e != nil {
+		return e
+	}
+	ctx, cancel := v.bound(ctx)
+	defer cancel()
+	v.ctx = ctx
+	v.dispatch(flat)
+	return v.err
+}
+
+func (v *VM) Interpret() err.Error {
+	return v.InterpretContext(context.Background())
+}
+
+/* Run the program by executing each instruction against the System
+ * interface, once validated as for the dispatch loop */
+func (v *VM) InterpretContext(ctx context.Context) err.Error {
+	if e := ir.Validate(v.flow.instr); e != nil {
+		return e
+	}
+	ctx, cancel := v.bound(ctx)
+	defer cancel()
+	v.ctx = ctx
+	for !v.exited && !v.interrupt && v.withinLimits() {
+		if v.trace {
+			v.Print()
+		}
 		v.flow.Execute(v)
 	}
-	fmt.Println("Complete:")
-	v.Print()
+	if v.trace {
+		fmt.Println("Complete:")
+		v.Print()
+	}
 	return v.err
 }
 
+/* Make the functions of a host callable by the program */
+func (v *VM) SetHost(host *system.Host) {
+	v.host = host
+}
+
+/* The value left in AX */
+func (v *VM) Result() system.StackEntry {
+	return v.registers[0].box()
+}
+
 func (v *VM) Push(a system.StackEntry) {
-	v.stack.Push(a)
+	v.stack.Push(unbox(a))
 }
 
 func (v *VM) Print() {
 	fmt.Println("============")
 	var s string
-	if len(v.registers) > 0 && v.registers[0] != nil {
-		if k, e := v.registers[0].ToString(); e != nil {
+	if len(v.registers) > 0 && v.Result() != nil {
+		if k, e := v.Result().ToString(); e != nil {
 			s = ""
 		} else {
 			s = k
@@ -82,40 +170,82 @@ func (v *VM) Print() {
 	fmt.Println("PC: ", v.flow.pc, " BP: ", v.stack.bp, " SP: ", v.stack.sp, " AX: ", s)
 	fmt.Println("Stack:")
 	for i, v := range v.stack.stack {
-		s, _ := v.ToString()
+		s, _ := v.box().ToString()
 		fmt.Println(i, " ", s)
 	}
 	fmt.Println("Heap:")
 	for k, v := range v.heap.heap {
-		s, _ := v.ToString()
+		s, _ := v.box().ToString()
 		fmt.Println(k, " ", s)
 	}
 	fmt.Println("============")
 
 }
 
+/* Stack and heap accesses outside what the run has set up fail the run,
+ * reading as undefined */
+func (v *VM) fetchS(offset int) value {
+	if x, ok := v.stack.Fetch(offset); ok {
+		return x
+	}
+	v.SetError("Stack offset " + strconv.Itoa(offset) + " is outside the stack")
+	return value{entry: undefined}
+}
+
+func (v *VM) setS(offset int, x value) {
+	if !v.stack.Set(offset, x) {
+		v.SetError("Stack offset " + strconv.Itoa(offset) + " is outside the stack")
+	}
+}
+
+func (v *VM) fetchM(memAddr int) value {
+	if x, ok := v.heap.Fetch(memAddr); ok {
+		return x
+	}
+	v.SetError("Memory address " + strconv.Itoa(memAddr) + " is not allocated")
+	return value{entry: undefined}
+}
+
+func (v *VM) setM(memAddr int, x value) {
+	if !v.heap.Set(memAddr, x) {
+		v.SetError("Memory address " + strconv.Itoa(memAddr) + " is not allocated")
+	}
+}
+
 func (v *VM) FetchS(offset int) system.StackEntry {
-	return v.stack.Fetch(offset)
+	return v.fetchS(offset).box()
 }
 
 func (v *VM) FetchM(memAddr int) system.StackEntry {
-	return v.heap.Fetch(memAddr)
+	return v.fetchM(memAddr).box()
 }
 
 func (v *VM) FetchR(id int) system.StackEntry {
-	return v.registers[id]
+	if id >= len(v.registers) {
+		v.SetError("Register %" + strconv.Itoa(id) + " is outside the register file")
+		return undefined
+	}
+	return v.registers[id].box()
 }
 
 func (v *VM) SetS(offset int, entry system.StackEntry) {
-	v.stack.Set(offset, entry)
+	v.setS(offset, unbox(entry))
 }
 
 func (v *VM) SetM(memAddr int, entry system.StackEntry) {
-	v.heap.Set(memAddr, entry)
+	v.setM(memAddr, unbox(entry))
 }
 
 func (v *VM) SetR(id int, entry system.StackEntry) {
-	v.registers[id] = entry
+	if id >= len(v.registers) {
+		v.SetError("Register %" + strconv.Itoa(id) + " is outside the register file")
+		return
+	}
+	v.registers[id] = unbox(entry)
+}
+
+func (v *VM) Alloc(size int) int {
+	return v.alloc(size)
 }
 
 func (v *VM) Release(addr int) {
@@ -123,15 +253,28 @@ func (v *VM) Release(addr int) {
 }
 
 func (v *VM) Return(result system.StackEntry) {
-	v.stack.PopFrame()
+	if len(v.flow.funcs) == 1 {
+		v.SetError("Return outside of a function")
+		return
+	}
+	v.leave(unbox(result))
 	v.flow.Return()
-	v.registers[0] = result
 }
 
 func (v *VM) Exit(result system.StackEntry) {
+	v.exit(unbox(result))
+	v.flow.Return()
+}
+
+func (v *VM) leave(result value) {
+	v.stack.PopFrame()
+	v.restoreRegisters()
+	v.registers[0] = result
+}
+
+func (v *VM) exit(result value) {
 	v.exited = true
 	v.stack.PopFrame()
-	v.flow.Return()
 	v.registers[0] = result
 }
 
@@ -140,9 +283,126 @@ func (v *VM) SetError(e string) {
 	v.err = err.NewRuntimeError(e)
 }
 
-func (v *VM) Call(offset int) {
-	v.flow.Call(offset)
+func (v *VM) Call(fn *system.Function, argc int) {
+	if v.enter(fn, argc) {
+		v.flow.Call(fn.Offset())
+	}
+}
+
+/* The callee frame keeps the function being run at BP+0 so closures can
+ * reach their captured environment */
+func (v *VM) enter(fn *system.Function, argc int) bool {
+	if !v.bindArgs(fn, argc) {
+		return false
+	}
+	v.saveRegisters()
 	v.stack.PushFrame()
+	v.stack.Push(value{entry: fn})
+	return true
+}
+
+/* Temporaries are caller saved: the VM keeps the caller's registers while
+ * the callee runs. A tail call keeps the saved registers of the frame it
+ * replaces. */
+func (v *VM) saveRegisters() {
+	v.saved = append(v.saved, v.registers[1:]...)
+}
+
+func (v *VM) restoreRegisters() {
+	last := len(v.saved) - (len(v.registers) - 1)
+	copy(v.registers[1:], v.saved[last:])
+	v.saved = v.saved[:last]
+}
+
+func (v *VM) TailCall(fn *system.Function, argc int, frameArgs int) {
+	if v.reenter(fn, argc, frameArgs) {
+		v.flow.GoTo(fn.Offset())
+	}
+}
+
+func (v *VM) reenter(fn *system.Function, argc int, frameArgs int) bool {
+	if !v.bindArgs(fn, argc) {
+		return false
+	}
+	if !v.stack.ReuseFrame(fn.Params().Count(), frameArgs) {
+		v.SetError("Tail call replaces more arguments than the frame holds")
+		return false
+	}
+	v.stack.Push(value{entry: fn})
+	return true
+}
+
+/* Call a host function with the argc arguments on top of the stack. Its
+ * errors and panics become runtime errors and a nil result is undefined. */
+func (v *VM) CallHost(name string, argc int) {
+	fn, ok := v.host.Lookup(name)
+	if argc > v.stack.sp {
+		v.SetError("Call has more arguments than the stack holds")
+		return
+	} else if !ok {
+		v.SetError("Host function '" + name + "' is not registered")
+		return
+	} else if params := fn.Params(); !params.Accepts(argc) {
+		v.SetError("Function '" + name + "' expects " + params.Describe() + ", got " + strconv.Itoa(argc))
+		return
+	}
+
+	args := make([]system.Value, argc)
+	top := len(v.stack.stack) - argc
+	for i := range args {
+		args[i] = v.stack.stack[top+i].box()
+	}
+	result, e := callHost(fn, args)
+	if e != nil {
+		v.SetError(name + ": " + e.Error())
+		return
+	} else if result == nil {
+		result = undefined
+	}
+	v.registers[0] = unbox(result)
+}
+
+func callHost(fn *system.HostFunction, args []system.Value) (result system.Value, e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, e = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn.Call(args)
+}
+
+/* Shape the argc arguments on top of the stack into the argument area the
+ * function expects: missing optional args are left undefined for the
+ * callee to default and extra args are collected into the rest list. */
+func (v *VM) bindArgs(fn *system.Function, argc int) bool {
+	params := fn.Params()
+	if argc > v.stack.sp {
+		v.SetError("Call has more arguments than the stack holds")
+		return false
+	} else if !params.Accepts(argc) {
+		v.SetError("Function expects " + params.Describe() + ", got " + strconv.Itoa(argc))
+		return false
+	}
+
+	fixed := params.Required + params.Optional
+	for ; argc < fixed; argc++ {
+		v.stack.Push(value{entry: undefined})
+	}
+
+	if params.Rest {
+		rest := make([]system.StackEntry, argc-fixed)
+		for i := len(rest) - 1; i >= 0; i-- {
+			rest[i] = v.stack.Pop().box()
+		}
+		v.stack.Push(value{entry: system.NewList(rest)})
+	}
+	return true
+}
+
+func (v *VM) Shrink(offset int) {
+	if !v.stack.Shrink(offset) {
+		v.SetError("Stack offset " + strconv.Itoa(offset) + " is outside the stack")
+	}
 }
 
 func (v *VM) Goto(offset int) {
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2016 Ryan Kophs
+ *
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to
+ * deal in the Software without restriction, including without limitation the
+ * rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+ * sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ **/
+
+package vm_test
+
+import (
+	"github.com/rkophs/presta/err"
+	"github.com/rkophs/presta/ir"
+	"github.com/rkophs/presta/system"
+	"github.com/rkophs/presta/vm"
+	"strings"
+	"testing"
+)
+
+/* Run instructions through the dispatch loop and the interpreter, which
+ * must fail the same way */
+func runBoth(t *testing.T, name string, instrs []ir.Instruction) err.Error {
+	run, interpret := vm.NewVM(instrs).Run(), vm.NewVM(instrs).Interpret()
+	if (run == nil) != (interpret == nil) {
+		t.Fatalf("%s: Run returned %v, Interpret %v", name, run, interpret)
+	} else if run != nil && (run.Code() != interpret.Code() || run.Message() != interpret.Message()) {
+		t.Fatalf("%s: Run failed with %q, Interpret with %q", name, run.Message(), interpret.Message())
+	}
+	return run
+}
+
+func TestOutOfBoundsAccessesFail(t *testing.T) {
+	ax, exit := ir.NewRegisterAccess(0), ir.NewExit(ir.NewRegisterAccess(0))
+	tests := []struct {
+		name   string
+		instrs []ir.Instruction
+		msg    string
+	}{
+		{"stack below", []ir.Instruction{ir.NewPush(ir.NewStackAccess(-5)), exit}, "Stack offset -5"},
+		{"stack above", []ir.Instruction{ir.NewMov(ir.NewStackAccess(2), ax), exit}, "Stack offset 2"},
+		{"shrink", []ir.Instruction{ir.NewShrink(3), exit}, "Stack offset 3"},
+		{"unallocated read", []ir.Instruction{ir.NewMov(ax, ir.NewMemoryAccess(3)), exit}, "Memory address 3"},
+		{"unallocated write", []ir.Instruction{ir.NewMov(ir.NewMemoryAccess(3), ax), exit}, "Memory address 3"},
+		{"released", []ir.Instruction{
+			ir.NewClosure(ax, ir.NewInstructionLocation(0), system.Params{}, ax),
+			ir.NewRelease(ir.NewMemoryAccess(0)),
+			ir.NewMov(ax, ir.NewMemoryAccess(0)),
+			exit,
+		}, "Memory address 0"},
+		{"tail call", []ir.Instruction{ir.NewTailCall(ir.NewInstructionLocation(1), system.Params{}, 0, 2), exit}, "Tail call"},
+		{"arguments", []ir.Instruction{ir.NewCall(ir.NewInstructionLocation(1), system.Params{}, 4), exit}, "more arguments"},
+	}
+	for _, test := range tests {
+		e := runBoth(t, test.name, test.instrs)
+		if e == nil {
+			t.Errorf("%s: ran without error", test.name)
+		} else if e.Code() != err.RUNTIME_ERROR || !strings.Contains(e.Message(), test.msg) {
+			t.Errorf("%s: got %q, want a runtime error containing %q", test.name, e.Message(), test.msg)
+		}
+	}
+}